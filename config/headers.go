@@ -0,0 +1,24 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ParseHeaders parses entries like "Authorization=Bearer token" into a header,
+// errors never contain the values since they usually are credentials
+func ParseHeaders(entries []string) (http.Header, error) {
+	header := http.Header{}
+	for i, entry := range entries {
+		name, value := entry, ""
+		if j := strings.Index(entry, "="); j != -1 {
+			name, value = strings.TrimSpace(entry[:j]), strings.TrimSpace(entry[j+1:])
+		}
+		if name == "" || name == entry || strings.ContainsAny(name, " \t:") {
+			return nil, fmt.Errorf("header %d must look like \"Authorization=Bearer token\"", i)
+		}
+		header.Add(name, value)
+	}
+	return header, nil
+}
@@ -1,49 +1,233 @@
 package config
 
-import (
-	"encoding/json"
-	"log"
-	"os"
-)
+import "os"
 
 type (
 	Config struct {
-		Http           httpConfig     `json:"http"`
-		DatabaseConfig databaseConfig `json:"database"`
+		Http           httpConfig        `json:"http"`
+		DatabaseConfig databaseConfig    `json:"database"`
+		Log            logConfig         `json:"log"`
+		Health         healthConfig      `json:"health"`
+		Admin          adminConfig       `json:"admin"`
+		Tracing        tracingConfig     `json:"tracing"`
+		RateLimit      rateLimitConfig   `json:"rate_limit"`
+		Cors           corsConfig        `json:"cors"`
+		Compression    compressionConfig `json:"compression"`
+		Images         imagesConfig      `json:"images"`
+		UI             uiConfig          `json:"ui"`
+		Events         eventsConfig      `json:"events"`
 	}
 	httpConfig struct {
-		Port string `json:"port"`
+		Port string `json:"port" validate:"required,port" reload:"restart"`
+		// IdempotencyTTL is how long responses of requests with an Idempotency-Key are replayed, "0" keeps them
+		IdempotencyTTL string `json:"idempotency_ttl" validate:"duration"`
+		// RequestTimeout bounds the handling of a request, requests exceeding it get 504, "0" disables it
+		RequestTimeout string `json:"request_timeout" validate:"duration"`
+		// RouteTimeouts overrides RequestTimeout per route, like "POST /v1/toys/_bulk=2m", "=0" disables it
+		RouteTimeouts []string `json:"route_timeouts" validate:"routetimeouts"`
+		// MaxBodySize limits request bodies, like "10MB", larger bodies get 413, "0" disables it
+		MaxBodySize string `json:"max_body_size" validate:"required,size"`
+		// RouteBodyLimits overrides MaxBodySize per route, like "POST /v1/toys/_bulk=50MB"
+		RouteBodyLimits []string `json:"route_body_limits" validate:"routesizes"`
+		// MaxHeaderSize limits the request line and headers, larger ones get 431
+		MaxHeaderSize string `json:"max_header_size" validate:"required,size" reload:"restart"`
+		// ReadHeaderTimeout bounds reading the request headers, empty or "0" disables it
+		ReadHeaderTimeout string `json:"read_header_timeout" validate:"duration" reload:"restart"`
+		// ReadTimeout bounds reading the whole request, empty or "0" disables it
+		ReadTimeout string `json:"read_timeout" validate:"duration" reload:"restart"`
+		// WriteTimeout bounds the time from the end of the request headers to the end of the response,
+		// it should exceed the route timeouts, empty or "0" disables it
+		WriteTimeout string `json:"write_timeout" validate:"duration" reload:"restart"`
+		// IdleTimeout bounds waiting for the next request on a keep-alive connection, empty or "0" disables it
+		IdleTimeout string `json:"idle_timeout" validate:"duration" reload:"restart"`
+	}
+	logConfig struct {
+		// Level is the lowest level written: debug, info, warn or error
+		Level string `json:"level" validate:"oneof=debug|info|warn|error"`
+		// Format is the encoding of log lines: json or logfmt
+		Format string `json:"format" validate:"oneof=json|logfmt" reload:"restart"`
+	}
+	healthConfig struct {
+		// MinFreeDiskMB is the free space of the database disk below which the server is not ready
+		MinFreeDiskMB int `json:"min_free_disk_mb" validate:"min=0"`
+		// MaxWriteLag is how long database writes may fail before the server is not ready, zero disables the check
+		MaxWriteLag string `json:"max_write_lag" validate:"duration"`
+	}
+	adminConfig struct {
+		// Address is the listen address of the admin-only listener serving diagnostics, empty disables it
+		Address string `json:"address" validate:"hostport" reload:"restart"`
+		// Pprof mounts the profiler under /debug/pprof/ on the admin listener
+		Pprof bool `json:"pprof" reload:"restart"`
+	}
+	tracingConfig struct {
+		// Exporter sends spans nowhere, to a json lines file or to an OTLP/HTTP collector: none, file or otlp
+		Exporter string `json:"exporter" validate:"oneof=none|file|otlp" reload:"restart"`
+		// File is the json lines file of the file exporter
+		File string `json:"file" validate:"filedir" reload:"restart"`
+		// Endpoint is the traces url of the OTLP/HTTP collector
+		Endpoint string `json:"endpoint" reload:"restart"`
+		// Headers are sent with every request to the collector, like "Authorization=Bearer token"
+		Headers []string `json:"headers" validate:"headers" secret:"true" reload:"restart"`
+		// ServiceName names the server in traces
+		ServiceName string `json:"service_name" reload:"restart"`
+		// SampleRatio is the share of new traces which are recorded, between 0 and 1
+		SampleRatio float64 `json:"sample_ratio" validate:"min=0,max=1" reload:"restart"`
+	}
+	uiConfig struct {
+		// Prefix is the path the admin ui is served under, empty disables it
+		Prefix string `json:"prefix" reload:"restart"`
+		// Dir serves the admin ui from a directory instead of the files built into the binary
+		Dir string `json:"dir" validate:"dir" reload:"restart"`
+		// SPA serves index.html for unknown paths without an extension
+		SPA bool `json:"spa" reload:"restart"`
+		// MaxAge is how long browsers cache files other than index.html
+		MaxAge string `json:"max_age" validate:"duration" reload:"restart"`
+	}
+	eventsConfig struct {
+		// Heartbeat is the interval of comments keeping idle event streams open, empty or "0" disables them
+		Heartbeat string `json:"heartbeat" validate:"duration" reload:"restart"`
+		// Retry is the reconnection delay sent to clients of event streams
+		Retry string `json:"retry" validate:"duration" reload:"restart"`
+	}
+	imagesConfig struct {
+		// Dir holds the image files, it is created when it does not exist
+		Dir string `json:"dir" validate:"required" reload:"restart"`
+		// MaxSize limits one image, like "5MB"
+		MaxSize string `json:"max_size" validate:"required,size" reload:"restart"`
+		// AllowedTypes are the accepted types sniffed from the content of images
+		AllowedTypes []string `json:"allowed_types" reload:"restart"`
+	}
+	compressionConfig struct {
+		// Enabled compresses responses with gzip or deflate when clients accept it
+		Enabled bool `json:"enabled" reload:"restart"`
+		// MinSize is the smallest response body in bytes which is compressed
+		MinSize int `json:"min_size" validate:"min=0" reload:"restart"`
+		// ContentTypes are the compressed media types, "text/*" matches all text types
+		ContentTypes []string `json:"content_types" reload:"restart"`
+		// Level trades speed (1) for size (9)
+		Level int `json:"level" validate:"min=1,max=9" reload:"restart"`
+	}
+	corsConfig struct {
+		// AllowedOrigins are exact origins, wildcard subdomains like "https://*.example.com",
+		// "regex:" patterns or "*", none disables cors
+		AllowedOrigins []string `json:"allowed_origins" validate:"origins"`
+		// AllowedMethods may be used by cross-origin requests
+		AllowedMethods []string `json:"allowed_methods"`
+		// AllowedHeaders may be sent by cross-origin requests, "*" allows any header
+		AllowedHeaders []string `json:"allowed_headers"`
+		// ExposedHeaders of responses may be read by scripts
+		ExposedHeaders []string `json:"exposed_headers"`
+		// AllowCredentials lets browsers send cookies and authorization headers
+		AllowCredentials bool `json:"allow_credentials"`
+		// MaxAge is how long browsers may cache preflights
+		MaxAge string `json:"max_age" validate:"duration"`
+	}
+	rateLimitConfig struct {
+		// Enabled counts requests against the limits
+		Enabled bool `json:"enabled" reload:"restart"`
+		// Algorithm is token_bucket or sliding_window
+		Algorithm string `json:"algorithm" validate:"oneof=token_bucket|sliding_window" reload:"restart"`
+		// Key identifies clients by ip, api_key or jwt_sub, requests without one are counted by ip
+		Key string `json:"key" validate:"oneof=ip|api_key|jwt_sub" reload:"restart"`
+		// APIKeyHeader is the request header holding the api key
+		APIKeyHeader string `json:"api_key_header" reload:"restart"`
+		// Store keeps counters in memory, or in the database so they survive restarts
+		Store string `json:"store" validate:"oneof=memory|database" reload:"restart"`
+		// Default is the limit of routes without their own, like "100/1m", empty for no limit
+		Default string `json:"default" validate:"ratelimit"`
+		// Routes overrides Default per route, like "POST /v1/toys/_bulk=10/1m" or "GET /healthz=off"
+		Routes []string `json:"routes" validate:"routelimits"`
 	}
 	databaseConfig struct {
-		BucketName string `json:"bucket_name"`
+		BucketName string `json:"bucket_name" validate:"required,filedir" reload:"restart"`
+		// ChangeLogRetention is the number of product changes kept in the change log, zero keeps all of them.
+		// The log is part of the database file, so every write rewrites the kept changes too
+		ChangeLogRetention int `json:"change_log_retention" validate:"min=0" reload:"restart"`
 	}
 )
 
+// AppConf is the configuration loaded at startup, a Watcher holds the reloaded one
 var AppConf Config
 
-// TODO : read config from json
-func Init() {
-	wd, err := os.Getwd()
-	if err != nil {
-		panic(err)
+// Defaults returns the configuration used for settings missing from every layer
+func Defaults() Config {
+	return Config{
+		Http: httpConfig{
+			Port:              "8080",
+			IdempotencyTTL:    "24h",
+			RequestTimeout:    "30s",
+			RouteTimeouts:     []string{"GET /v1/toys/events=0", "GET /v1/toys/ws=0"},
+			MaxBodySize:       "10MB",
+			MaxHeaderSize:     "1MB",
+			ReadHeaderTimeout: "5s",
+			ReadTimeout:       "1m",
+			WriteTimeout:      "3m",
+			IdleTimeout:       "2m",
+		},
+		DatabaseConfig: databaseConfig{
+			BucketName:         "database.json",
+			ChangeLogRetention: 1000,
+		},
+		Log: logConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		Health: healthConfig{
+			MinFreeDiskMB: 64,
+			MaxWriteLag:   "30s",
+		},
+		Admin: adminConfig{
+			Address: "127.0.0.1:6060",
+		},
+		Tracing: tracingConfig{
+			Exporter:    "none",
+			File:        "traces.jsonl",
+			Endpoint:    "http://localhost:4318/v1/traces",
+			ServiceName: "pure-webserver",
+			SampleRatio: 1,
+		},
+		RateLimit: rateLimitConfig{
+			Algorithm:    "token_bucket",
+			Key:          "ip",
+			APIKeyHeader: "X-API-Key",
+			Store:        "memory",
+		},
+		UI: uiConfig{
+			Prefix: "/admin",
+			SPA:    true,
+			MaxAge: "1h",
+		},
+		Images: imagesConfig{
+			Dir:          "images",
+			MaxSize:      "5MB",
+			AllowedTypes: []string{"image/jpeg", "image/png", "image/gif", "image/webp"},
+		},
+		Events: eventsConfig{
+			Heartbeat: "15s",
+			Retry:     "3s",
+		},
+		Compression: compressionConfig{
+			Enabled:      true,
+			MinSize:      1024,
+			ContentTypes: []string{"application/json", "application/xml", "application/x-ndjson", "text/*"},
+			Level:        6,
+		},
+		Cors: corsConfig{
+			AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders: []string{"Content-Type", "Authorization", "If-Match", "If-None-Match", "Idempotency-Key", "X-Request-ID", "X-API-Key"},
+			ExposedHeaders: []string{"ETag", "X-Request-ID", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+			MaxAge:         "10m",
+		},
 	}
-	// read config file
-	file, err := os.ReadFile(wd + "/config/config.json")
+}
+
+// Init loads the layered configuration from defaults, config file, environment and command line args
+// into AppConf, see Load for the precedence of layers
+func Init(args []string) (*Effective, error) {
+	effective, err := Load(args, os.LookupEnv)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	err = json.Unmarshal(file, &AppConf)
-	if err != nil {
-		log.Fatal(err)
-	}
-	AppConf.DatabaseConfig.BucketName = wd + "/" + AppConf.DatabaseConfig.BucketName
-	// hc := httpConfig{
-	// 	Port: "8080",
-	// }
-	// dc := databaseConfig{
-	// 	BucketName: wd + "/database.json",
-	// }
-	// log.Print(wd + "/database.json")
-	// AppConf.Http = hc
-	// AppConf.DatabaseConfig = dc
+	AppConf = effective.Config
+	return effective, nil
 }
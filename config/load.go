@@ -0,0 +1,300 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Source is the layer which set the effective value of a setting
+	Source string
+	// Effective is the configuration loaded from every layer
+	Effective struct {
+		Config Config
+		// Sources maps every setting key (like "http.port") to the layer which set it
+		Sources map[string]Source
+		// File is the loaded config file, empty when there is none
+		File string
+		// PrintConfig is set by --print-config to dump the configuration instead of starting the server
+		PrintConfig bool
+		// Args are the command line args left after flags
+		Args []string
+	}
+	// setting is one leaf field of Config
+	setting struct {
+		// Key is the dotted json path of the field, like "http.port"
+		Key string
+		// Env is the environment variable overriding the field, like "PWS_HTTP_PORT"
+		Env string
+		// Flag is the command line flag overriding the field, like "http-port"
+		Flag  string
+		Value reflect.Value
+		// Secret fields (tagged `secret:"true"`) are redacted when printed
+		Secret bool
+		// Rules are the comma separated checks of the `validate` tag, see Validate
+		Rules string
+		// Reload is "restart" for settings which can not change while the server runs
+		Reload string
+	}
+	// LookupEnv returns the value of an environment variable, os.LookupEnv in production
+	LookupEnv func(key string) (string, bool)
+)
+
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceEnv     Source = "env"
+	SourceFlag    Source = "flag"
+
+	// EnvPrefix prefixes the environment variables of every setting
+	EnvPrefix = "PWS_"
+	// ConfigEnv holds the config file path when --config is not given
+	ConfigEnv = EnvPrefix + "CONFIG"
+	// DefaultFile is the config file read when neither --config nor PWS_CONFIG are given
+	DefaultFile = "config/config.json"
+	// redacted replaces the value of secret settings when printed
+	redacted = "[redacted]"
+)
+
+// Load builds the configuration from, in increasing precedence, defaults, the config file
+// (--config, PWS_CONFIG or config/config.json when it exists), environment variables
+// (PWS_HTTP_PORT for http.port) and command line flags (--http-port for http.port).
+// Every problem of the result is reported at once as ValidationErrors
+func Load(args []string, lookupEnv LookupEnv) (*Effective, error) {
+	effective := &Effective{
+		Config:  Defaults(),
+		Sources: map[string]Source{},
+	}
+	settings := walkSettings(&effective.Config)
+	for _, s := range settings {
+		effective.Sources[s.Key] = SourceDefault
+	}
+
+	flags := flag.NewFlagSet("pure-webserver", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	configFile := flags.String("config", "", "path of the json config file (env "+ConfigEnv+")")
+	flags.BoolVar(&effective.PrintConfig, "print-config", false, "print the effective configuration and exit")
+	flagValues := map[string]*string{}
+	for _, s := range settings {
+		flagValues[s.Flag] = flags.String(s.Flag, "", "overrides "+s.Key+" (env "+s.Env+")")
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			flags.SetOutput(os.Stderr)
+			flags.PrintDefaults()
+		}
+		return nil, err
+	}
+	effective.Args = flags.Args()
+
+	// file layer
+	path, required := *configFile, true
+	if path == "" {
+		path, required = lookupEnv(ConfigEnv)
+	}
+	if path == "" {
+		path, required = DefaultFile, false
+	}
+	problems, err := effective.loadFile(path, required)
+	if err != nil {
+		return nil, err
+	}
+
+	// environment layer
+	for _, s := range settings {
+		value, ok := lookupEnv(s.Env)
+		if !ok {
+			continue
+		}
+		if err := setValue(s.Value, value); err != nil {
+			problems = append(problems, ValidationError{Path: jsonPath(s.Key), Message: fmt.Sprintf("%v (from env %s)", err, s.Env)})
+		}
+		effective.Sources[s.Key] = SourceEnv
+	}
+
+	// command line layer
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.Flag != f.Name {
+				continue
+			}
+			if err := setValue(s.Value, *flagValues[s.Flag]); err != nil {
+				problems = append(problems, ValidationError{Path: jsonPath(s.Key), Message: fmt.Sprintf("%v (from flag --%s)", err, s.Flag)})
+			}
+			effective.Sources[s.Key] = SourceFlag
+		}
+	})
+
+	if effective.Config.DatabaseConfig.BucketName != "" {
+		bucketName, err := filepath.Abs(effective.Config.DatabaseConfig.BucketName)
+		if err != nil {
+			return nil, err
+		}
+		effective.Config.DatabaseConfig.BucketName = bucketName
+	}
+	problems = append(problems, Validate(&effective.Config, effective.Sources)...)
+	if len(problems) != 0 {
+		return nil, problems
+	}
+	return effective, nil
+}
+
+// loadFile merges the json file at path into the configuration, a missing file is an error only when required.
+// Unknown keys and values of the wrong type are returned as problems
+func (e *Effective) loadFile(path string, required bool) (ValidationErrors, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+	e.File, err = filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return ValidationErrors{{Path: "$", Message: fmt.Sprintf("invalid json in %s: %v", path, err)}}, nil
+	}
+	problems := checkSchema(raw, reflect.TypeOf(e.Config), "")
+	// values of the wrong type are reported by checkSchema, every other field is still decoded
+	_ = json.Unmarshal(b, &e.Config)
+	if object, ok := raw.(map[string]interface{}); ok {
+		for key := range flattenKeys("", object) {
+			if _, ok := e.Sources[key]; ok {
+				e.Sources[key] = SourceFile
+			}
+		}
+	}
+	return problems, nil
+}
+
+// Dump writes the effective configuration with secrets redacted, the source of every setting and the config file
+func (e *Effective) Dump(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string]interface{}{
+		"config":  e.Redacted(),
+		"sources": e.Sources,
+		"file":    e.File,
+	})
+}
+
+// Redacted returns the configuration as nested json objects with the value of secret settings hidden
+func (e *Effective) Redacted() map[string]interface{} {
+	result := map[string]interface{}{}
+	for _, s := range walkSettings(&e.Config) {
+		value := s.Value.Interface()
+		empty := s.Value.IsZero() || s.Value.Kind() == reflect.Slice && s.Value.Len() == 0
+		if s.Secret && !empty {
+			value = redacted
+		}
+		node := result
+		path := strings.Split(s.Key, ".")
+		for _, name := range path[:len(path)-1] {
+			if _, ok := node[name]; !ok {
+				node[name] = map[string]interface{}{}
+			}
+			node = node[name].(map[string]interface{})
+		}
+		node[path[len(path)-1]] = value
+	}
+	return result
+}
+
+// walkSettings lists the leaf fields of c named after their json tags
+func walkSettings(c *Config) []setting {
+	return walkStruct(reflect.ValueOf(c).Elem(), "")
+}
+
+// walkStruct lists the leaf fields of a struct value under prefix
+func walkStruct(v reflect.Value, prefix string) []setting {
+	var settings []setting
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + name
+		if field.Type.Kind() == reflect.Struct {
+			settings = append(settings, walkStruct(v.Field(i), key+".")...)
+			continue
+		}
+		normalized := strings.NewReplacer(".", "_", "-", "_").Replace(key)
+		settings = append(settings, setting{
+			Key:    key,
+			Env:    EnvPrefix + strings.ToUpper(normalized),
+			Flag:   strings.ReplaceAll(normalized, "_", "-"),
+			Value:  v.Field(i),
+			Secret: field.Tag.Get("secret") == "true",
+			Rules:  field.Tag.Get("validate"),
+			Reload: field.Tag.Get("reload"),
+		})
+	}
+	return settings
+}
+
+// setValue parses raw into a leaf field, slices are comma separated
+func setValue(v reflect.Value, raw string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported setting type %s", v.Type())
+		}
+		var values []string
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		v.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+// flattenKeys returns the dotted paths of every leaf of a decoded json object
+func flattenKeys(prefix string, raw map[string]interface{}) map[string]bool {
+	keys := map[string]bool{}
+	for name, value := range raw {
+		if child, ok := value.(map[string]interface{}); ok {
+			for key := range flattenKeys(prefix+name+".", child) {
+				keys[key] = true
+			}
+			continue
+		}
+		keys[prefix+name] = true
+	}
+	return keys
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testEnv returns a LookupEnv reading env
+func testEnv(env map[string]string) LookupEnv {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+// testConfigFile writes content to a config file in a temporary directory and returns its path
+func testConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPrintConfigRedactsSecrets(t *testing.T) {
+	const token = "Bearer s3cret-token"
+	file := testConfigFile(t, `{"tracing": {"headers": ["Authorization=`+token+`"]}}`)
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+	}{
+		{"file", []string{"--config", file}, nil},
+		{"env", nil, map[string]string{"PWS_TRACING_HEADERS": "Authorization=" + token}},
+		{"flag", []string{"--tracing-headers", "Authorization=" + token, "--print-config"}, nil},
+	}
+	for _, test := range tests {
+		effective, err := Load(test.args, testEnv(test.env))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := effective.Config.Tracing.Headers; len(got) != 1 || !strings.Contains(got[0], token) {
+			t.Errorf("%s: loaded headers %q", test.name, got)
+		}
+		tracing := effective.Redacted()["tracing"].(map[string]interface{})
+		if tracing["headers"] != redacted {
+			t.Errorf("%s: redacted headers are %v, want %s", test.name, tracing["headers"], redacted)
+		}
+		var dump bytes.Buffer
+		if err := effective.Dump(&dump); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(dump.String(), "s3cret") {
+			t.Errorf("%s: --print-config shows the secret:\n%s", test.name, dump.String())
+		}
+		if !strings.Contains(dump.String(), `"endpoint": "http://localhost:4318/v1/traces"`) {
+			t.Errorf("%s: --print-config hides settings which are not secret", test.name)
+		}
+	}
+}
+
+func TestRedactedKeepsEmptySecrets(t *testing.T) {
+	effective, err := Load(nil, testEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracing := effective.Redacted()["tracing"].(map[string]interface{})
+	if tracing["headers"] == redacted {
+		t.Error("an empty secret is shown as redacted")
+	}
+}
+
+func TestInvalidSecretIsNotPrinted(t *testing.T) {
+	_, err := Load(nil, testEnv(map[string]string{"PWS_TRACING_HEADERS": "s3cret-without-name"}))
+	if err == nil {
+		t.Fatal("a header without name is valid")
+	}
+	if strings.Contains(err.Error(), "s3cret") || !strings.Contains(err.Error(), "$.tracing.headers") {
+		t.Errorf("validation error is %q", err)
+	}
+}
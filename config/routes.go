@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/amupxm/pure-webserver/pkg/ratelimit"
+)
+
+// ParseRouteValues parses entries like "POST /v1/toys/_bulk=2m" or "/v1/toys/search=5s"
+// into values keyed by "METHOD /route" or "/route"
+func ParseRouteValues(entries []string) (map[string]string, error) {
+	values := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		i := strings.LastIndex(entry, "=")
+		if i == -1 {
+			return nil, fmt.Errorf("route setting %q must look like \"POST /v1/toys/_bulk=value\"", entry)
+		}
+		fields := strings.Fields(entry[:i])
+		if len(fields) == 0 || len(fields) > 2 || !strings.HasPrefix(fields[len(fields)-1], "/") {
+			return nil, fmt.Errorf("route setting %q must name a route pattern starting with /", entry)
+		}
+		route := fields[0]
+		if len(fields) == 2 {
+			route = strings.ToUpper(fields[0]) + " " + fields[1]
+		}
+		values[route] = strings.TrimSpace(entry[i+1:])
+	}
+	return values, nil
+}
+
+// ParseRouteTimeouts parses route_timeouts entries like "POST /v1/toys/_bulk=2m",
+// a zero duration disables the timeout of a route
+func ParseRouteTimeouts(entries []string) (map[string]time.Duration, error) {
+	values, err := ParseRouteValues(entries)
+	if err != nil {
+		return nil, err
+	}
+	timeouts := make(map[string]time.Duration, len(values))
+	for route, value := range values {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("route timeout of %s must be a duration like \"30s\", got %q", route, value)
+		}
+		timeouts[route] = timeout
+	}
+	return timeouts, nil
+}
+
+// ParseRouteLimits parses rate_limit.routes entries like "GET /v1/toys=20/1s",
+// "off" disables the limit of a route
+func ParseRouteLimits(entries []string) (map[string]*ratelimit.Limit, error) {
+	values, err := ParseRouteValues(entries)
+	if err != nil {
+		return nil, err
+	}
+	limits := make(map[string]*ratelimit.Limit, len(values))
+	for route, value := range values {
+		if value == "off" {
+			limits[route] = nil
+			continue
+		}
+		limit, err := ratelimit.ParseLimit(value)
+		if err != nil {
+			return nil, fmt.Errorf("rate limit of %s: %w", route, err)
+		}
+		limits[route] = &limit
+	}
+	return limits, nil
+}
+
+// ParseRouteSizes parses route_body_limits entries like "POST /v1/toys/_bulk=50MB",
+// a zero size disables the limit of a route
+func ParseRouteSizes(entries []string) (map[string]int64, error) {
+	values, err := ParseRouteValues(entries)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]int64, len(values))
+	for route, value := range values {
+		size, err := ParseSize(value)
+		if err != nil {
+			return nil, fmt.Errorf("body limit of %s: %w", route, err)
+		}
+		sizes[route] = size
+	}
+	return sizes, nil
+}
@@ -0,0 +1,311 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	httpEngine "github.com/amupxm/pure-webserver/pkg/httpEngine"
+	"github.com/amupxm/pure-webserver/pkg/ratelimit"
+)
+
+type (
+	// ValidationError is one problem of the configuration
+	ValidationError struct {
+		// Path is the json path of the setting, like "$.http.port"
+		Path    string
+		Message string
+	}
+	// ValidationErrors lists every problem of the configuration
+	ValidationErrors []ValidationError
+)
+
+// Error lists every problem on its own line
+func (ve ValidationErrors) Error() string {
+	lines := []string{"invalid configuration:"}
+	for _, e := range ve {
+		lines = append(lines, "  "+e.Path+": "+e.Message)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// jsonPath converts a setting key to a json path
+func jsonPath(key string) string {
+	if key == "" {
+		return "$"
+	}
+	return "$." + key
+}
+
+// Validate checks every setting against the rules of its `validate` tag:
+//
+//	required   the value is not empty
+//	port       a tcp port number between 1 and 65535
+//	hostport   an optional listen address like "127.0.0.1:6060"
+//	duration   a time.ParseDuration value like "30s", "0" disables the setting like an empty value
+//	min=N      a number not lower than N
+//	max=N      a number not greater than N
+//	oneof=a|b  one of the listed values
+//	routetimeouts  entries like "POST /v1/toys/_bulk=2m", see ParseRouteTimeouts
+//	ratelimit  an optional limit like "100/1m"
+//	routelimits  entries like "GET /v1/toys=20/1s", see ParseRouteLimits
+//	origins  cors origins, see httpEngine.CORSOptions
+//	size  a size like "10MB", see ParseSize
+//	routesizes  entries like "POST /v1/toys/_bulk=50MB", see ParseRouteSizes
+//	headers  entries like "Authorization=Bearer token", see ParseHeaders
+//	dir        a directory which exists
+//	filedir    a file path whose directory exists
+func Validate(c *Config, sources map[string]Source) ValidationErrors {
+	var problems ValidationErrors
+	for _, s := range walkSettings(c) {
+		for _, rule := range strings.Split(s.Rules, ",") {
+			if rule == "" {
+				continue
+			}
+			if message := checkRule(s.Value, rule); message != "" {
+				if source, ok := sources[s.Key]; ok && source != SourceDefault {
+					message += " (from " + string(source) + ")"
+				}
+				problems = append(problems, ValidationError{Path: jsonPath(s.Key), Message: message})
+				break
+			}
+		}
+	}
+	return problems
+}
+
+// checkRule returns why value breaks rule, empty when it does not
+func checkRule(value reflect.Value, rule string) string {
+	name, argument := rule, ""
+	if i := strings.Index(rule, "="); i != -1 {
+		name, argument = rule[:i], rule[i+1:]
+	}
+	text := fmt.Sprint(value.Interface())
+	switch name {
+	case "required":
+		if value.IsZero() {
+			return "is required"
+		}
+	case "port":
+		port, err := strconv.Atoi(text)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Sprintf("must be a port number between 1 and 65535, got %q", text)
+		}
+	case "hostport":
+		if text == "" {
+			return ""
+		}
+		_, port, err := net.SplitHostPort(text)
+		if number, _ := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+			return fmt.Sprintf("must be an address like \"127.0.0.1:6060\", got %q", text)
+		}
+	case "duration":
+		if text == "" {
+			return ""
+		}
+		duration, err := time.ParseDuration(text)
+		if err != nil || duration < 0 {
+			return fmt.Sprintf("must be a duration like \"30s\" or \"0\", got %q", text)
+		}
+	case "min", "max":
+		limit, _ := strconv.ParseFloat(argument, 64)
+		number, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Sprintf("must be a number, got %q", text)
+		}
+		if name == "min" && number < limit {
+			return fmt.Sprintf("must be at least %s, got %s", argument, text)
+		}
+		if name == "max" && number > limit {
+			return fmt.Sprintf("must be at most %s, got %s", argument, text)
+		}
+	case "oneof":
+		for _, allowed := range strings.Split(argument, "|") {
+			if text == allowed {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of %s, got %q", strings.ReplaceAll(argument, "|", ", "), text)
+	case "routetimeouts":
+		entries, _ := value.Interface().([]string)
+		if _, err := ParseRouteTimeouts(entries); err != nil {
+			return err.Error()
+		}
+	case "ratelimit":
+		if text == "" {
+			return ""
+		}
+		if _, err := ratelimit.ParseLimit(text); err != nil {
+			return err.Error()
+		}
+	case "routelimits":
+		entries, _ := value.Interface().([]string)
+		if _, err := ParseRouteLimits(entries); err != nil {
+			return err.Error()
+		}
+	case "size":
+		if _, err := ParseSize(text); err != nil {
+			return err.Error()
+		}
+	case "routesizes":
+		entries, _ := value.Interface().([]string)
+		if _, err := ParseRouteSizes(entries); err != nil {
+			return err.Error()
+		}
+	case "headers":
+		entries, _ := value.Interface().([]string)
+		if _, err := ParseHeaders(entries); err != nil {
+			return err.Error()
+		}
+	case "origins":
+		entries, _ := value.Interface().([]string)
+		if _, err := httpEngine.NewCORSPolicy(httpEngine.CORSOptions{AllowedOrigins: entries}); err != nil {
+			return err.Error()
+		}
+	case "dir", "filedir":
+		if text == "" {
+			return ""
+		}
+		dir := text
+		if name == "filedir" {
+			dir = filepath.Dir(text)
+		}
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() {
+			return fmt.Sprintf("directory %s does not exist", dir)
+		}
+	}
+	return ""
+}
+
+// checkSchema compares a decoded json value with the type of the field it is decoded into,
+// reporting unknown keys and wrong types
+func checkSchema(raw interface{}, t reflect.Type, key string) ValidationErrors {
+	var problems ValidationErrors
+	problem := func(message string) ValidationErrors {
+		return append(problems, ValidationError{Path: jsonPath(key), Message: message})
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := raw.(map[string]interface{})
+		if !ok {
+			return problem("must be an object, got " + jsonType(raw))
+		}
+		fields := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			if name != "" && name != "-" {
+				fields[name] = t.Field(i).Type
+			}
+		}
+		var names []string
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			childKey := name
+			if key != "" {
+				childKey = key + "." + name
+			}
+			fieldType, ok := fields[name]
+			if !ok {
+				problems = append(problems, ValidationError{Path: jsonPath(childKey), Message: "unknown key" + suggestKey(name, fields)})
+				continue
+			}
+			problems = append(problems, checkSchema(object[name], fieldType, childKey)...)
+		}
+	case reflect.String:
+		if _, ok := raw.(string); !ok {
+			return problem("must be a string, got " + jsonType(raw))
+		}
+	case reflect.Bool:
+		if _, ok := raw.(bool); !ok {
+			return problem("must be a boolean, got " + jsonType(raw))
+		}
+	case reflect.Int, reflect.Int64:
+		number, ok := raw.(float64)
+		if !ok || number != float64(int64(number)) {
+			return problem("must be an integer, got " + jsonType(raw))
+		}
+	case reflect.Float64:
+		if _, ok := raw.(float64); !ok {
+			return problem("must be a number, got " + jsonType(raw))
+		}
+	case reflect.Slice:
+		list, ok := raw.([]interface{})
+		if !ok {
+			return problem("must be an array, got " + jsonType(raw))
+		}
+		for i, element := range list {
+			problems = append(problems, checkSchema(element, t.Elem(), fmt.Sprintf("%s[%d]", key, i))...)
+		}
+	}
+	return problems
+}
+
+// jsonType names the json type of a decoded value for error messages
+func jsonType(raw interface{}) string {
+	switch v := raw.(type) {
+	case nil:
+		return "null"
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case float64:
+		return "number " + strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return "boolean " + strconv.FormatBool(v)
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+// suggestKey hints the known key closest to an unknown one, to catch typos
+func suggestKey(name string, fields map[string]reflect.Type) string {
+	best, bestDistance := "", 3
+	for field := range fields {
+		if distance := editDistance(name, field); distance < bestDistance {
+			best, bestDistance = field, distance
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+// editDistance is the levenshtein distance of two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+// minInt returns the lowest of two ints
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
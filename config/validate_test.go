@@ -0,0 +1,37 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckRuleDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		valid bool
+	}{
+		{"", true},
+		{"0", true},
+		{"0s", true},
+		{"30s", true},
+		{"-1s", false},
+		{"30", false},
+		{"soon", false},
+	}
+	for _, test := range tests {
+		message := checkRule(reflect.ValueOf(test.value), "duration")
+		if valid := message == ""; valid != test.valid {
+			t.Errorf("duration %q: valid = %v, want %v (%s)", test.value, valid, test.valid, message)
+		}
+	}
+}
+
+func TestZeroDurationsLoad(t *testing.T) {
+	effective, err := Load([]string{"--http-request-timeout", "0", "--http-idempotency-ttl", "0"}, testEnv(map[string]string{"PWS_EVENTS_HEARTBEAT": "0"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if effective.Config.Http.RequestTimeout != "0" || effective.Config.Events.Heartbeat != "0" {
+		t.Errorf("zero durations loaded as %q and %q", effective.Config.Http.RequestTimeout, effective.Config.Events.Heartbeat)
+	}
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/amupxm/pure-webserver/pkg/logger"
+)
+
+type (
+	// Watcher reloads the configuration on SIGHUP or when the config file changes
+	Watcher interface {
+		// Current returns the active configuration
+		Current() Config
+		// Subscribe registers a callback run with the previous and the new configuration after every reload
+		Subscribe(callback func(old, new Config))
+		// Reload loads, validates and applies the configuration
+		Reload() error
+		// Watch reloads on SIGHUP and file changes until stop is closed
+		Watch(stop <-chan struct{})
+	}
+	watcher struct {
+		args      []string
+		lookupEnv LookupEnv
+		file      string
+		// current holds the active Config
+		current atomic.Value
+		// lock serializes reloads and subscriptions
+		lock        sync.Mutex
+		subscribers []func(old, new Config)
+		// fileState is the last seen modification time and size of file
+		fileState fileState
+	}
+	fileState struct {
+		modTime time.Time
+		size    int64
+	}
+)
+
+// watchInterval is how often the config file is checked for changes
+var watchInterval = 2 * time.Second
+
+// NewWatcher creates a watcher starting from effective, reloads use the same args and environment
+func NewWatcher(effective *Effective, args []string, lookupEnv LookupEnv) Watcher {
+	w := &watcher{
+		args:      args,
+		lookupEnv: lookupEnv,
+		file:      effective.File,
+	}
+	w.current.Store(effective.Config)
+	w.fileState = w.statFile()
+	return w
+}
+
+// Current returns the active configuration
+func (w *watcher) Current() Config {
+	return w.current.Load().(Config)
+}
+
+// Subscribe registers a callback run with the previous and the new configuration after every reload
+func (w *watcher) Subscribe(callback func(old, new Config)) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.subscribers = append(w.subscribers, callback)
+}
+
+// Reload loads and validates the configuration, then swaps it and notifies subscribers.
+// The active configuration is kept when the new one is invalid or changes a setting
+// tagged `reload:"restart"`
+func (w *watcher) Reload() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	effective, err := Load(w.args, w.lookupEnv)
+	if err != nil {
+		return err
+	}
+	old := w.Current()
+	if problems := restartRequired(&old, &effective.Config); len(problems) != 0 {
+		return problems
+	}
+	w.current.Store(effective.Config)
+	for _, callback := range w.subscribers {
+		callback(old, effective.Config)
+	}
+	return nil
+}
+
+// Watch reloads on SIGHUP and file changes until stop is closed
+func (w *watcher) Watch(stop <-chan struct{}) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hangup:
+			w.reloadAndLog("SIGHUP")
+		case <-ticker.C:
+			if w.file == "" {
+				continue
+			}
+			if state := w.statFile(); state != w.fileState {
+				w.fileState = state
+				w.reloadAndLog("change of " + w.file)
+			}
+		}
+	}
+}
+
+// reloadAndLog reloads and logs the outcome
+func (w *watcher) reloadAndLog(reason string) {
+	if err := w.Reload(); err != nil {
+		logger.Default().Error("config reload rejected, keeping the active configuration", logger.Fields{"reason": reason, "error": err})
+		return
+	}
+	logger.Default().Info("config reloaded", logger.Fields{"reason": reason})
+}
+
+// statFile returns the modification time and size of the config file
+func (w *watcher) statFile() fileState {
+	info, err := os.Stat(w.file)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{modTime: info.ModTime(), size: info.Size()}
+}
+
+// restartRequired lists the settings tagged `reload:"restart"` which differ between old and new
+func restartRequired(old, new *Config) ValidationErrors {
+	var problems ValidationErrors
+	oldSettings, newSettings := walkSettings(old), walkSettings(new)
+	for i, s := range oldSettings {
+		if s.Reload != "restart" {
+			continue
+		}
+		if !reflect.DeepEqual(s.Value.Interface(), newSettings[i].Value.Interface()) {
+			problems = append(problems, ValidationError{Path: jsonPath(s.Key), Message: "can not change without a restart"})
+		}
+	}
+	return problems
+}
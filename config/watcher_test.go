@@ -0,0 +1,145 @@
+package config
+
+import (
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newTestWatcher returns a watcher over a config file holding content and the path of the file
+func newTestWatcher(t *testing.T, content string) (Watcher, string) {
+	t.Helper()
+	file := testConfigFile(t, content)
+	args := []string{"--config", file}
+	effective, err := Load(args, testEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewWatcher(effective, args, testEnv(nil)), file
+}
+
+// writeConfig replaces the content of the config file
+func writeConfig(t *testing.T, file, content string) {
+	t.Helper()
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// notifications returns a channel receiving the new configuration of every reload of w
+func notifications(w Watcher) <-chan Config {
+	reloaded := make(chan Config, 10)
+	w.Subscribe(func(old, new Config) {
+		reloaded <- new
+	})
+	return reloaded
+}
+
+func TestReload(t *testing.T) {
+	w, file := newTestWatcher(t, `{"log": {"level": "info"}}`)
+	var old, new Config
+	calls := 0
+	w.Subscribe(func(o, n Config) {
+		old, new = o, n
+		calls++
+	})
+	writeConfig(t, file, `{"log": {"level": "debug"}}`)
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Current().Log.Level != "debug" {
+		t.Errorf("level is %q after the reload, want debug", w.Current().Log.Level)
+	}
+	if calls != 1 || old.Log.Level != "info" || new.Log.Level != "debug" {
+		t.Errorf("subscriber ran %d times with levels %q and %q", calls, old.Log.Level, new.Log.Level)
+	}
+}
+
+func TestReloadKeepsTheActiveConfiguration(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		problem string
+	}{
+		{"restart setting", `{"http": {"port": "9999"}, "log": {"level": "debug"}}`, "http.port"},
+		{"invalid value", `{"log": {"level": "loud"}}`, "log.level"},
+		{"unknown key", `{"log": {"levle": "debug"}}`, "levle"},
+		{"malformed file", `{"log": {"level": "debug"}`, ""},
+	}
+	for _, test := range tests {
+		w, file := newTestWatcher(t, `{"log": {"level": "info"}}`)
+		reloaded := notifications(w)
+		port := w.Current().Http.Port
+		writeConfig(t, file, test.content)
+		err := w.Reload()
+		if err == nil || !strings.Contains(err.Error(), test.problem) {
+			t.Errorf("%s: error %v, want a problem with %q", test.name, err, test.problem)
+		}
+		if current := w.Current(); current.Log.Level != "info" || current.Http.Port != port {
+			t.Errorf("%s: active configuration changed to level %q and port %q", test.name, current.Log.Level, current.Http.Port)
+		}
+		if len(reloaded) != 0 {
+			t.Errorf("%s: subscribers were notified of a rejected reload", test.name)
+		}
+	}
+}
+
+func TestWatchReloadsOnFileChange(t *testing.T) {
+	defer func(interval time.Duration) { watchInterval = interval }(watchInterval)
+	watchInterval = 10 * time.Millisecond
+	w, file := newTestWatcher(t, `{"log": {"level": "info"}}`)
+	reloaded := notifications(w)
+	stop := make(chan struct{})
+	defer close(stop)
+	go w.Watch(stop)
+
+	writeConfig(t, file, `{"log": {"level": "warn"}}`)
+	select {
+	case c := <-reloaded:
+		if c.Log.Level != "warn" {
+			t.Errorf("reloaded level %q, want warn", c.Log.Level)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("the change of the file was not reloaded")
+	}
+}
+
+func TestWatchReloadsOnSIGHUP(t *testing.T) {
+	// SIGHUP ends the process unless someone is notified of it, so it is caught
+	// here too before Watch starts listening
+	caught := make(chan os.Signal, 1)
+	signal.Notify(caught, syscall.SIGHUP)
+	defer signal.Stop(caught)
+	// the file poll must not be what reloads
+	defer func(interval time.Duration) { watchInterval = interval }(watchInterval)
+	watchInterval = time.Hour
+	w, file := newTestWatcher(t, `{"log": {"level": "info"}}`)
+	reloaded := notifications(w)
+	stop := make(chan struct{})
+	defer close(stop)
+	go w.Watch(stop)
+
+	writeConfig(t, file, `{"log": {"level": "error"}}`)
+	deadline := time.After(3 * time.Second)
+	// signals sent before Watch listens are lost, so it is sent until a reload happens
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case c := <-reloaded:
+			if c.Log.Level != "error" {
+				t.Errorf("reloaded level %q, want error", c.Log.Level)
+			}
+			return
+		case <-ticker.C:
+		case <-deadline:
+			t.Fatal("SIGHUP did not reload")
+		}
+	}
+}
@@ -2,6 +2,41 @@ package constants
 
 const (
 	NoParam = "invalid url param"
+	NoQuery = "invalid query param"
 	NoData  = "invalid id"
 	BadData = "incorrupted data"
+	// PreconditionFailed is returned when If-Match or If-None-Match do not hold
+	PreconditionFailed = "precondition failed"
+	// Conflict is returned when a product changed since it was read
+	Conflict = "product was modified concurrently"
+	// IdempotencyMismatch is returned when an Idempotency-Key is reused for another request
+	IdempotencyMismatch = "idempotency key was used for a different request"
+	// Timeout is returned when a route does not answer before its timeout
+	Timeout = "request timed out"
+	// Cancelled is returned when the work of a request was cancelled
+	Cancelled = "request was cancelled"
+	// RateLimited is returned when a client sent more requests than the limit of a route
+	RateLimited = "rate limit exceeded"
+	// CORSNotAllowed is returned for preflights of origins, methods or headers which are not allowed
+	CORSNotAllowed = "cross-origin request not allowed"
+	// UnsupportedEncoding is returned for request bodies with a Content-Encoding other than gzip
+	UnsupportedEncoding = "unsupported content encoding"
+	// NotAcceptable is returned when no response format matches Accept or ?format=
+	NotAcceptable = "no acceptable response format"
+	// BodyTooLarge is returned when a request body exceeds its size limit
+	BodyTooLarge = "request body too large"
+	// UnsupportedMediaType is returned for request bodies of a Content-Type or Content-Encoding which can not be decoded
+	UnsupportedMediaType = "unsupported media type"
+	// EmptyBody is returned when a request needs a body but has none
+	EmptyBody = "request body is empty"
+	// UnknownField is returned for request fields the target does not have
+	UnknownField = "unknown field"
+	// NoImage is returned for uploads without an image file
+	NoImage = "no image in the request"
+	// ImageNotFound is returned for images a product does not have
+	ImageNotFound = "image not found"
+	// StreamingUnsupported is returned when a response can not be streamed to the client
+	StreamingUnsupported = "streaming is not supported"
+	// UpgradeRequired is returned for requests to websocket routes which are not websocket handshakes
+	UpgradeRequired = "websocket upgrade required"
 )
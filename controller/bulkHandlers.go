@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/amupxm/pure-webserver/domain"
+	"github.com/amupxm/pure-webserver/pkg/database"
+	httpEngine "github.com/amupxm/pure-webserver/pkg/httpEngine"
+)
+
+// bulkItem is the response of one bulk operation
+type bulkItem struct {
+	Op       string           `json:"op"`
+	Iid      string           `json:"iid"`
+	Status   int              `json:"status"`
+	Products []domain.Product `json:"products,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// Bulk handler creates, updates and deletes products listed as a json array
+// or as newline delimited json (application/x-ndjson), ?atomic=true applies all of them or none
+func (e *engine) Bulk(c *httpEngine.ServerContext) {
+	operations, err := decodeBulkOperations(c)
+	if err != nil {
+		c.ErrorHandler(400, err)
+		return
+	}
+	atomic := c.Request.URL.Query().Get("atomic") == "true"
+	results, err := e.ProductLogic.BulkProducts(c.Context(), operations, atomic)
+	cancelled := errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+	if err != nil && !errors.Is(err, database.ErrBatchAborted) && !cancelled {
+		c.ErrorHandler(400, err)
+		return
+	}
+
+	items := make([]bulkItem, len(results))
+	failed := false
+	for i, result := range results {
+		items[i] = bulkItem{
+			Op:       operations[i].Op,
+			Iid:      operations[i].Iid,
+			Status:   bulkStatus(operations[i].Op, result.Err),
+			Products: result.Products,
+		}
+		if result.Err != nil {
+			items[i].Error = result.Err.Error()
+			failed = true
+		}
+	}
+	code := 200
+	switch {
+	case errors.Is(err, database.ErrBatchAborted):
+		code = 409
+	case cancelled:
+		// operations written before the cancellation are listed with their status
+		code = bulkStatus("", err)
+	}
+	c.JSON(code, map[string]interface{}{
+		"atomic": atomic,
+		"errors": failed,
+		"items":  items,
+	})
+}
+
+// decodeBulkOperations reads and validates the operations of a bulk request body
+func decodeBulkOperations(c *httpEngine.ServerContext) ([]domain.BulkOperation, error) {
+	var operations []domain.BulkOperation
+	switch c.ContentType() {
+	case "application/x-ndjson", "application/ndjson":
+		decoder := json.NewDecoder(c.Request.Body)
+		for {
+			var operation domain.BulkOperation
+			err := decoder.Decode(&operation)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", len(operations)+1, err)
+			}
+			operations = append(operations, operation)
+		}
+	default:
+		if err := c.BindToJson(&operations); err != nil {
+			return nil, err
+		}
+	}
+	for i, operation := range operations {
+		switch operation.Op {
+		case domain.BulkCreate, domain.BulkUpdate, domain.BulkDelete:
+		default:
+			return nil, fmt.Errorf("operation %d: unknown op %q", i, operation.Op)
+		}
+		if operation.Iid == "" {
+			return nil, fmt.Errorf("operation %d: missing iid", i)
+		}
+	}
+	return operations, nil
+}
+
+// bulkStatus returns the http status of one bulk operation
+func bulkStatus(op string, err error) int {
+	switch {
+	case err == nil && op == domain.BulkCreate:
+		return 201
+	case err == nil:
+		return 200
+	case errors.Is(err, database.ErrNotFound):
+		return 404
+	case errors.Is(err, database.ErrVersionConflict):
+		return 412
+	case errors.Is(err, database.ErrBatchAborted):
+		return 424
+	case errors.Is(err, context.DeadlineExceeded):
+		return 504
+	case errors.Is(err, context.Canceled):
+		return 503
+	}
+	return 400
+}
@@ -0,0 +1,44 @@
+package controller
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/amupxm/pure-webserver/config"
+	httpEngine "github.com/amupxm/pure-webserver/pkg/httpEngine"
+	"github.com/amupxm/pure-webserver/pkg/logger"
+)
+
+// corsPolicy returns the cors policy of the configuration, nil when no origin is allowed,
+// the policy follows configuration reloads and an invalid one keeps the previous policy
+func corsPolicy(watcher config.Watcher) func() *httpEngine.CORSPolicy {
+	var current atomic.Value
+	current.Store((*httpEngine.CORSPolicy)(nil))
+	load := func(c config.Config) {
+		var policy *httpEngine.CORSPolicy
+		if len(c.Cors.AllowedOrigins) > 0 {
+			maxAge, _ := time.ParseDuration(c.Cors.MaxAge)
+			var err error
+			policy, err = httpEngine.NewCORSPolicy(httpEngine.CORSOptions{
+				AllowedOrigins:   c.Cors.AllowedOrigins,
+				AllowedMethods:   c.Cors.AllowedMethods,
+				AllowedHeaders:   c.Cors.AllowedHeaders,
+				ExposedHeaders:   c.Cors.ExposedHeaders,
+				AllowCredentials: c.Cors.AllowCredentials,
+				MaxAge:           maxAge,
+			})
+			if err != nil {
+				logger.Default().Warn("invalid cors configuration, keeping the previous policy", logger.Fields{"error": err})
+				return
+			}
+		}
+		current.Store(policy)
+	}
+	load(watcher.Current())
+	watcher.Subscribe(func(_, new config.Config) {
+		load(new)
+	})
+	return func() *httpEngine.CORSPolicy {
+		return current.Load().(*httpEngine.CORSPolicy)
+	}
+}
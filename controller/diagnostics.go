@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"path/filepath"
+	"time"
+
+	"github.com/amupxm/pure-webserver/config"
+	"github.com/amupxm/pure-webserver/pkg/database"
+	"github.com/amupxm/pure-webserver/pkg/health"
+	httpEngine "github.com/amupxm/pure-webserver/pkg/httpEngine"
+	"github.com/amupxm/pure-webserver/pkg/logger"
+	"github.com/amupxm/pure-webserver/pkg/metrics"
+)
+
+// registerDiagnostics adds the liveness and readiness probes to server
+// and starts the admin listener when it is configured
+func registerDiagnostics(server httpEngine.Server, db database.Database, watcher config.Watcher) {
+	liveness := health.NewChecker(0)
+	readiness := health.NewChecker(0)
+	readiness.Add("database", func(ctx context.Context) error {
+		return db.Ping(ctx)
+	})
+	databaseDir := filepath.Dir(watcher.Current().DatabaseConfig.BucketName)
+	readiness.Add("disk", health.DiskSpace(databaseDir, func() uint64 {
+		return uint64(watcher.Current().Health.MinFreeDiskMB) << 20
+	}))
+	readiness.Add("write_lag", health.WriteLag(db.WriteLag, func() time.Duration {
+		return settingDuration("health.max_write_lag", watcher.Current().Health.MaxWriteLag)
+	}))
+	server.AddHandler("/healthz", "GET", httpEngine.WrapHandler(liveness.Handler()))
+	server.AddHandler("/readyz", "GET", httpEngine.WrapHandler(readiness.Handler()))
+
+	health.PublishRuntimeVars(time.Now())
+	if admin := watcher.Current().Admin; admin.Address != "" {
+		go serveAdmin(admin.Address, admin.Pprof)
+	}
+}
+
+// serveAdmin serves runtime variables, metrics and optionally pprof on address,
+// it should only be reachable by operators
+func serveAdmin(address string, withPprof bool) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.Handle("/metrics", metrics.Handler(metrics.Default()))
+	if withPprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	logger.Default().Info("admin listener started", logger.Fields{"address": address, "pprof": withPprof})
+	err := http.ListenAndServe(address, mux)
+	logger.Default().Error("admin listener stopped", logger.Fields{"error": err})
+}
@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/amupxm/pure-webserver/config"
+	"github.com/amupxm/pure-webserver/domain"
+	"github.com/amupxm/pure-webserver/pkg/database"
+	httpEngine "github.com/amupxm/pure-webserver/pkg/httpEngine"
+	"github.com/amupxm/pure-webserver/pkg/logger"
+	"github.com/amupxm/pure-webserver/pkg/websocket"
+)
+
+type (
+	// productEvents streams product changes from the change log of the database, clients resume from the
+	// sequence number of the last change they received
+	productEvents struct {
+		db database.Database
+	}
+	// productEvent is one change of a product
+	productEvent struct {
+		Seq     int64                  `json:"seq"`
+		Kind    database.ChangeKind    `json:"kind"`
+		Id      string                 `json:"id,omitempty"`
+		Product map[string]interface{} `json:"product,omitempty"`
+	}
+)
+
+// resetEvent tells a resuming client that the events it missed are gone and the products should be reloaded
+const resetEvent database.ChangeKind = "reset"
+
+// registerEvents streams product changes of db as server-sent events on /v1/toys/events
+// and over websockets on /v1/toys/ws
+func registerEvents(server httpEngine.Server, db database.Database, c config.Config, cors func() *httpEngine.CORSPolicy) {
+	events := &productEvents{db: db}
+	heartbeat, _ := time.ParseDuration(c.Events.Heartbeat)
+	retry, _ := time.ParseDuration(c.Events.Retry)
+	server.AddHandler("/v1/toys/events", "GET", func(c *httpEngine.ServerContext) {
+		events.serve(c, heartbeat, retry)
+	})
+	server.WebSocket("/v1/toys/ws", func(c *httpEngine.ServerContext, conn *websocket.Conn) {
+		events.serveSocket(c, conn, heartbeat)
+	}, productSocketOptions(heartbeat, cors))
+}
+
+// newProductEvent turns a change into an event, deletes carry the product before the change
+func newProductEvent(change database.Change) productEvent {
+	product := change.After
+	if change.Kind == database.ChangeDelete {
+		product = change.Before
+	}
+	return productEvent{Seq: change.Seq, Kind: change.Kind, Id: change.Id, Product: product}
+}
+
+// watch returns the events after the sequence number from, then the new ones as they are written, until ctx is done.
+// An empty from is a first connection which only gets new events. When the events after from are no longer logged
+// or from is not a sequence number of the database, the first event is a reset. The channel is also closed when
+// the client falls too far behind, it reconnects and resumes from its last sequence number
+func (e *productEvents) watch(ctx context.Context, from string) (<-chan productEvent, error) {
+	var product *domain.Product
+	head, err := e.db.ChangeSeq(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fromSeq, reset := head, false
+	if from != "" {
+		last, err := strconv.ParseInt(from, 10, 64)
+		if err != nil || last < 0 || last > head {
+			// the sequence number is not from this database
+			reset = true
+		} else {
+			fromSeq = last
+		}
+	}
+	changes, err := e.db.Watch(ctx, product, fromSeq)
+	if errors.Is(err, database.ErrChangesTrimmed) {
+		fromSeq, reset = head, true
+		changes, err = e.db.Watch(ctx, product, fromSeq)
+	}
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan productEvent)
+	go func() {
+		defer close(events)
+		if reset {
+			select {
+			case events <- productEvent{Seq: fromSeq, Kind: resetEvent}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		for change := range changes {
+			select {
+			case events <- newProductEvent(change):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// serve streams events to one client, starting with the ones it missed since Last-Event-ID
+func (e *productEvents) serve(c *httpEngine.ServerContext, heartbeat, retry time.Duration) {
+	ctx, cancel := context.WithCancel(c.Context())
+	defer cancel()
+	stream, err := e.watch(ctx, c.LastEventID())
+	if err != nil {
+		c.ErrorHandler(http.StatusInternalServerError, err)
+		return
+	}
+	events, err := c.EventStream(retry)
+	if err != nil {
+		c.ErrorHandler(http.StatusInternalServerError, err)
+		return
+	}
+	sse := make(chan httpEngine.Event)
+	go func() {
+		defer close(sse)
+		for event := range stream {
+			select {
+			case sse <- sseEvent(event):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	if err := events.Run(sse, heartbeat); err != nil {
+		logger.Default().Debug("event stream closed", logger.Fields{"error": err, "request_id": c.RequestID})
+	}
+}
+
+// sseEvent returns the server-sent event of a product event
+func sseEvent(event productEvent) httpEngine.Event {
+	data, err := json.Marshal(event)
+	if err != nil {
+		data = []byte("{}")
+	}
+	return httpEngine.Event{ID: strconv.FormatInt(event.Seq, 10), Event: string(event.Kind), Data: string(data)}
+}
@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amupxm/pure-webserver/config"
+	"github.com/amupxm/pure-webserver/domain"
+	"github.com/amupxm/pure-webserver/pkg/database"
+	httpEngine "github.com/amupxm/pure-webserver/pkg/httpEngine"
+)
+
+// newEventsDatabase returns a temporary database logging the last retention changes of products,
+// holding n products with the iids from 1 to n
+func newEventsDatabase(t *testing.T, retention, n int) database.Database {
+	t.Helper()
+	db := database.NewDatabase(database.WithBucketName(filepath.Join(t.TempDir(), "database.json")))
+	var product *domain.Product
+	db.EnableChangeLog(product, retention)
+	for i := 1; i <= n; i++ {
+		insertProduct(t, db, strconv.Itoa(i))
+	}
+	return db
+}
+
+// insertProduct writes a product with iid to db
+func insertProduct(t *testing.T, db database.Database, iid string) {
+	t.Helper()
+	if err := db.WriteToCollection(context.Background(), &domain.Product{Name: "car", Iid: iid}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// nextEvent returns the next event of stream as "seq:kind", failing when none arrives
+func nextEvent(t *testing.T, stream <-chan productEvent) string {
+	t.Helper()
+	select {
+	case event, ok := <-stream:
+		if !ok {
+			t.Fatal("the event stream was closed")
+		}
+		return strconv.FormatInt(event.Seq, 10) + ":" + string(event.Kind)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event was received")
+	}
+	return ""
+}
+
+func TestProductEventsResume(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		want []string
+	}{
+		{"first connection", "", nil},
+		{"up to date", "5", nil},
+		{"one missed", "4", []string{"5:insert"}},
+		{"every logged change missed", "2", []string{"3:insert", "4:insert", "5:insert"}},
+		{"trimmed from the log", "1", []string{"5:reset"}},
+		{"from another database", "9", []string{"5:reset"}},
+		{"invalid id", "x", []string{"5:reset"}},
+	}
+	for _, test := range tests {
+		// the log keeps the changes 3 to 5
+		db := newEventsDatabase(t, 3, 5)
+		events := &productEvents{db: db}
+		ctx, cancel := context.WithCancel(context.Background())
+		stream, err := events.watch(ctx, test.from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a new change follows the missed ones
+		insertProduct(t, db, "6")
+		var got []string
+		for event := nextEvent(t, stream); event != "6:insert"; event = nextEvent(t, stream) {
+			got = append(got, event)
+		}
+		cancel()
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: missed %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestProductEventsCloseWithTheContext(t *testing.T) {
+	db := newEventsDatabase(t, 0, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := (&productEvents{db: db}).watch(ctx, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(t, stream); event != "1:insert" {
+		t.Errorf("first event is %s, want 1:insert", event)
+	}
+	cancel()
+	select {
+	case <-stream:
+	case <-time.After(5 * time.Second):
+		t.Error("the stream was not closed with its context")
+	}
+}
+
+func TestEventStreamIdsAreChangeSeqs(t *testing.T) {
+	db := newEventsDatabase(t, 0, 2)
+	c := config.Defaults()
+	c.Events.Heartbeat = "0"
+	server := httpEngine.NewServer()
+	registerEvents(server, db, c, func() *httpEngine.CORSPolicy { return nil })
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	r, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/toys/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set(httpEngine.LastEventIDHeader, "1")
+	client := &http.Client{Timeout: 5 * time.Second}
+	response, err := client.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	insertProduct(t, db, "3")
+	lines := bufio.NewScanner(response.Body)
+	var ids []string
+	for len(ids) < 2 && lines.Scan() {
+		if id, ok := strings.CutPrefix(lines.Text(), "id: "); ok {
+			ids = append(ids, id)
+		}
+	}
+	if !reflect.DeepEqual(ids, []string{"2", "3"}) {
+		t.Errorf("event ids are %q, want the seqs 2 and 3 of the change log", ids)
+	}
+}
+
+func TestSSEEvent(t *testing.T) {
+	event := sseEvent(newProductEvent(database.Change{Seq: 7, Kind: database.ChangeDelete, Id: "1", Before: map[string]interface{}{"name": "car"}}))
+	want := `{"seq":7,"kind":"delete","id":"1","product":{"name":"car"}}`
+	if event.ID != "7" || event.Event != "delete" || event.Data != want {
+		t.Errorf("event is %+v, want id 7, event delete and data %s", event, want)
+	}
+}
@@ -1,16 +1,28 @@
 package controller
 
 import (
-	"log"
+	"context"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/amupxm/pure-webserver/config"
 	"github.com/amupxm/pure-webserver/logic"
+	"github.com/amupxm/pure-webserver/pkg/blob"
+	"github.com/amupxm/pure-webserver/pkg/database"
 	httpEngine "github.com/amupxm/pure-webserver/pkg/httpEngine"
+	"github.com/amupxm/pure-webserver/pkg/logger"
+	"github.com/amupxm/pure-webserver/pkg/metrics"
+	"github.com/amupxm/pure-webserver/pkg/tracing"
 )
 
 type (
 	engine struct {
 		ProductLogic logic.ProductLogic
+		// Images stores the product images
+		Images blob.Store
 	}
 	Engine interface {
 		// GetOne returns one product
@@ -23,26 +35,208 @@ type (
 		CreateProduct(c *httpEngine.ServerContext)
 		// DeleteOne deletes one product
 		DeleteOne(c *httpEngine.ServerContext)
+		// Search returns products matching a full-text query
+		Search(c *httpEngine.ServerContext)
+		// Bulk creates, updates and deletes many products
+		Bulk(c *httpEngine.ServerContext)
+		// UploadImages stores images of one product
+		UploadImages(c *httpEngine.ServerContext)
+		// GetImage returns one image of a product
+		GetImage(c *httpEngine.ServerContext)
+		// Changes returns a page of the product change log
+		Changes(c *httpEngine.ServerContext)
 	}
 )
 
-func NewEngine(pl logic.ProductLogic) Engine {
+func NewEngine(pl logic.ProductLogic, images blob.Store) Engine {
 	return &engine{
 		ProductLogic: pl,
+		Images:       images,
 	}
 }
 
-func InitNewEngine(pl logic.ProductLogic) {
-	en := NewEngine(pl)
-	server := httpEngine.NewServer()
+func InitNewEngine(pl logic.ProductLogic, db database.Database, images blob.Store, watcher config.Watcher) {
+	en := NewEngine(pl, images)
+	maxHeaderSize, err := config.ParseSize(watcher.Current().Http.MaxHeaderSize)
+	if err != nil {
+		logger.Default().Warn("invalid max header size", logger.Fields{"error": err})
+	}
+	server := httpEngine.NewServer(
+		httpEngine.WithPort(watcher.Current().Http.Port),
+		httpEngine.WithTracer(tracing.Default()),
+		httpEngine.WithTimeouts(serverTimeouts(watcher.Current())),
+		httpEngine.WithMaxHeaderBytes(int(maxHeaderSize)),
+	)
+
+	server.Use(httpEngine.RequestID(), httpEngine.AccessLog(logger.Default()), httpEngine.Metrics(metrics.Default()))
+	if c := watcher.Current().Compression; c.Enabled {
+		server.Use(httpEngine.Compress(httpEngine.CompressOptions{
+			MinSize:      c.MinSize,
+			ContentTypes: c.ContentTypes,
+			Level:        c.Level,
+		}))
+	}
+	cors := corsPolicy(watcher)
+	server.Use(httpEngine.CORS(cors))
+
+	rateLimit, stopRateLimit, err := newRateLimit(db, watcher)
+	if err != nil {
+		logger.Default().Error("can not create rate limiter", logger.Fields{"error": err})
+		os.Exit(1)
+	}
+	if rateLimit != nil {
+		server.Use(rateLimit)
+	}
+
+	server.Use(httpEngine.BodyLimit(routeBodyLimits(watcher)))
+
+	// idempotency keys belong to the authenticated client, anonymous clients are told apart by ip
+	idempotencyClient := httpEngine.KeyByFirst(httpEngine.KeyByJWTSubject(), httpEngine.KeyByAPIKey(watcher.Current().RateLimit.APIKeyHeader))
+	server.Use(httpEngine.Idempotency(httpEngine.NewDatabaseIdempotencyStore(db, idempotencyTTL(watcher.Current())), idempotencyClient))
+	watcher.Subscribe(func(old, new config.Config) {
+		if old.Http.IdempotencyTTL != new.Http.IdempotencyTTL {
+			var record *httpEngine.IdempotencyRecord
+			db.SetTTL(record, idempotencyTTL(new))
+		}
+	})
+
+	server.Use(httpEngine.Timeout(routeTimeouts(watcher)))
+
+	server.AddHandler("/metrics", "GET", httpEngine.WrapHandler(metrics.Handler(metrics.Default())))
+	registerDiagnostics(server, db, watcher)
+	registerUI(server, watcher.Current())
+	registerEvents(server, db, watcher.Current(), cors)
 
 	server.AddHandler("/v1/toys", "GET", en.GetAll)
+	server.AddHandler("/v1/toys/search", "GET", en.Search)
+	server.AddHandler("/v1/toys/_bulk", "POST", en.Bulk)
+	server.AddHandler("/v1/toys/changes", "GET", en.Changes)
 	server.AddHandler("/v1/toys/:iid", "GET", en.GetOne)
 	server.AddHandler("/v1/toys/:iid", "DELETE", en.DeleteOne)
 	server.AddHandler("/v1/toys/:iid", "PATCH", en.UpdateOne)
 	server.AddHandler("/v1/toys/:iid", "PUT", en.CreateProduct)
+	server.AddHandler("/v1/toys/:iid/images", "POST", en.UploadImages)
+	server.AddHandler("/v1/toys/:iid/images/:name", "GET", en.GetImage)
+
+	// shutdown persists what is only kept in memory before the process exits
+	shutdown := func() {
+		stopRateLimit()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_ = tracing.Default().Shutdown(ctx)
+		cancel()
+	}
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-signals
+		logger.Default().Info("shutting down", logger.Fields{"signal": sig.String()})
+		shutdown()
+		os.Exit(0)
+	}()
 
 	// listen on port 8080 , You can change this port from config.json
-	server.StartServer(config.AppConf.Http.Port)
-	log.Printf("server started on port %s\n", config.AppConf.Http.Port)
+	logger.Default().Info("server started", logger.Fields{"port": watcher.Current().Http.Port})
+	err = server.StartServer()
+	logger.Default().Error("server stopped", logger.Fields{"error": err})
+	shutdown()
+	os.Exit(1)
+}
+
+// routeTimeouts returns the timeout of a route by its method and pattern from the configuration,
+// the timeouts follow configuration reloads
+func routeTimeouts(watcher config.Watcher) func(method, route string) time.Duration {
+	type timeouts struct {
+		fallback time.Duration
+		routes   map[string]time.Duration
+	}
+	var current atomic.Value
+	load := func(c config.Config) {
+		fallback := settingDuration("http.request_timeout", c.Http.RequestTimeout)
+		routes, err := config.ParseRouteTimeouts(c.Http.RouteTimeouts)
+		if err != nil {
+			logger.Default().Warn("invalid route timeouts", logger.Fields{"error": err})
+		}
+		current.Store(timeouts{fallback: fallback, routes: routes})
+	}
+	load(watcher.Current())
+	watcher.Subscribe(func(_, new config.Config) {
+		load(new)
+	})
+	return func(method, route string) time.Duration {
+		t := current.Load().(timeouts)
+		if timeout, ok := t.routes[method+" "+route]; ok {
+			return timeout
+		}
+		if timeout, ok := t.routes[route]; ok {
+			return timeout
+		}
+		return t.fallback
+	}
+}
+
+// routeBodyLimits returns the body size limit of a route by its method and pattern from the configuration,
+// the limits follow configuration reloads
+func routeBodyLimits(watcher config.Watcher) func(method, route string) int64 {
+	type limits struct {
+		fallback int64
+		routes   map[string]int64
+	}
+	var current atomic.Value
+	load := func(c config.Config) {
+		fallback, err := config.ParseSize(c.Http.MaxBodySize)
+		if err != nil {
+			logger.Default().Warn("invalid max body size", logger.Fields{"error": err})
+		}
+		routes, err := config.ParseRouteSizes(c.Http.RouteBodyLimits)
+		if err != nil {
+			logger.Default().Warn("invalid route body limits", logger.Fields{"error": err})
+		}
+		current.Store(limits{fallback: fallback, routes: routes})
+	}
+	load(watcher.Current())
+	watcher.Subscribe(func(_, new config.Config) {
+		load(new)
+	})
+	return func(method, route string) int64 {
+		l := current.Load().(limits)
+		if limit, ok := l.routes[method+" "+route]; ok {
+			return limit
+		}
+		if limit, ok := l.routes[route]; ok {
+			return limit
+		}
+		return l.fallback
+	}
+}
+
+// serverTimeouts returns the connection timeouts of the configuration, empty ones are disabled
+func serverTimeouts(c config.Config) httpEngine.Timeouts {
+	return httpEngine.Timeouts{
+		ReadHeader: settingDuration("http.read_header_timeout", c.Http.ReadHeaderTimeout),
+		Read:       settingDuration("http.read_timeout", c.Http.ReadTimeout),
+		Write:      settingDuration("http.write_timeout", c.Http.WriteTimeout),
+		Idle:       settingDuration("http.idle_timeout", c.Http.IdleTimeout),
+	}
+}
+
+// settingDuration parses the duration setting key, an empty or invalid one is zero and invalid ones are logged
+func settingDuration(key, text string) time.Duration {
+	if text == "" {
+		return 0
+	}
+	duration, err := time.ParseDuration(text)
+	if err != nil {
+		logger.Default().Warn("invalid duration", logger.Fields{"setting": key, "error": err})
+	}
+	return duration
+}
+
+// idempotencyTTL returns how long idempotent responses are replayed, 24 hours when not configured
+// and forever when zero
+func idempotencyTTL(c config.Config) time.Duration {
+	ttl, err := time.ParseDuration(c.Http.IdempotencyTTL)
+	if err != nil {
+		return 24 * time.Hour
+	}
+	return ttl
 }
@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/amupxm/pure-webserver/config"
+)
+
+// newTestWatcher returns a watcher of c which is never reloaded from a file
+func newTestWatcher(c config.Config) config.Watcher {
+	return config.NewWatcher(&config.Effective{Config: c}, nil, func(string) (string, bool) { return "", false })
+}
+
+func TestRouteTimeouts(t *testing.T) {
+	c := config.Defaults()
+	c.Http.RequestTimeout = "30s"
+	c.Http.RouteTimeouts = []string{"POST /v1/toys/_bulk=2m", "/v1/toys/search=5s", "GET /v1/toys/events=0"}
+	timeoutFor := routeTimeouts(newTestWatcher(c))
+	tests := []struct {
+		method string
+		route  string
+		want   time.Duration
+	}{
+		{"POST", "/v1/toys/_bulk", 2 * time.Minute},
+		{"GET", "/v1/toys/search", 5 * time.Second},
+		{"POST", "/v1/toys/search", 5 * time.Second},
+		// a route set to "=0" is exempt from the request timeout
+		{"GET", "/v1/toys/events", 0},
+		{"GET", "/v1/toys", 30 * time.Second},
+		{"GET", "/v1/toys/_bulk", 30 * time.Second},
+	}
+	for _, test := range tests {
+		if got := timeoutFor(test.method, test.route); got != test.want {
+			t.Errorf("timeout of %s %s is %s, want %s", test.method, test.route, got, test.want)
+		}
+	}
+}
+
+// pushWatcher hands configurations to its subscribers without validating them
+type pushWatcher struct {
+	config.Watcher
+	subscribers []func(old, new config.Config)
+}
+
+func (w *pushWatcher) Subscribe(callback func(old, new config.Config)) {
+	w.subscribers = append(w.subscribers, callback)
+}
+
+func (w *pushWatcher) push(c config.Config) {
+	for _, callback := range w.subscribers {
+		callback(w.Current(), c)
+	}
+}
+
+func TestCORSPolicyKeepsThePreviousPolicy(t *testing.T) {
+	c := config.Defaults()
+	c.Cors.AllowedOrigins = []string{"https://toys.example.com"}
+	watcher := &pushWatcher{Watcher: newTestWatcher(c)}
+	policy := corsPolicy(watcher)
+	tests := []struct {
+		name    string
+		origins []string
+		allowed string
+	}{
+		{"reload", []string{"https://cars.example.com"}, "https://cars.example.com"},
+		{"invalid reload", []string{"regex:("}, "https://cars.example.com"},
+		{"reload without origins", nil, ""},
+	}
+	for _, test := range tests {
+		c.Cors.AllowedOrigins = test.origins
+		watcher.push(c)
+		p := policy()
+		if test.allowed == "" {
+			if p != nil {
+				t.Errorf("%s: policy is %v, want none", test.name, p)
+			}
+			continue
+		}
+		if p == nil || !p.AllowOrigin(test.allowed) {
+			t.Errorf("%s: %s is not allowed by %v", test.name, test.allowed, p)
+		}
+	}
+}
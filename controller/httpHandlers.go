@@ -2,20 +2,36 @@ package controller
 
 import (
 	"errors"
+	"fmt"
+	"hash/fnv"
 
 	"github.com/amupxm/pure-webserver/constants"
 	"github.com/amupxm/pure-webserver/domain"
+	"github.com/amupxm/pure-webserver/pkg/database"
 	httpEngine "github.com/amupxm/pure-webserver/pkg/httpEngine"
+	"github.com/amupxm/pure-webserver/pkg/jsonpatch"
+)
+
+const (
+	// defaultChangesLimit is the page size of the change log without a limit query param
+	defaultChangesLimit = 100
+	// maxChangesLimit is the largest page of the change log
+	maxChangesLimit = 1000
 )
 
 // GetAll handler writes all products to output
 func (e *engine) GetAll(c *httpEngine.ServerContext) {
-	ee, err := e.ProductLogic.GetAllProducts()
+	ee, err := e.ProductLogic.GetAllProducts(c.Context())
 	if err != nil {
 		c.ErrorHandler(400, err)
 		return
 	}
-	c.JSON(200, ee)
+	etag := c.RepresentationETag(productsETag(ee))
+	c.SetETag(etag)
+	if c.NotModified(etag) {
+		return
+	}
+	c.Render(200, ee)
 }
 
 // GetOne handler writes one product by iid to output
@@ -26,12 +42,17 @@ func (e *engine) GetOne(c *httpEngine.ServerContext) {
 		c.ErrorHandler(400, err)
 		return
 	}
-	ee, err := e.ProductLogic.GetProductByID(id)
+	ee, err := e.ProductLogic.GetProductByID(c.Context(), id)
 	if err != nil {
 		c.ErrorHandler(400, errors.New(constants.NoData))
 		return
 	}
-	c.JSON(200, ee)
+	etag := c.RepresentationETag(productsETag(ee))
+	c.SetETag(etag)
+	if c.NotModified(etag) {
+		return
+	}
+	c.Render(200, ee)
 
 }
 
@@ -44,15 +65,34 @@ func (e *engine) CreateProduct(c *httpEngine.ServerContext) {
 		return
 	}
 	var product = &domain.Product{}
-	// check is valid json for product
-	err = c.BindToJson(product)
+	// check is valid json, xml or form for product
+	err = c.Bind(product)
 	if err != nil {
 		c.ErrorHandler(400, err)
 		return
 
 	}
 	product.Iid = id
-	res, err := e.ProductLogic.NewProduct(product)
+	versions, ok := e.checkPreconditions(c, id)
+	if !ok {
+		return
+	}
+	if c.Request.Header.Get("If-Match") != "" {
+		// a matching If-Match replaces the stored product instead of adding another one
+		res, err := e.ProductLogic.UpdateProduct(c.Context(), product, versions)
+		if errors.Is(err, database.ErrVersionConflict) {
+			c.ErrorHandler(412, errors.New(constants.Conflict))
+			return
+		}
+		if err != nil {
+			c.ErrorHandler(400, err)
+			return
+		}
+		c.SetETag(productsETag(&[]domain.Product{*res}))
+		c.JSON(200, res)
+		return
+	}
+	res, err := e.ProductLogic.NewProduct(c.Context(), product)
 	if err != nil {
 		c.ErrorHandler(400, err)
 		return
@@ -61,7 +101,8 @@ func (e *engine) CreateProduct(c *httpEngine.ServerContext) {
 	c.JSON(200, res)
 }
 
-// UpdateOne updates one product which exists in db
+// UpdateOne patches one product which exists in db,
+// the body is a json patch (application/json-patch+json) or a merge patch (application/merge-patch+json or application/json)
 func (e *engine) UpdateOne(c *httpEngine.ServerContext) {
 	// check iid exists or not
 	id, err := c.GetURLParam("iid")
@@ -70,21 +111,45 @@ func (e *engine) UpdateOne(c *httpEngine.ServerContext) {
 		return
 	}
 
-	var product = &domain.Product{}
-	// check is valid json for product
-	err = c.BindToJson(product)
+	body, err := c.ReadBody()
 	if err != nil {
 		c.ErrorHandler(400, err)
 		return
-
 	}
-	product.Iid = id
-	res, err := e.ProductLogic.UpdateProduct(product)
+	var patch jsonpatch.Patcher
+	// plain json bodies keep the fields they omit
+	if contentType := c.ContentType(); contentType == "" || contentType == "application/json" {
+		patch, err = jsonpatch.DecodeMergePatch(body)
+	} else {
+		patch, err = jsonpatch.Decode(contentType, body)
+	}
+	if errors.Is(err, jsonpatch.ErrUnsupportedMediaType) {
+		c.ErrorHandler(415, err)
+		return
+	}
+	if err != nil {
+		c.ErrorHandler(400, err)
+		return
+	}
+	versions, ok := e.checkPreconditions(c, id)
+	if !ok {
+		return
+	}
+	res, err := e.ProductLogic.PatchProduct(c.Context(), id, versions, patch)
+	if errors.Is(err, database.ErrVersionConflict) {
+		c.ErrorHandler(412, errors.New(constants.Conflict))
+		return
+	}
+	if errors.Is(err, jsonpatch.ErrTestFailed) {
+		c.ErrorHandler(409, err)
+		return
+	}
 	if err != nil {
 		c.ErrorHandler(400, err)
 		return
 
 	}
+	c.SetETag(productsETag(&[]domain.Product{*res}))
 	c.JSON(200, res)
 }
 
@@ -98,7 +163,15 @@ func (e *engine) DeleteOne(c *httpEngine.ServerContext) {
 	}
 	var product = &domain.Product{}
 	product.Iid = id
-	err = e.ProductLogic.DeleteProduct(product)
+	versions, ok := e.checkPreconditions(c, id)
+	if !ok {
+		return
+	}
+	err = e.ProductLogic.DeleteProduct(c.Context(), product, versions)
+	if errors.Is(err, database.ErrVersionConflict) {
+		c.ErrorHandler(412, errors.New(constants.Conflict))
+		return
+	}
 	if err != nil {
 		c.JSON(400,
 			map[string]string{
@@ -113,3 +186,78 @@ func (e *engine) DeleteOne(c *httpEngine.ServerContext) {
 		},
 	)
 }
+
+// Search handler writes products matching the q query param to output
+func (e *engine) Search(c *httpEngine.ServerContext) {
+	query, err := c.GetQueryParam("q")
+	if err != nil {
+		c.ErrorHandler(400, err)
+		return
+	}
+	res, err := e.ProductLogic.SearchProducts(c.Context(), query)
+	if err != nil {
+		c.ErrorHandler(400, err)
+		return
+	}
+	c.Render(200, res)
+}
+
+// Changes handler writes a page of the product change log after the from query param,
+// changes dropped from the log get 410 and the client should reload the products
+func (e *engine) Changes(c *httpEngine.ServerContext) {
+	query := struct {
+		From  int64 `query:"from"`
+		Limit int   `query:"limit"`
+	}{Limit: defaultChangesLimit}
+	if err := c.BindQuery(&query); err != nil {
+		c.ErrorHandler(400, err)
+		return
+	}
+	if query.From < 0 || query.Limit < 1 || query.Limit > maxChangesLimit {
+		c.ErrorHandler(400, errors.New(constants.NoQuery))
+		return
+	}
+	page, err := e.ProductLogic.ProductChanges(c.Context(), query.From, query.Limit)
+	if errors.Is(err, database.ErrChangesTrimmed) {
+		c.ErrorHandler(410, err)
+		return
+	}
+	if err != nil {
+		c.ErrorHandler(400, err)
+		return
+	}
+	c.JSON(200, page)
+}
+
+// checkPreconditions evaluates If-Match and If-None-Match against the stored products with iid,
+// with If-Match it returns the versions of the products the etag was computed from,
+// the write compares and swaps all of them so a product written in between fails it
+func (e *engine) checkPreconditions(c *httpEngine.ServerContext, iid string) (map[string]int64, bool) {
+	var etag string
+	current, err := e.ProductLogic.GetProductByID(c.Context(), iid)
+	if err == nil && len(*current) != 0 {
+		etag = productsETag(current)
+	}
+	if c.PreconditionFailed(etag) {
+		return nil, false
+	}
+	if c.Request.Header.Get("If-Match") == "" || etag == "" {
+		return nil, true
+	}
+	versions := make(map[string]int64, len(*current))
+	for _, product := range *current {
+		versions[product.Id] = product.Version
+	}
+	return versions, true
+}
+
+// productsETag returns an entity tag which changes whenever one of products is written
+func productsETag(products *[]domain.Product) string {
+	hash := fnv.New64a()
+	if products != nil {
+		for _, product := range *products {
+			fmt.Fprintf(hash, "%s:%d;", product.Id, product.Version)
+		}
+	}
+	return httpEngine.ETag(fmt.Sprintf("%x", hash.Sum64()))
+}
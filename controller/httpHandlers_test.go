@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/amupxm/pure-webserver/domain"
+	"github.com/amupxm/pure-webserver/logic"
+	"github.com/amupxm/pure-webserver/pkg/database"
+	httpEngine "github.com/amupxm/pure-webserver/pkg/httpEngine"
+	"github.com/amupxm/pure-webserver/repository"
+)
+
+func TestChangesPages(t *testing.T) {
+	db := database.NewDatabase(database.WithBucketName(filepath.Join(t.TempDir(), "database.json")))
+	// the log keeps the changes 3 to 5
+	pl := logic.NewProductLogic(repository.NewProductRepository(db, 3))
+	for i := 1; i <= 5; i++ {
+		if _, err := pl.NewProduct(context.Background(), &domain.Product{Name: "car", Iid: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := httpEngine.NewServer()
+	s.AddHandler("/v1/toys/changes", http.MethodGet, NewEngine(pl, nil).Changes)
+	tests := []struct {
+		name  string
+		query string
+		code  int
+		seqs  []int64
+		next  int64
+		more  bool
+	}{
+		{"first page", "?from=2&limit=2", 200, []int64{3, 4}, 4, true},
+		{"last page", "?from=4&limit=2", 200, []int64{5}, 5, false},
+		{"exact last page", "?from=3&limit=2", 200, []int64{4, 5}, 5, false},
+		{"up to date", "?from=5", 200, nil, 5, false},
+		{"default limit", "?from=2", 200, []int64{3, 4, 5}, 5, false},
+		{"largest limit", "?from=2&limit=" + strconv.Itoa(maxChangesLimit), 200, []int64{3, 4, 5}, 5, false},
+		{"trimmed", "?from=1", 410, nil, 0, false},
+		{"trimmed without from", "", 410, nil, 0, false},
+		{"zero limit", "?from=2&limit=0", 400, nil, 0, false},
+		{"limit over the largest", "?from=2&limit=" + strconv.Itoa(maxChangesLimit+1), 400, nil, 0, false},
+		{"negative from", "?from=-1", 400, nil, 0, false},
+		{"invalid limit", "?from=2&limit=x", 400, nil, 0, false},
+	}
+	for _, test := range tests {
+		response := httptest.NewRecorder()
+		s.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/v1/toys/changes"+test.query, nil))
+		if response.Code != test.code {
+			t.Errorf("%s: code %d, want %d: %s", test.name, response.Code, test.code, response.Body)
+			continue
+		}
+		if test.code != 200 {
+			continue
+		}
+		var page domain.ProductChangePage
+		if err := json.Unmarshal(response.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		var seqs []int64
+		for _, change := range page.Changes {
+			seqs = append(seqs, change.Seq)
+		}
+		if !reflect.DeepEqual(seqs, test.seqs) || page.Next != test.next || page.More != test.more {
+			t.Errorf("%s: changes %v, next %d, more %v, want %v, %d, %v", test.name, seqs, page.Next, page.More, test.seqs, test.next, test.more)
+		}
+	}
+}
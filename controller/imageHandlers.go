@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/amupxm/pure-webserver/constants"
+	"github.com/amupxm/pure-webserver/domain"
+	"github.com/amupxm/pure-webserver/pkg/blob"
+	httpEngine "github.com/amupxm/pure-webserver/pkg/httpEngine"
+)
+
+// UploadImages stores the files of a multipart body as they stream in and links them to the product
+func (e *engine) UploadImages(c *httpEngine.ServerContext) {
+	id, err := c.GetURLParam("iid")
+	if err != nil {
+		c.ErrorHandler(400, err)
+		return
+	}
+	products, err := e.ProductLogic.GetProductByID(c.Context(), id)
+	if err != nil || len(*products) == 0 {
+		c.ErrorHandler(400, errors.New(constants.NoData))
+		return
+	}
+	var images []blob.Info
+	err = c.StreamMultipart(func(part *multipart.Part) error {
+		// only files are stored, other fields are skipped
+		if part.FileName() == "" {
+			return nil
+		}
+		info, err := e.Images.Put(c.Context(), part)
+		if err != nil {
+			return err
+		}
+		images = append(images, info)
+		return nil
+	})
+	switch {
+	case errors.Is(err, blob.ErrTooLarge):
+		c.ErrorHandler(413, err)
+		return
+	case errors.Is(err, blob.ErrTypeNotAllowed):
+		c.ErrorHandler(415, err)
+		return
+	case err != nil:
+		c.ErrorHandler(400, err)
+		return
+	case len(images) == 0:
+		c.ErrorHandler(400, errors.New(constants.NoImage))
+		return
+	}
+	names := make([]string, len(images))
+	for i, image := range images {
+		names[i] = image.Name
+	}
+	product, err := e.ProductLogic.AddProductImages(c.Context(), id, names)
+	if err != nil {
+		c.ErrorHandler(400, err)
+		return
+	}
+	c.JSON(201, map[string]interface{}{
+		"images":  images,
+		"product": product,
+	})
+}
+
+// GetImage writes an image of the product, with support for ranges and conditional requests
+func (e *engine) GetImage(c *httpEngine.ServerContext) {
+	id, err := c.GetURLParam("iid")
+	if err != nil {
+		c.ErrorHandler(400, err)
+		return
+	}
+	name, err := c.GetURLParam("name")
+	if err != nil {
+		c.ErrorHandler(400, err)
+		return
+	}
+	products, err := e.ProductLogic.GetProductByID(c.Context(), id)
+	if err != nil || !hasImage(*products, name) {
+		c.ErrorHandler(404, errors.New(constants.ImageNotFound))
+		return
+	}
+	file, info, err := e.Images.Open(c.Context(), name)
+	if errors.Is(err, blob.ErrNotFound) {
+		c.ErrorHandler(404, errors.New(constants.ImageNotFound))
+		return
+	}
+	if err != nil {
+		c.ErrorHandler(500, err)
+		return
+	}
+	defer file.Close()
+	header := c.Response.Header()
+	header.Set("Content-Type", info.ContentType)
+	header.Set("X-Content-Type-Options", "nosniff")
+	// names are content addresses, so an image never changes
+	header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	c.SetETag(httpEngine.ETag(strings.SplitN(name, ".", 2)[0]))
+	http.ServeContent(c.Response, c.Request, name, info.ModTime, file)
+}
+
+// hasImage reports whether one of products links the image called name
+func hasImage(products []domain.Product, name string) bool {
+	for _, product := range products {
+		for _, image := range product.Images {
+			if image == name {
+				return true
+			}
+		}
+	}
+	return false
+}
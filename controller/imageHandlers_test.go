@@ -0,0 +1,199 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"path/filepath"
+	"testing"
+
+	"github.com/amupxm/pure-webserver/domain"
+	"github.com/amupxm/pure-webserver/logic"
+	"github.com/amupxm/pure-webserver/pkg/blob"
+	"github.com/amupxm/pure-webserver/pkg/database"
+	httpEngine "github.com/amupxm/pure-webserver/pkg/httpEngine"
+	"github.com/amupxm/pure-webserver/repository"
+)
+
+// pngImage is a png signature followed by filler, enough for content sniffing
+var pngImage = pngOfSize(108)
+
+// pngOfSize returns a png signature followed by filler up to size bytes
+func pngOfSize(size int) []byte {
+	return append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, size-8)...)
+}
+
+// imageFile is one part of an upload
+type imageFile struct {
+	field       string
+	fileName    string
+	contentType string
+	content     []byte
+}
+
+// newImageServer returns a server with the image routes over a temporary database holding the product "toy",
+// images up to 1KB of png and gif are accepted and bodies are limited to 4KB
+func newImageServer(t *testing.T) (httpEngine.Server, logic.ProductLogic, blob.Store) {
+	t.Helper()
+	dir := t.TempDir()
+	db := database.NewDatabase(database.WithBucketName(filepath.Join(dir, "database.json")))
+	pl := logic.NewProductLogic(repository.NewProductRepository(db, 0))
+	if _, err := pl.NewProduct(context.Background(), &domain.Product{Name: "car", Brand: "toyz", Iid: "toy"}); err != nil {
+		t.Fatal(err)
+	}
+	images, err := blob.NewLocalStore(filepath.Join(dir, "images"), blob.WithMaxSize(1024), blob.WithAllowedTypes("image/png", "image/gif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	en := NewEngine(pl, images)
+	s := httpEngine.NewServer()
+	s.Use(httpEngine.BodyLimit(func(method, route string) int64 { return 4096 }))
+	s.AddHandler("/v1/toys/:iid/images", http.MethodPost, en.UploadImages)
+	s.AddHandler("/v1/toys/:iid/images/:name", http.MethodGet, en.GetImage)
+	return s, pl, images
+}
+
+// upload posts files as a multipart body to the images of the product iid
+func upload(s httpEngine.Server, iid string, files ...imageFile) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, file := range files {
+		header := textproto.MIMEHeader{}
+		disposition := `form-data; name="` + file.field + `"`
+		if file.fileName != "" {
+			disposition += `; filename="` + file.fileName + `"`
+		}
+		header.Set("Content-Disposition", disposition)
+		if file.contentType != "" {
+			header.Set("Content-Type", file.contentType)
+		}
+		part, _ := w.CreatePart(header)
+		part.Write(file.content)
+	}
+	w.Close()
+	r := httptest.NewRequest(http.MethodPost, "/v1/toys/"+iid+"/images", &body)
+	r.Header.Set("Content-Type", w.FormDataContentType())
+	response := httptest.NewRecorder()
+	s.ServeHTTP(response, r)
+	return response
+}
+
+func TestUploadImages(t *testing.T) {
+	s, _, _ := newImageServer(t)
+	png := imageFile{"image", "car.png", "image/png", pngImage}
+	medium := imageFile{"image", "medium.png", "image/png", pngOfSize(900)}
+	tests := []struct {
+		name  string
+		iid   string
+		files []imageFile
+		code  int
+	}{
+		{"png", "toy", []imageFile{png}, http.StatusCreated},
+		{"fields are skipped", "toy", []imageFile{{"title", "", "", []byte("a car")}, png}, http.StatusCreated},
+		{"only fields", "toy", []imageFile{{"title", "", "", []byte("a car")}}, http.StatusBadRequest},
+		{"missing product", "boat", []imageFile{png}, http.StatusBadRequest},
+		{"too large", "toy", []imageFile{{"image", "big.png", "image/png", pngOfSize(2048)}}, http.StatusRequestEntityTooLarge},
+		// every image is small enough, together they exceed the body limit
+		{"body over the limit", "toy", []imageFile{medium, medium, medium, medium, medium}, http.StatusRequestEntityTooLarge},
+		// the type is sniffed from the content, the declared one is ignored
+		{"text declared as png", "toy", []imageFile{{"image", "car.png", "image/png", []byte("just some text, no image at all")}}, http.StatusUnsupportedMediaType},
+		{"jpeg not allowed", "toy", []imageFile{{"image", "car.jpg", "image/jpeg", append([]byte("\xff\xd8\xff"), make([]byte, 100)...)}}, http.StatusUnsupportedMediaType},
+	}
+	for _, test := range tests {
+		if response := upload(s, test.iid, test.files...); response.Code != test.code {
+			t.Errorf("%s: code %d, want %d: %s", test.name, response.Code, test.code, response.Body)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/toys/toy/images", bytes.NewReader(pngImage))
+	r.Header.Set("Content-Type", "image/png")
+	response := httptest.NewRecorder()
+	s.ServeHTTP(response, r)
+	if response.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("an image which is not multipart: code %d, want %d", response.Code, http.StatusUnsupportedMediaType)
+	}
+}
+
+func TestUploadImagesLinksImagesOnce(t *testing.T) {
+	s, pl, _ := newImageServer(t)
+	gif := imageFile{"image", "car.gif", "image/gif", append([]byte("GIF89a"), make([]byte, 100)...)}
+	png := imageFile{"image", "car.png", "image/png", pngImage}
+	for i, files := range [][]imageFile{{png, png}, {png, gif}, {gif}} {
+		if response := upload(s, "toy", files...); response.Code != http.StatusCreated {
+			t.Fatalf("upload %d: code %d: %s", i, response.Code, response.Body)
+		}
+	}
+	products, err := pl.GetProductByID(context.Background(), "toy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := (*products)[0].Images
+	if len(images) != 2 || images[0] == images[1] {
+		t.Fatalf("the product links %v, want the png and the gif once", images)
+	}
+	if filepath.Ext(images[0]) != ".png" || filepath.Ext(images[1]) != ".gif" {
+		t.Errorf("the product links %v, want them in upload order", images)
+	}
+
+	// the response holds the stored images and the linked product
+	response := upload(s, "toy", png)
+	var created struct {
+		Images  []blob.Info    `json:"images"`
+		Product domain.Product `json:"product"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if len(created.Images) != 1 || created.Images[0].Name != images[0] || len(created.Product.Images) != 2 {
+		t.Errorf("upload response is %s", response.Body)
+	}
+}
+
+func TestGetImage(t *testing.T) {
+	s, _, images := newImageServer(t)
+	response := upload(s, "toy", imageFile{"image", "car.png", "image/png", pngImage})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("upload: code %d: %s", response.Code, response.Body)
+	}
+	var created struct {
+		Images []blob.Info `json:"images"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	linked := created.Images[0].Name
+	// an image which is stored but not linked to the product
+	unlinked, err := images.Put(context.Background(), bytes.NewReader(append([]byte("GIF89a"), make([]byte, 100)...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		target string
+		code   int
+	}{
+		{"linked image", "/v1/toys/toy/images/" + linked, http.StatusOK},
+		{"image of another product", "/v1/toys/boat/images/" + linked, http.StatusNotFound},
+		{"stored image not linked", "/v1/toys/toy/images/" + unlinked.Name, http.StatusNotFound},
+		{"missing image", "/v1/toys/toy/images/missing.png", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		response := httptest.NewRecorder()
+		s.ServeHTTP(response, httptest.NewRequest(http.MethodGet, test.target, nil))
+		if response.Code != test.code {
+			t.Errorf("%s: code %d, want %d", test.name, response.Code, test.code)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/toys/toy/images/"+linked, nil)
+	r.Header.Set("Range", "bytes=0-7")
+	response = httptest.NewRecorder()
+	s.ServeHTTP(response, r)
+	if response.Code != http.StatusPartialContent || response.Body.String() != string(pngImage[:8]) || response.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("range of the image: %d %s %q", response.Code, response.Header().Get("Content-Type"), response.Body)
+	}
+}
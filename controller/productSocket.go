@@ -0,0 +1,192 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	httpEngine "github.com/amupxm/pure-webserver/pkg/httpEngine"
+	"github.com/amupxm/pure-webserver/pkg/logger"
+	"github.com/amupxm/pure-webserver/pkg/websocket"
+)
+
+type (
+	// socketCommand is a message of a client of the product socket
+	socketCommand struct {
+		// Action is subscribe or unsubscribe
+		Action string `json:"action"`
+		// Ids are the products, none means every product
+		Ids []string `json:"ids"`
+	}
+	// socketMessage is a message sent to clients of the product socket,
+	// its type is change, reset, subscribed or error
+	socketMessage struct {
+		Type string `json:"type"`
+		*productEvent
+		All     bool     `json:"all,omitempty"`
+		Ids     []string `json:"ids,omitempty"`
+		Message string   `json:"message,omitempty"`
+	}
+	// socketSubscription are the products a client receives changes of
+	socketSubscription struct {
+		all bool
+		ids map[string]bool
+	}
+)
+
+// productSocketMaxMessage limits the messages of clients, they only send commands
+const productSocketMaxMessage = 64 << 10
+
+// productSocketOptions accepts handshakes from the same origin or origins allowed by cors,
+// clients are pinged every heartbeat and dropped when they miss two pings
+func productSocketOptions(heartbeat time.Duration, cors func() *httpEngine.CORSPolicy) websocket.Options {
+	return websocket.Options{
+		MaxMessageSize: productSocketMaxMessage,
+		IdleTimeout:    3 * heartbeat,
+		WriteTimeout:   10 * time.Second,
+		CheckOrigin: func(origin, host string) bool {
+			if websocket.SameOrigin(origin, host) {
+				return true
+			}
+			policy := cors()
+			return policy != nil && policy.AllowOrigin(origin)
+		},
+	}
+}
+
+// serveSocket sends the changes of the products a client subscribed to. Clients send
+// {"action": "subscribe", "ids": ["1"]} and {"action": "unsubscribe", "ids": ["1"]}, without ids for every product.
+// Changes are watched from the first subscribe, a client reconnecting with ?from= set to the last seq it received
+// gets the changes it missed after its first subscribe
+func (e *productEvents) serveSocket(c *httpEngine.ServerContext, conn *websocket.Conn, heartbeat time.Duration) {
+	from, _ := c.GetQueryParam("from")
+	ctx, cancel := context.WithCancel(c.Context())
+	defer cancel()
+	// stream stays nil until the first subscribe
+	var stream <-chan productEvent
+
+	commands := make(chan socketCommand)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(commands)
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			var command socketCommand
+			if messageType != websocket.TextMessage || json.Unmarshal(message, &command) != nil {
+				command = socketCommand{Action: "invalid"}
+			}
+			select {
+			case commands <- command:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var tick <-chan time.Time
+	if heartbeat > 0 {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	subscription := socketSubscription{ids: make(map[string]bool)}
+	for {
+		var err error
+		select {
+		case command, ok := <-commands:
+			if !ok {
+				logSocketClosed(c, <-readErr)
+				return
+			}
+			if !subscription.apply(command) {
+				err = sendSocket(conn, socketMessage{Type: "error", Message: "unknown command, send {\"action\": \"subscribe\"} or {\"action\": \"unsubscribe\"}"})
+				break
+			}
+			err = sendSocket(conn, socketMessage{Type: "subscribed", All: subscription.all, Ids: subscription.list()})
+			if command.Action == "subscribe" && stream == nil && err == nil {
+				stream, err = e.watch(ctx, from)
+			}
+		case event, ok := <-stream:
+			if !ok {
+				conn.Close(websocket.CloseTryAgainLater, "too slow, reconnect with ?from= set to the last seq")
+				return
+			}
+			err = subscription.send(conn, event)
+		case <-tick:
+			err = conn.Ping(nil)
+		}
+		if err != nil {
+			logSocketClosed(c, err)
+			return
+		}
+	}
+}
+
+// apply changes the subscription by command, it reports false for unknown actions
+func (s *socketSubscription) apply(command socketCommand) bool {
+	switch command.Action {
+	case "subscribe":
+		if len(command.Ids) == 0 {
+			s.all = true
+		}
+		for _, id := range command.Ids {
+			s.ids[id] = true
+		}
+	case "unsubscribe":
+		if len(command.Ids) == 0 {
+			s.all = false
+			s.ids = make(map[string]bool)
+		}
+		for _, id := range command.Ids {
+			delete(s.ids, id)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// list returns the ids of the subscription
+func (s *socketSubscription) list() []string {
+	ids := make([]string, 0, len(s.ids))
+	for id := range s.ids {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// send writes event when the subscription covers its product, resets are always written
+func (s *socketSubscription) send(conn *websocket.Conn, event productEvent) error {
+	if event.Kind == resetEvent {
+		return sendSocket(conn, socketMessage{Type: "reset", productEvent: &event})
+	}
+	if !s.all && !s.ids[event.Id] {
+		return nil
+	}
+	return sendSocket(conn, socketMessage{Type: "change", productEvent: &event})
+}
+
+// sendSocket writes message as json
+func sendSocket(conn *websocket.Conn, message socketMessage) error {
+	b, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.TextMessage, b)
+}
+
+// logSocketClosed logs why a product socket ended, clients closing it normally are not logged
+func logSocketClosed(c *httpEngine.ServerContext, err error) {
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) && (closeErr.Code == websocket.CloseNormal || closeErr.Code == websocket.CloseGoingAway) {
+		return
+	}
+	logger.Default().Debug("product socket closed", logger.Fields{"error": err, "request_id": c.RequestID})
+}
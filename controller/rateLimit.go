@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/amupxm/pure-webserver/config"
+	"github.com/amupxm/pure-webserver/pkg/database"
+	httpEngine "github.com/amupxm/pure-webserver/pkg/httpEngine"
+	"github.com/amupxm/pure-webserver/pkg/logger"
+	"github.com/amupxm/pure-webserver/pkg/ratelimit"
+)
+
+// rateLimitFlushInterval is how often the database store persists changed counters
+const rateLimitFlushInterval = 5 * time.Second
+
+// newRateLimit creates the rate limit middleware from the configuration, nil when rate limiting is disabled.
+// stop ends the flushes of the database store after a last one, it returns once the counters are persisted
+func newRateLimit(db database.Database, watcher config.Watcher) (middleware httpEngine.Middleware, stop func(), err error) {
+	stop = func() {}
+	c := watcher.Current().RateLimit
+	if !c.Enabled {
+		return nil, stop, nil
+	}
+	idle := rateLimitIdle(watcher.Current())
+	var store ratelimit.Store = ratelimit.NewMemoryStore(idle)
+	if c.Store == "database" {
+		databaseStore, err := ratelimit.NewDatabaseStore(context.Background(), db, idle)
+		if err != nil {
+			return nil, stop, err
+		}
+		stopFlushes, flushed := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(flushed)
+			databaseStore.Run(rateLimitFlushInterval, stopFlushes)
+		}()
+		var once sync.Once
+		stop = func() {
+			once.Do(func() { close(stopFlushes) })
+			<-flushed
+		}
+		store = databaseStore
+	}
+	limiter, err := ratelimit.NewLimiter(store, ratelimit.Algorithm(c.Algorithm))
+	if err != nil {
+		stop()
+		return nil, func() {}, err
+	}
+	var key httpEngine.KeyFunc
+	switch c.Key {
+	case "api_key":
+		key = httpEngine.KeyByAPIKey(c.APIKeyHeader)
+	case "jwt_sub":
+		key = httpEngine.KeyByJWTSubject()
+	default:
+		key = httpEngine.KeyByIP()
+	}
+	return httpEngine.RateLimit(limiter, routeLimits(watcher), key), stop, nil
+}
+
+// routeLimits returns the rate limit of a route by its method and pattern from the configuration,
+// the limits follow configuration reloads
+func routeLimits(watcher config.Watcher) func(method, route string) (ratelimit.Limit, bool) {
+	type limits struct {
+		fallback *ratelimit.Limit
+		routes   map[string]*ratelimit.Limit
+	}
+	var current atomic.Value
+	load := func(c config.Config) {
+		var fallback *ratelimit.Limit
+		if limit, err := ratelimit.ParseLimit(c.RateLimit.Default); err == nil {
+			fallback = &limit
+		}
+		routes, err := config.ParseRouteLimits(c.RateLimit.Routes)
+		if err != nil {
+			logger.Default().Warn("invalid route rate limits", logger.Fields{"error": err})
+		}
+		current.Store(limits{fallback: fallback, routes: routes})
+	}
+	load(watcher.Current())
+	watcher.Subscribe(func(_, new config.Config) {
+		load(new)
+	})
+	return func(method, route string) (ratelimit.Limit, bool) {
+		l := current.Load().(limits)
+		limit, ok := l.routes[method+" "+route]
+		if !ok {
+			limit, ok = l.routes[route]
+		}
+		if !ok {
+			limit = l.fallback
+		}
+		if limit == nil {
+			return ratelimit.Limit{}, false
+		}
+		return *limit, true
+	}
+}
+
+// rateLimitIdle returns how long counters of idle clients are kept, twice the longest configured window
+// and at least a minute
+func rateLimitIdle(c config.Config) time.Duration {
+	idle := time.Minute
+	limits, err := config.ParseRouteLimits(c.RateLimit.Routes)
+	if err != nil {
+		limits = make(map[string]*ratelimit.Limit)
+	}
+	if limit, err := ratelimit.ParseLimit(c.RateLimit.Default); err == nil {
+		limits[""] = &limit
+	}
+	for _, limit := range limits {
+		if limit != nil && 2*limit.Window > idle {
+			idle = 2 * limit.Window
+		}
+	}
+	return idle
+}
@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/amupxm/pure-webserver/config"
+	"github.com/amupxm/pure-webserver/pkg/database"
+	httpEngine "github.com/amupxm/pure-webserver/pkg/httpEngine"
+	"github.com/amupxm/pure-webserver/pkg/ratelimit"
+)
+
+func TestRateLimitStopFlushes(t *testing.T) {
+	db := database.NewDatabase(database.WithBucketName(filepath.Join(t.TempDir(), "database.json")))
+	c := config.Defaults()
+	c.RateLimit.Enabled = true
+	c.RateLimit.Store = "database"
+	c.RateLimit.Default = "10/1m"
+	middleware, stop, err := newRateLimit(db, newTestWatcher(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httpEngine.NewServer()
+	s.Use(middleware)
+	s.AddHandler("/v1/toys", http.MethodGet, func(c *httpEngine.ServerContext) {})
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/toys", nil))
+
+	// the request is counted long before the next periodic flush, stop persists it
+	stop()
+	stop()
+	var record *ratelimit.Record
+	records, err := db.GetFromCollection(context.Background(), record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Errorf("%d rate limit states were persisted on stop, want 1", len(records))
+	}
+}
@@ -0,0 +1,29 @@
+package controller
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/amupxm/pure-webserver/config"
+	httpEngine "github.com/amupxm/pure-webserver/pkg/httpEngine"
+)
+
+// uiFiles is the admin ui built into the binary
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// registerUI serves the admin ui under ui.prefix, from ui.dir when set or else from the files built into the binary
+func registerUI(server httpEngine.Server, c config.Config) {
+	if c.UI.Prefix == "" {
+		return
+	}
+	var files fs.FS = os.DirFS(c.UI.Dir)
+	if c.UI.Dir == "" {
+		files, _ = fs.Sub(uiFiles, "ui")
+	}
+	maxAge, _ := time.ParseDuration(c.UI.MaxAge)
+	server.Static(c.UI.Prefix, files, httpEngine.StaticOptions{SPA: c.UI.SPA, MaxAge: maxAge})
+}
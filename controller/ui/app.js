@@ -0,0 +1,12 @@
+// lists the toys of the api in the table
+fetch("/v1/toys")
+	.then((response) => response.json())
+	.then((toys) => {
+		const body = document.getElementById("toys");
+		for (const toy of toys || []) {
+			const row = body.insertRow();
+			for (const field of ["iid", "name", "brand", "company", "version"]) {
+				row.insertCell().textContent = toy[field];
+			}
+		}
+	});
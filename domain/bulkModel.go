@@ -0,0 +1,27 @@
+package domain
+
+import "encoding/json"
+
+type (
+	// BulkOperation is one create, update or delete of a bulk request
+	BulkOperation struct {
+		// Op is one of BulkCreate, BulkUpdate and BulkDelete
+		Op  string `json:"op"`
+		Iid string `json:"iid"`
+		// Version must match the stored product when non zero
+		Version int64 `json:"version,omitempty"`
+		// Product is the new product on create and a merge patch on update
+		Product json.RawMessage `json:"product,omitempty"`
+	}
+	// BulkResult is the outcome of one bulk operation
+	BulkResult struct {
+		Products []Product
+		Err      error
+	}
+)
+
+const (
+	BulkCreate = "create"
+	BulkUpdate = "update"
+	BulkDelete = "delete"
+)
@@ -0,0 +1,27 @@
+package domain
+
+import "time"
+
+type (
+	// ProductChange is one entry of the change log of products
+	ProductChange struct {
+		// Seq orders changes, it only increases
+		Seq int64 `json:"seq"`
+		// Kind is insert, update or delete
+		Kind string `json:"kind"`
+		Id   string `json:"id"`
+		// Before is the product before the change, nil for inserts
+		Before *Product `json:"before"`
+		// After is the product after the change, nil for deletes
+		After *Product  `json:"after"`
+		Time  time.Time `json:"time"`
+	}
+	// ProductChangePage is a page of the change log of products
+	ProductChangePage struct {
+		Changes []ProductChange `json:"changes"`
+		// Next is the seq to read the following page from
+		Next int64 `json:"next"`
+		// More is set when changes after Next were already written
+		More bool `json:"more"`
+	}
+)
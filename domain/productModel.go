@@ -9,4 +9,6 @@ type Product struct {
 	Company string `json:"company"`
 	Iid     string `json:"iid"`
 	Id      string `json:"id"`
+	// Images are the names of the blobs of the product images
+	Images []string `json:"images,omitempty"`
 }
@@ -1,21 +1,34 @@
 package logic
 
 import (
-	"fmt"
+	"context"
+	"errors"
 
+	"github.com/amupxm/pure-webserver/constants"
 	"github.com/amupxm/pure-webserver/domain"
+	"github.com/amupxm/pure-webserver/pkg/jsonpatch"
+	"github.com/amupxm/pure-webserver/pkg/logger"
+	"github.com/amupxm/pure-webserver/pkg/tracing"
 	"github.com/amupxm/pure-webserver/repository"
 )
 
+// bulkBatchSize is the number of operations written at once by non atomic bulk requests
+const bulkBatchSize = 500
+
 type (
 
 	// ProductLogic is the business logic for products
 	ProductLogic interface {
-		NewProduct(product *domain.Product) (*domain.Product, error)
-		GetProductByID(id string) (*[]domain.Product, error)
-		GetAllProducts() (*[]domain.Product, error)
-		DeleteProduct(product *domain.Product) error
-		UpdateProduct(product *domain.Product) (*domain.Product, error)
+		NewProduct(ctx context.Context, product *domain.Product) (*domain.Product, error)
+		GetProductByID(ctx context.Context, id string) (*[]domain.Product, error)
+		GetAllProducts(ctx context.Context) (*[]domain.Product, error)
+		DeleteProduct(ctx context.Context, product *domain.Product, versions map[string]int64) error
+		UpdateProduct(ctx context.Context, product *domain.Product, versions map[string]int64) (*domain.Product, error)
+		PatchProduct(ctx context.Context, iid string, versions map[string]int64, patch jsonpatch.Patcher) (*domain.Product, error)
+		SearchProducts(ctx context.Context, query string) (*[]domain.Product, error)
+		BulkProducts(ctx context.Context, operations []domain.BulkOperation, atomic bool) ([]domain.BulkResult, error)
+		AddProductImages(ctx context.Context, iid string, names []string) (*domain.Product, error)
+		ProductChanges(ctx context.Context, fromSeq int64, limit int) (*domain.ProductChangePage, error)
 	}
 	productLogic struct {
 		productRepository repository.ProductRepository
@@ -29,43 +42,190 @@ func NewProductLogic(productRepository repository.ProductRepository) ProductLogi
 }
 
 // NewProduct creates a new product
-func (pl *productLogic) NewProduct(product *domain.Product) (*domain.Product, error) {
+func (pl *productLogic) NewProduct(ctx context.Context, product *domain.Product) (*domain.Product, error) {
+	ctx, span := tracing.Start(ctx, "logic.NewProduct")
+	defer span.End()
+	span.SetAttribute("product.iid", product.Iid)
 	// TODO : check for duplicated iid
-	result, err := pl.productRepository.CreateProduct(product)
+	result, err := pl.productRepository.CreateProduct(ctx, product)
 	if err != nil {
-		fmt.Println(1, result)
-
+		span.RecordError(err)
+		logger.Default().Warn("can not create product", logger.Fields{"iid": product.Iid, "error": err})
 		return nil, err
 	}
-	fmt.Println(1, result)
+	logger.Default().Debug("product created", logger.Fields{"iid": result.Iid, "id": result.Id})
 
 	return result, nil
 }
 
 // GetProductByID returns product with iid
-func (pl *productLogic) GetProductByID(id string) (*[]domain.Product, error) {
-	list, err := pl.productRepository.GetProductByID(id)
+func (pl *productLogic) GetProductByID(ctx context.Context, id string) (*[]domain.Product, error) {
+	ctx, span := tracing.Start(ctx, "logic.GetProductByID")
+	defer span.End()
+	span.SetAttribute("product.iid", id)
+	list, err := pl.productRepository.GetProductByID(ctx, id)
 	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
 	return &list, nil
 }
 
-func (pl *productLogic) GetAllProducts() (*[]domain.Product, error) {
+func (pl *productLogic) GetAllProducts(ctx context.Context) (*[]domain.Product, error) {
+	ctx, span := tracing.Start(ctx, "logic.GetAllProducts")
+	defer span.End()
 	var list *domain.Product
-	result, err := pl.productRepository.GetAllProducts(list)
+	result, err := pl.productRepository.GetAllProducts(ctx, list)
 	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
 	return result, nil
 }
-func (pl *productLogic) UpdateProduct(product *domain.Product) (*domain.Product, error) {
-	result, err := pl.productRepository.UpdateProduct(product)
+
+// UpdateProduct replaces the products with product.Iid, non nil versions must match the stored ones
+func (pl *productLogic) UpdateProduct(ctx context.Context, product *domain.Product, versions map[string]int64) (*domain.Product, error) {
+	ctx, span := tracing.Start(ctx, "logic.UpdateProduct")
+	defer span.End()
+	span.SetAttribute("product.iid", product.Iid)
+	result, err := pl.productRepository.UpdateProduct(ctx, product, versions)
 	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
 	return result, nil
 }
-func (pl *productLogic) DeleteProduct(product *domain.Product) error {
-	return pl.productRepository.DeleteProduct(product.Iid)
+
+// PatchProduct applies a merge patch or json patch to the product with iid atomically
+func (pl *productLogic) PatchProduct(ctx context.Context, iid string, versions map[string]int64, patch jsonpatch.Patcher) (*domain.Product, error) {
+	ctx, span := tracing.Start(ctx, "logic.PatchProduct")
+	defer span.End()
+	span.SetAttribute("product.iid", iid)
+	result, err := pl.productRepository.PatchProduct(ctx, iid, versions, patch)
+	span.RecordError(err)
+	return result, err
+}
+func (pl *productLogic) DeleteProduct(ctx context.Context, product *domain.Product, versions map[string]int64) error {
+	ctx, span := tracing.Start(ctx, "logic.DeleteProduct")
+	defer span.End()
+	span.SetAttribute("product.iid", product.Iid)
+	err := pl.productRepository.DeleteProduct(ctx, product.Iid, versions)
+	span.RecordError(err)
+	return err
+}
+
+// SearchProducts returns products whose name, brand or company match query
+func (pl *productLogic) SearchProducts(ctx context.Context, query string) (*[]domain.Product, error) {
+	ctx, span := tracing.Start(ctx, "logic.SearchProducts")
+	defer span.End()
+	result, err := pl.productRepository.SearchProducts(ctx, query)
+	span.RecordError(err)
+	return result, err
+}
+
+// BulkProducts applies create, update and delete operations in batches,
+// atomic requests are written in a single batch which fails as a whole
+func (pl *productLogic) BulkProducts(ctx context.Context, operations []domain.BulkOperation, atomic bool) ([]domain.BulkResult, error) {
+	ctx, span := tracing.Start(ctx, "logic.BulkProducts")
+	defer span.End()
+	span.SetAttribute("bulk.operations", len(operations))
+	span.SetAttribute("bulk.atomic", atomic)
+	if atomic {
+		results, err := pl.productRepository.BulkWrite(ctx, operations, true)
+		span.RecordError(err)
+		if err != nil && ctx.Err() != nil {
+			return cancelledResults(nil, len(operations), err), err
+		}
+		return results, err
+	}
+	var results []domain.BulkResult
+	for start := 0; start < len(operations); start += bulkBatchSize {
+		end := start + bulkBatchSize
+		if end > len(operations) {
+			end = len(operations)
+		}
+		batchResults, err := pl.productRepository.BulkWrite(ctx, operations[start:end], false)
+		if err != nil {
+			span.RecordError(err)
+			if ctx.Err() != nil {
+				// earlier batches are written, the cancelled one and the following ones are not
+				return cancelledResults(results, len(operations)-start, err), err
+			}
+			return results, err
+		}
+		results = append(results, batchResults...)
+	}
+	return results, nil
+}
+
+// cancelledResults appends count results failed with err, for operations skipped by a cancellation
+func cancelledResults(results []domain.BulkResult, count int, err error) []domain.BulkResult {
+	for i := 0; i < count; i++ {
+		results = append(results, domain.BulkResult{Err: err})
+	}
+	return results
+}
+
+// AddProductImages links stored images to the product with iid atomically, names it already has are skipped
+func (pl *productLogic) AddProductImages(ctx context.Context, iid string, names []string) (*domain.Product, error) {
+	ctx, span := tracing.Start(ctx, "logic.AddProductImages")
+	defer span.End()
+	span.SetAttribute("product.iid", iid)
+	span.SetAttribute("product.images", len(names))
+	result, err := pl.productRepository.PatchProduct(ctx, iid, nil, imagesPatch(names))
+	span.RecordError(err)
+	return result, err
+}
+
+// ProductChanges returns a page of at most limit changes of products after fromSeq
+func (pl *productLogic) ProductChanges(ctx context.Context, fromSeq int64, limit int) (*domain.ProductChangePage, error) {
+	ctx, span := tracing.Start(ctx, "logic.ProductChanges")
+	defer span.End()
+	span.SetAttribute("changes.from", fromSeq)
+	// one more change tells whether there is a next page
+	changes, err := pl.productRepository.ProductChanges(ctx, fromSeq, limit+1)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	page := &domain.ProductChangePage{Changes: changes, Next: fromSeq}
+	if len(changes) > limit {
+		page.Changes = changes[:limit]
+		page.More = true
+	}
+	if len(page.Changes) > 0 {
+		page.Next = page.Changes[len(page.Changes)-1].Seq
+	}
+	return page, nil
+}
+
+// imagesPatch appends image names to the images of a product document
+type imagesPatch []string
+
+// Apply returns a copy of doc with the names it does not have yet appended to its images
+func (p imagesPatch) Apply(doc interface{}) (interface{}, error) {
+	object, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, errors.New(constants.BadData)
+	}
+	patched := make(map[string]interface{}, len(object)+1)
+	for key, value := range object {
+		patched[key] = value
+	}
+	existing, _ := object["images"].([]interface{})
+	images := append([]interface{}{}, existing...)
+	for _, name := range p {
+		found := false
+		for _, image := range images {
+			if image == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			images = append(images, name)
+		}
+	}
+	patched["images"] = images
+	return patched, nil
 }
@@ -0,0 +1,77 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/amupxm/pure-webserver/domain"
+	"github.com/amupxm/pure-webserver/repository"
+)
+
+// cancellingRepository writes bulk batches until cancel is due, then it cancels the request
+type cancellingRepository struct {
+	repository.ProductRepository
+	cancel      context.CancelFunc
+	cancelAfter int
+	calls       int
+}
+
+func (r *cancellingRepository) BulkWrite(ctx context.Context, operations []domain.BulkOperation, atomic bool) ([]domain.BulkResult, error) {
+	r.calls++
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if r.calls == r.cancelAfter {
+		r.cancel()
+	}
+	results := make([]domain.BulkResult, len(operations))
+	for i := range results {
+		results[i].Products = []domain.Product{{Iid: operations[i].Iid}}
+	}
+	return results, nil
+}
+
+func TestBulkProductsStopsWhenCancelled(t *testing.T) {
+	operations := make([]domain.BulkOperation, 2*bulkBatchSize+200)
+	tests := []struct {
+		name        string
+		atomic      bool
+		cancelAfter int
+		calls       int
+		written     int
+	}{
+		// the first batch is written, the second one sees the cancellation and the third one is skipped
+		{"batches", false, 1, 2, bulkBatchSize},
+		{"cancelled before the first batch", false, 0, 1, 0},
+		{"atomic", true, 0, 1, 0},
+	}
+	for _, test := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		if test.cancelAfter == 0 {
+			cancel()
+		}
+		repository := &cancellingRepository{cancel: cancel, cancelAfter: test.cancelAfter}
+		results, err := NewProductLogic(repository).BulkProducts(ctx, operations, test.atomic)
+		cancel()
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: error %v, want %v", test.name, err, context.Canceled)
+		}
+		if repository.calls != test.calls {
+			t.Errorf("%s: %d batches were sent, want %d", test.name, repository.calls, test.calls)
+		}
+		if len(results) != len(operations) {
+			t.Fatalf("%s: %d results for %d operations", test.name, len(results), len(operations))
+		}
+		for i, result := range results {
+			if i < test.written && result.Err != nil {
+				t.Errorf("%s: written operation %d failed with %v", test.name, i, result.Err)
+				break
+			}
+			if i >= test.written && !errors.Is(result.Err, context.Canceled) {
+				t.Errorf("%s: skipped operation %d has error %v, want %v", test.name, i, result.Err, context.Canceled)
+				break
+			}
+		}
+	}
+}
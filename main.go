@@ -1,17 +1,111 @@
 package main
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/amupxm/pure-webserver/config"
 	"github.com/amupxm/pure-webserver/controller"
 	"github.com/amupxm/pure-webserver/logic"
+	"github.com/amupxm/pure-webserver/pkg/blob"
 	"github.com/amupxm/pure-webserver/pkg/database"
+	"github.com/amupxm/pure-webserver/pkg/logger"
+	"github.com/amupxm/pure-webserver/pkg/metrics"
+	"github.com/amupxm/pure-webserver/pkg/tracing"
 	"github.com/amupxm/pure-webserver/repository"
 )
 
 func main() {
-	config.Init()
-	database := database.NewDatabase()
-	productsRepository := repository.NewProductRepository(database)
+	if len(os.Args) > 1 && os.Args[1] == "validate-config" {
+		validateConfig(os.Args[2:])
+		return
+	}
+	effective, err := config.Init(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if effective.PrintConfig {
+		if err := effective.Dump(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	level, _ := logger.ParseLevel(effective.Config.Log.Level)
+	appLogger := logger.New(os.Stderr, logger.Format(effective.Config.Log.Format), level)
+	logger.SetDefault(appLogger)
+
+	// reload the configuration on SIGHUP and config file changes
+	watcher := config.NewWatcher(effective, os.Args[1:], os.LookupEnv)
+	watcher.Subscribe(func(old, new config.Config) {
+		if old.Log.Level != new.Log.Level {
+			level, _ := logger.ParseLevel(new.Log.Level)
+			appLogger.SetLevel(level)
+		}
+	})
+	go watcher.Watch(nil)
+
+	tracer, err := newTracer(effective.Config)
+	if err != nil {
+		appLogger.Error("can not create tracer", logger.Fields{"error": err})
+		os.Exit(1)
+	}
+	tracing.SetDefault(tracer)
+
+	database := database.NewDatabase(
+		database.WithBucketName(effective.Config.DatabaseConfig.BucketName),
+		database.WithMetrics(metrics.Default()),
+	)
+	maxImageSize, _ := config.ParseSize(effective.Config.Images.MaxSize)
+	images, err := blob.NewLocalStore(
+		effective.Config.Images.Dir,
+		blob.WithMaxSize(maxImageSize),
+		blob.WithAllowedTypes(effective.Config.Images.AllowedTypes...),
+	)
+	if err != nil {
+		appLogger.Error("can not create image store", logger.Fields{"error": err})
+		os.Exit(1)
+	}
+	productsRepository := repository.NewProductRepository(database, effective.Config.DatabaseConfig.ChangeLogRetention)
 	productLogic := logic.NewProductLogic(productsRepository)
-	controller.InitNewEngine(productLogic)
+	controller.InitNewEngine(productLogic, database, images, watcher)
+}
+
+// newTracer creates the tracer exporting spans as configured
+func newTracer(c config.Config) (*tracing.Tracer, error) {
+	var exporter tracing.Exporter
+	switch c.Tracing.Exporter {
+	case "file":
+		fileExporter, err := tracing.NewFileExporter(c.Tracing.File)
+		if err != nil {
+			return nil, err
+		}
+		exporter = fileExporter
+	case "otlp":
+		otlpExporter := tracing.NewOTLPExporter(c.Tracing.Endpoint, c.Tracing.ServiceName)
+		header, err := config.ParseHeaders(c.Tracing.Headers)
+		if err != nil {
+			return nil, err
+		}
+		for key := range header {
+			otlpExporter.SetHeader(key, header.Get(key))
+		}
+		exporter = otlpExporter
+	}
+	return tracing.NewTracer(exporter, tracing.WithSampleRatio(c.Tracing.SampleRatio)), nil
+}
+
+// validateConfig checks the layered configuration without starting the server
+func validateConfig(args []string) {
+	effective, err := config.Load(args, os.LookupEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	file := effective.File
+	if file == "" {
+		file = "defaults"
+	}
+	fmt.Printf("configuration is valid (%s)\n", file)
 }
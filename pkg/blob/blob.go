@@ -0,0 +1,63 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+type (
+	// Info describes a stored blob
+	Info struct {
+		// Name identifies the blob, it is derived from the content so equal contents share a name
+		Name        string    `json:"name"`
+		Size        int64     `json:"size"`
+		ContentType string    `json:"content_type"`
+		ModTime     time.Time `json:"-"`
+	}
+
+	// Store keeps blobs by name
+	Store interface {
+		// Put stores the content read from r and returns its info, storing a content twice keeps one copy
+		Put(ctx context.Context, r io.Reader) (Info, error)
+		// Open returns the content of the blob called name, the caller must close it
+		Open(ctx context.Context, name string) (io.ReadSeekCloser, Info, error)
+		// Delete removes the blob called name
+		Delete(ctx context.Context, name string) error
+	}
+)
+
+var (
+	// ErrNotFound is returned for names without a blob
+	ErrNotFound = errors.New("blob not found")
+	// ErrTooLarge is returned by Put for contents larger than the maximum size of the store
+	ErrTooLarge = errors.New("blob is too large")
+	// ErrTypeNotAllowed is returned by Put for contents of a type the store does not accept
+	ErrTypeNotAllowed = errors.New("blob type is not allowed")
+	// ErrEmpty is returned by Put for empty contents
+	ErrEmpty = errors.New("blob is empty")
+)
+
+// sniffLen is how many bytes http.DetectContentType looks at
+const sniffLen = 512
+
+// extensions are the file extensions of sniffed content types, names of other types have none
+var extensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"image/bmp":       ".bmp",
+	"application/pdf": ".pdf",
+}
+
+// contentTypeOf returns the content type of a name created by Put
+func contentTypeOf(name string) string {
+	for contentType, extension := range extensions {
+		if len(name) > len(extension) && name[len(name)-len(extension):] == extension {
+			return contentType
+		}
+	}
+	return "application/octet-stream"
+}
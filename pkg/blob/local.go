@@ -0,0 +1,175 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+type (
+	// Option configures a LocalStore created by NewLocalStore
+	Option func(s *LocalStore)
+
+	// LocalStore keeps blobs as files named by the sha256 of their content, sharded by its first two characters
+	LocalStore struct {
+		dir          string
+		maxSize      int64
+		allowedTypes map[string]bool
+	}
+)
+
+// validName matches names created by Put, so Open and Delete never leave the store directory
+var validName = regexp.MustCompile(`^[0-9a-f]{64}(\.[a-z0-9]+)?$`)
+
+// WithMaxSize rejects contents larger than size bytes with ErrTooLarge, zero accepts any size
+func WithMaxSize(size int64) Option {
+	return func(s *LocalStore) {
+		s.maxSize = size
+	}
+}
+
+// WithAllowedTypes rejects contents whose sniffed type is not one of types with ErrTypeNotAllowed,
+// no types accept any content
+func WithAllowedTypes(types ...string) Option {
+	return func(s *LocalStore) {
+		s.allowedTypes = make(map[string]bool, len(types))
+		for _, t := range types {
+			s.allowedTypes[t] = true
+		}
+	}
+}
+
+// NewLocalStore creates a store in dir, creating it when it does not exist
+func NewLocalStore(dir string, options ...Option) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &LocalStore{dir: dir}
+	for _, option := range options {
+		option(s)
+	}
+	return s, nil
+}
+
+// Put sniffs the type of the content from its first bytes and streams it to a temporary file while hashing it,
+// the file is renamed to its content address once the whole content was read within the size limit
+func (s *LocalStore) Put(ctx context.Context, r io.Reader) (Info, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return Info{}, err
+	}
+	head = head[:n]
+	if n == 0 {
+		return Info{}, ErrEmpty
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if len(s.allowedTypes) > 0 && !s.allowedTypes[contentType] {
+		return Info{}, fmt.Errorf("%w: %s", ErrTypeNotAllowed, contentType)
+	}
+
+	tmp, err := ioutil.TempFile(s.dir, ".upload-")
+	if err != nil {
+		return Info{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	hash := sha256.New()
+	reader := io.MultiReader(bytes.NewReader(head), contextReader{ctx: ctx, r: r})
+	if s.maxSize > 0 {
+		// one byte more than allowed tells a content of exactly the maximum size from a larger one
+		reader = io.LimitReader(reader, s.maxSize+1)
+	}
+	size, err := io.Copy(io.MultiWriter(tmp, hash), reader)
+	if err != nil {
+		return Info{}, err
+	}
+	if s.maxSize > 0 && size > s.maxSize {
+		return Info{}, ErrTooLarge
+	}
+	if err := tmp.Close(); err != nil {
+		return Info{}, err
+	}
+
+	name := hex.EncodeToString(hash.Sum(nil)) + extensions[contentType]
+	path := s.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return Info{}, err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := os.Rename(tmp.Name(), path); err != nil {
+			return Info{}, err
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{Name: name, Size: size, ContentType: contentTypeOf(name), ModTime: info.ModTime()}, nil
+}
+
+// Open returns the file of the blob called name
+func (s *LocalStore) Open(ctx context.Context, name string) (io.ReadSeekCloser, Info, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, Info{}, err
+	}
+	if !validName.MatchString(name) {
+		return nil, Info{}, ErrNotFound
+	}
+	file, err := os.Open(s.path(name))
+	if os.IsNotExist(err) {
+		return nil, Info{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Info{}, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, Info{}, err
+	}
+	return file, Info{Name: name, Size: stat.Size(), ContentType: contentTypeOf(name), ModTime: stat.ModTime()}, nil
+}
+
+// Delete removes the file of the blob called name
+func (s *LocalStore) Delete(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !validName.MatchString(name) {
+		return ErrNotFound
+	}
+	err := os.Remove(s.path(name))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// path returns the file of the blob called name
+func (s *LocalStore) path(name string) string {
+	return filepath.Join(s.dir, name[:2], name)
+}
+
+// contextReader stops reading once ctx is done, so abandoned uploads do not keep writing
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// Read reads from the wrapped reader unless the context is done
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
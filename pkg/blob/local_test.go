@@ -0,0 +1,118 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// pngContent is a png signature followed by filler, enough for content sniffing
+var pngContent = append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 100)...)
+
+// newTestStore returns a store in a temporary directory accepting png and gif up to 1KB
+func newTestStore(t *testing.T) (*LocalStore, string) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "images")
+	store, err := NewLocalStore(dir, WithMaxSize(1024), WithAllowedTypes("image/png", "image/gif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, dir
+}
+
+func TestLocalStorePutOpenDelete(t *testing.T) {
+	store, dir := newTestStore(t)
+	ctx := context.Background()
+	info, err := store.Put(ctx, bytes.NewReader(pngContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(info.Name, ".png") || info.Size != int64(len(pngContent)) || info.ContentType != "image/png" {
+		t.Errorf("info is %+v", info)
+	}
+	again, err := store.Put(ctx, bytes.NewReader(pngContent))
+	if err != nil || again.Name != info.Name {
+		t.Errorf("storing the content again gave %+v, %v", again, err)
+	}
+
+	file, opened, err := store.Open(ctx, info.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(file)
+	file.Close()
+	if !bytes.Equal(content, pngContent) || opened.Name != info.Name || opened.Size != info.Size {
+		t.Errorf("opened %d bytes with info %+v", len(content), opened)
+	}
+
+	if err := store.Delete(ctx, info.Name); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Open(ctx, info.Name); !errors.Is(err, ErrNotFound) {
+		t.Errorf("open after delete error = %v, want %v", err, ErrNotFound)
+	}
+	if err := store.Delete(ctx, info.Name); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second delete error = %v, want %v", err, ErrNotFound)
+	}
+	assertNoUploads(t, dir)
+}
+
+func TestLocalStorePutRejects(t *testing.T) {
+	store, dir := newTestStore(t)
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		content []byte
+		err     error
+	}{
+		{"empty", context.Background(), nil, ErrEmpty},
+		{"type", context.Background(), []byte("just some text"), ErrTypeNotAllowed},
+		{"size", context.Background(), append(append([]byte(nil), pngContent...), make([]byte, 1024)...), ErrTooLarge},
+		{"canceled", canceled, append(append([]byte(nil), pngContent...), make([]byte, 600)...), context.Canceled},
+	}
+	for _, test := range tests {
+		if _, err := store.Put(test.ctx, bytes.NewReader(test.content)); !errors.Is(err, test.err) {
+			t.Errorf("%s: error %v, want %v", test.name, err, test.err)
+		}
+	}
+	exact := append(append([]byte(nil), pngContent...), make([]byte, 1024-len(pngContent))...)
+	if _, err := store.Put(context.Background(), bytes.NewReader(exact)); err != nil {
+		t.Errorf("content of exactly the maximum size: %v", err)
+	}
+	assertNoUploads(t, dir)
+}
+
+func TestLocalStoreRejectsNamesOutsideTheStore(t *testing.T) {
+	store, dir := newTestStore(t)
+	secret := filepath.Join(filepath.Dir(dir), "secret")
+	if err := os.WriteFile(secret, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"../secret", "..", "", "ab/../../secret", strings.Repeat("a", 64) + "/../x", strings.Repeat("A", 64)} {
+		if _, _, err := store.Open(context.Background(), name); !errors.Is(err, ErrNotFound) {
+			t.Errorf("open %q error = %v, want %v", name, err, ErrNotFound)
+		}
+		if err := store.Delete(context.Background(), name); !errors.Is(err, ErrNotFound) {
+			t.Errorf("delete %q error = %v, want %v", name, err, ErrNotFound)
+		}
+	}
+	if _, err := os.Stat(secret); err != nil {
+		t.Errorf("a file outside the store was removed: %v", err)
+	}
+}
+
+// assertNoUploads fails when temporary upload files are left in dir
+func assertNoUploads(t *testing.T, dir string) {
+	t.Helper()
+	uploads, _ := filepath.Glob(filepath.Join(dir, ".upload-*"))
+	if len(uploads) > 0 {
+		t.Errorf("temporary files are left: %v", uploads)
+	}
+}
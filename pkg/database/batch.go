@@ -0,0 +1,193 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+type (
+	// BatchKind is the kind of write of a batch operation
+	BatchKind int
+	// BatchOperation is one write of a batch
+	BatchOperation struct {
+		// Kind is BatchInsert, BatchModify or BatchRemove
+		Kind BatchKind
+		// Data is the model to insert
+		Data interface{}
+		// Id selects the item to modify or remove when Match is nil
+		Id string
+		// Match selects every item to modify or remove
+		Match func(doc map[string]interface{}) bool
+		// Version must equal the stored version of selected items when non zero
+		Version int64
+		// Versions, when not nil, must hold exactly the ids of the selected items with their stored versions
+		Versions map[string]int64
+		// Modify returns the new document of a selected item
+		Modify func(doc map[string]interface{}) (map[string]interface{}, error)
+	}
+	// BatchResult is the outcome of one batch operation
+	BatchResult struct {
+		// Docs are the written documents, removed ones for BatchRemove
+		Docs []map[string]interface{}
+		// Err is the reason the operation was not applied
+		Err error
+		// before are the stored documents replaced by BatchModify, in the order of Docs
+		before []map[string]interface{}
+	}
+)
+
+const (
+	// BatchInsert inserts Data as a new item
+	BatchInsert BatchKind = iota
+	// BatchModify replaces selected items by the result of Modify
+	BatchModify
+	// BatchRemove removes selected items
+	BatchRemove
+)
+
+var (
+	// ErrBatchAborted is the result of operations rolled back by a failure of an atomic batch
+	ErrBatchAborted = errors.New("batch aborted")
+	// ErrInvalidOperation is the result of operations with an unknown kind
+	ErrInvalidOperation = errors.New("invalid batch operation")
+)
+
+// ExecuteBatch applies operations in order to a collection with a single read and write of the database.
+// Failed operations are skipped, unless atomic is set in which case nothing is written,
+// every other operation gets ErrBatchAborted and ErrBatchAborted is returned
+func (db *database) ExecuteBatch(ctx context.Context, collection interface{}, operations []BatchOperation, atomic bool) ([]BatchResult, error) {
+	defer db.acquire(ctx, "batch", db.getCollectionName(collection), true)()
+	dbCollection, err := db.getCollections(collection)
+	if err != nil {
+		return nil, err
+	}
+	collectionName := db.getCollectionName(collection)
+	c := dbCollection.Items[collectionName]
+	lastId := dbCollection.DataIndexes[collectionName]
+
+	results := make([]BatchResult, len(operations))
+	failed := false
+	for i, operation := range operations {
+		// nothing is written when the batch is cancelled
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var next DBInnerModel
+		next, results[i], lastId = applyBatchOperation(c, operation, lastId)
+		if results[i].Err != nil {
+			failed = true
+			continue
+		}
+		c = next
+	}
+	if failed && atomic {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i] = BatchResult{Err: ErrBatchAborted}
+			}
+		}
+		return results, ErrBatchAborted
+	}
+
+	dbCollection.Items[collectionName] = c
+	dbCollection.DataIndexes[collectionName] = lastId
+	changes := db.recordChanges(dbCollection, collectionName, batchChanges(operations, results))
+	err = db.writeDatabase(dbCollection)
+	if err != nil {
+		return nil, err
+	}
+	db.notify(collectionName, changes...)
+	if idx, ok := db.indexes[collectionName]; ok && idx.isBuilt() {
+		idx.rebuild(c)
+	}
+	return results, nil
+}
+
+// applyBatchOperation returns a copy of c with operation applied, c itself is never modified
+func applyBatchOperation(c DBInnerModel, operation BatchOperation, lastId int) (DBInnerModel, BatchResult, int) {
+	var result BatchResult
+	if operation.Kind == BatchInsert {
+		doc, err := toDocument(operation.Data)
+		if err != nil {
+			result.Err = err
+			return c, result, lastId
+		}
+		lastId++
+		now := time.Now()
+		doc["id"] = strconv.Itoa(lastId)
+		doc["created_at"] = now
+		doc["updated_at"] = now
+		doc["version"] = 1
+		result.Docs = append(result.Docs, doc)
+		return append(c[:len(c):len(c)], doc), result, lastId
+	}
+	if operation.Kind != BatchModify && operation.Kind != BatchRemove {
+		result.Err = ErrInvalidOperation
+		return c, result, lastId
+	}
+
+	next := DBInnerModel{}
+	for _, item := range c {
+		stored, err := toDocument(item)
+		if err != nil {
+			result.Err = err
+			return c, result, lastId
+		}
+		selected := documentId(stored) == operation.Id
+		if operation.Match != nil {
+			selected = operation.Match(stored)
+		}
+		if !selected {
+			next = append(next, item)
+			continue
+		}
+		if operation.Version != 0 && documentVersion(stored) != operation.Version {
+			result.Err = ErrVersionConflict
+			return c, result, lastId
+		}
+		if version, ok := operation.Versions[documentId(stored)]; operation.Versions != nil && (!ok || version != documentVersion(stored)) {
+			result.Err = ErrVersionConflict
+			return c, result, lastId
+		}
+		if operation.Kind == BatchRemove {
+			result.Docs = append(result.Docs, stored)
+			continue
+		}
+		doc, err := operation.Modify(copyDocument(stored))
+		if err == nil && doc == nil {
+			err = ErrNoDocument
+		}
+		if err != nil {
+			result.Err = err
+			return c, result, lastId
+		}
+		doc["id"] = stored["id"]
+		doc["created_at"] = stored["created_at"]
+		doc["version"] = documentVersion(stored) + 1
+		doc["updated_at"] = time.Now()
+		result.Docs = append(result.Docs, doc)
+		result.before = append(result.before, stored)
+		next = append(next, doc)
+	}
+	// an item of Versions which is gone was written since its versions were read
+	if operation.Versions != nil && len(result.Docs) != len(operation.Versions) {
+		result.Err = ErrVersionConflict
+		return c, result, lastId
+	}
+	if len(result.Docs) == 0 {
+		result.Err = ErrNotFound
+		return c, result, lastId
+	}
+	return next, result, lastId
+}
+
+// copyDocument returns a shallow copy of doc so a failed modification leaves the stored item intact
+func copyDocument(doc map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(doc))
+	for k, v := range doc {
+		result[k] = v
+	}
+	return result
+}
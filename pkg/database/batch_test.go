@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestExecuteBatchAtomic(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	var item *testItem
+	for _, name := range []string{"car", "car", "boat"} {
+		if err := db.WriteToCollection(ctx, &testItem{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cars := func(doc map[string]interface{}) bool {
+		return doc["name"] == "car"
+	}
+	operations := []BatchOperation{
+		{Kind: BatchInsert, Data: &testItem{Name: "plane"}},
+		{Kind: BatchModify, Match: cars, Modify: func(doc map[string]interface{}) (map[string]interface{}, error) {
+			doc["brand"] = "new"
+			return doc, nil
+		}},
+		// the second car fails, so the first one must not be written either
+		{Kind: BatchModify, Match: cars, Modify: func(doc map[string]interface{}) (map[string]interface{}, error) {
+			if doc["id"] == "2" {
+				return nil, nil
+			}
+			return doc, nil
+		}},
+	}
+	results, err := db.ExecuteBatch(ctx, item, operations, true)
+	if !errors.Is(err, ErrBatchAborted) {
+		t.Fatalf("error %v, want %v", err, ErrBatchAborted)
+	}
+	if !errors.Is(results[0].Err, ErrBatchAborted) || !errors.Is(results[1].Err, ErrBatchAborted) || !errors.Is(results[2].Err, ErrNoDocument) {
+		t.Errorf("results are %+v", results)
+	}
+	items, err := db.GetFromCollection(ctx, item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("an aborted batch inserted items, %d are stored", len(items))
+	}
+	for _, stored := range items {
+		if doc, _ := toDocument(stored); documentVersion(doc) != 1 || doc["brand"] != "" {
+			t.Errorf("an aborted batch modified %v", doc)
+		}
+	}
+
+	results, err = db.ExecuteBatch(ctx, item, operations[:2], true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results[0].Docs) != 1 || len(results[1].Docs) != 2 {
+		t.Errorf("batch wrote %d and %d documents, want 1 and 2", len(results[0].Docs), len(results[1].Docs))
+	}
+}
+
+func TestExecuteBatchVersions(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	var item *testItem
+	for _, name := range []string{"car", "car"} {
+		if err := db.WriteToCollection(ctx, &testItem{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cars := func(doc map[string]interface{}) bool {
+		return doc["name"] == "car"
+	}
+	tests := []struct {
+		name     string
+		versions map[string]int64
+		err      error
+	}{
+		{"another version", map[string]int64{"1": 1, "2": 2}, ErrVersionConflict},
+		{"a selected item is missing", map[string]int64{"1": 1}, ErrVersionConflict},
+		{"an item is not selected", map[string]int64{"1": 1, "2": 1, "3": 1}, ErrVersionConflict},
+		{"every version matches", map[string]int64{"1": 1, "2": 1}, nil},
+		{"versions were written", map[string]int64{"1": 1, "2": 1}, ErrVersionConflict},
+	}
+	for _, test := range tests {
+		results, _ := db.ExecuteBatch(ctx, item, []BatchOperation{{Kind: BatchRemove, Match: cars, Versions: test.versions}}, true)
+		if !errors.Is(results[0].Err, test.err) {
+			t.Errorf("%s: error %v, want %v", test.name, results[0].Err, test.err)
+		}
+		if test.err == nil {
+			if err := db.WriteToCollection(ctx, &testItem{Name: "car"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// ChangeKind is the kind of write which changed an item
+	ChangeKind string
+	// Change describes one item written to a collection
+	Change struct {
+		// Seq orders the changes of a database, it is zero for collections without change log
+		Seq int64 `json:"seq"`
+		// Collection is the name of the collection of the item
+		Collection string `json:"collection"`
+		// Kind is ChangeInsert, ChangeUpdate or ChangeDelete
+		Kind ChangeKind `json:"kind"`
+		// Id is the id of the item
+		Id string `json:"id"`
+		// Before is the document before the change, nil for ChangeInsert
+		Before map[string]interface{} `json:"before"`
+		// After is the document after the change, nil for ChangeDelete
+		After map[string]interface{} `json:"after"`
+		// Time is when the change was written
+		Time time.Time `json:"time"`
+	}
+	// ChangeHook is called for every change of a collection
+	ChangeHook func(change Change)
+
+	// changeHooks are the hooks registered with OnChange by collection name
+	changeHooks struct {
+		lock   sync.Mutex
+		nextId int
+		hooks  map[string]map[int]ChangeHook
+	}
+
+	// changeWatcher buffers the changes of a Watch written while earlier ones are delivered
+	changeWatcher struct {
+		lock   sync.Mutex
+		live   chan Change
+		closed bool
+	}
+)
+
+const (
+	// ChangeInsert is the change of an item written for the first time
+	ChangeInsert ChangeKind = "insert"
+	// ChangeUpdate is the change of an item replaced by a new version
+	ChangeUpdate ChangeKind = "update"
+	// ChangeDelete is the change of a removed item
+	ChangeDelete ChangeKind = "delete"
+)
+
+// watchBuffer is the number of new changes a watcher may lag behind before its channel is closed
+const watchBuffer = 256
+
+var (
+	// ErrNoChangeLog is returned when reading the changes of a collection without change log
+	ErrNoChangeLog = errors.New("collection has no change log")
+	// ErrChangesTrimmed is returned when changes after the requested sequence number were dropped from the log
+	ErrChangesTrimmed = errors.New("changes were dropped from the change log")
+)
+
+// OnChange calls hook after every successful write of an item of collection and returns the function
+// removing it. Hooks are called in the order of writes while the database is locked, so they must
+// return quickly and must not use the database
+func (db *database) OnChange(collection interface{}, hook ChangeHook) func() {
+	collectionName := db.getCollectionName(collection)
+	db.changes.lock.Lock()
+	defer db.changes.lock.Unlock()
+	if db.changes.hooks == nil {
+		db.changes.hooks = make(map[string]map[int]ChangeHook)
+	}
+	if db.changes.hooks[collectionName] == nil {
+		db.changes.hooks[collectionName] = make(map[int]ChangeHook)
+	}
+	db.changes.nextId++
+	id := db.changes.nextId
+	db.changes.hooks[collectionName][id] = hook
+	return func() {
+		db.changes.lock.Lock()
+		defer db.changes.lock.Unlock()
+		delete(db.changes.hooks[collectionName], id)
+	}
+}
+
+// EnableChangeLog records the inserts, updates and deletes of a collection with increasing sequence numbers
+// in the database file, keeping the last retention ones (all of them when zero). Items expiring by ttl are not logged
+func (db *database) EnableChangeLog(collection interface{}, retention int) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.changeLogs[db.getCollectionName(collection)] = retention
+}
+
+// Changes returns at most limit logged changes of collection after fromSeq, oldest first
+func (db *database) Changes(ctx context.Context, collection interface{}, fromSeq int64, limit int) ([]Change, error) {
+	collectionName := db.getCollectionName(collection)
+	defer db.acquire(ctx, "changes", collectionName, false)()
+	if _, ok := db.changeLogs[collectionName]; !ok {
+		return nil, ErrNoChangeLog
+	}
+	dbCollection, err := db.readDatabase()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := changesAfter(dbCollection, collectionName, fromSeq)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(changes) > limit {
+		changes = changes[:limit]
+	}
+	return changes, nil
+}
+
+// ChangeSeq returns the sequence number of the last logged change of the database, watching from it
+// only gets new changes
+func (db *database) ChangeSeq(ctx context.Context) (int64, error) {
+	defer db.acquire(ctx, "change_seq", "", false)()
+	dbCollection, err := db.readDatabase()
+	if err != nil {
+		return 0, err
+	}
+	return dbCollection.ChangeSeq, nil
+}
+
+// Watch returns the logged changes of collection after fromSeq, then the new ones as they are written.
+// The channel is closed when ctx is done or the watcher falls behind by more than watchBuffer changes,
+// watching again from the last received Seq resumes without gaps
+func (db *database) Watch(ctx context.Context, collection interface{}, fromSeq int64) (<-chan Change, error) {
+	collectionName := db.getCollectionName(collection)
+	// writes wait until the hook is added, so no change is missed or delivered twice
+	defer db.acquire(ctx, "watch", collectionName, false)()
+	if _, ok := db.changeLogs[collectionName]; !ok {
+		return nil, ErrNoChangeLog
+	}
+	dbCollection, err := db.readDatabase()
+	if err != nil {
+		return nil, err
+	}
+	backlog, err := changesAfter(dbCollection, collectionName, fromSeq)
+	if err != nil {
+		return nil, err
+	}
+	watcher := &changeWatcher{live: make(chan Change, watchBuffer)}
+	remove := db.OnChange(collection, watcher.push)
+	changes := make(chan Change)
+	go func() {
+		defer close(changes)
+		defer watcher.stop()
+		defer remove()
+		for _, change := range backlog {
+			select {
+			case changes <- change:
+			case <-ctx.Done():
+				return
+			}
+		}
+		for {
+			select {
+			case change, ok := <-watcher.live:
+				if !ok {
+					return
+				}
+				select {
+				case changes <- change:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// changesAfter returns the logged changes of collectionName after fromSeq
+func changesAfter(dbCollection *DbModelCollection, collectionName string, fromSeq int64) ([]Change, error) {
+	if fromSeq < dbCollection.TrimmedSeq[collectionName] {
+		return nil, ErrChangesTrimmed
+	}
+	log := dbCollection.Changes[collectionName]
+	i := sort.Search(len(log), func(i int) bool {
+		return log[i].Seq > fromSeq
+	})
+	return log[i:], nil
+}
+
+// recordChanges stamps the changes of collectionName and appends them to its change log in dbCollection,
+// the caller must hold the database lock and write dbCollection afterwards
+func (db *database) recordChanges(dbCollection *DbModelCollection, collectionName string, changes []Change) []Change {
+	now := time.Now()
+	retention, logged := db.changeLogs[collectionName]
+	for i := range changes {
+		changes[i].Collection = collectionName
+		changes[i].Time = now
+		if logged {
+			dbCollection.ChangeSeq++
+			changes[i].Seq = dbCollection.ChangeSeq
+		}
+	}
+	if !logged || len(changes) == 0 {
+		return changes
+	}
+	if dbCollection.Changes == nil {
+		dbCollection.Changes = make(map[string][]Change)
+	}
+	log := append(dbCollection.Changes[collectionName], changes...)
+	if retention > 0 && len(log) > retention {
+		if dbCollection.TrimmedSeq == nil {
+			dbCollection.TrimmedSeq = make(map[string]int64)
+		}
+		dbCollection.TrimmedSeq[collectionName] = log[len(log)-retention-1].Seq
+		log = append([]Change(nil), log[len(log)-retention:]...)
+	}
+	dbCollection.Changes[collectionName] = log
+	return changes
+}
+
+// notify calls the hooks of the collection of changes, the caller must hold the database lock
+func (db *database) notify(collectionName string, changes ...Change) {
+	db.changes.lock.Lock()
+	hooks := make([]ChangeHook, 0, len(db.changes.hooks[collectionName]))
+	for _, hook := range db.changes.hooks[collectionName] {
+		hooks = append(hooks, hook)
+	}
+	db.changes.lock.Unlock()
+	for _, change := range changes {
+		for _, hook := range hooks {
+			hook(change)
+		}
+	}
+}
+
+// batchChanges returns the changes of the applied operations of a batch
+func batchChanges(operations []BatchOperation, results []BatchResult) []Change {
+	var changes []Change
+	for i, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		for j, doc := range result.Docs {
+			switch operations[i].Kind {
+			case BatchInsert:
+				changes = append(changes, Change{Kind: ChangeInsert, Id: documentId(doc), After: doc})
+			case BatchModify:
+				changes = append(changes, Change{Kind: ChangeUpdate, Id: documentId(doc), Before: result.before[j], After: doc})
+			case BatchRemove:
+				changes = append(changes, Change{Kind: ChangeDelete, Id: documentId(doc), Before: doc})
+			}
+		}
+	}
+	return changes
+}
+
+// push hands a new change to the watcher, a watcher too far behind is closed
+func (w *changeWatcher) push(change Change) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.live <- change:
+	default:
+		w.closed = true
+		close(w.live)
+	}
+}
+
+// stop closes the watcher
+func (w *changeWatcher) stop() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.live)
+	}
+}
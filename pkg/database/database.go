@@ -1,25 +1,56 @@
 package database
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"os"
 	"reflect"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/amupxm/pure-webserver/config"
 )
 
 type (
 	Database interface {
 		// WriteToCollection writes a collection to the database
-		WriteToCollection(collection interface{}) error
+		WriteToCollection(ctx context.Context, collection interface{}) error
 		// GetFromCollection returns a collection from the database
-		GetFromCollection(collection interface{}) (DBInnerModel, error)
+		GetFromCollection(ctx context.Context, collection interface{}) (DBInnerModel, error)
 		// UpdateCollection updates a collection in the database
-		UpdateCollection(collectionData interface{}) error
+		UpdateCollection(ctx context.Context, collectionData interface{}) error
+		// IndexFields enables full-text search on fields (json names) of a collection
+		IndexFields(collection interface{}, fields ...string)
+		// SetTTL makes items of a collection expire ttl after their last update, a zero ttl keeps them
+		SetTTL(collection interface{}, ttl time.Duration)
+		// Search returns items of a collection matching query, the most relevant first
+		Search(ctx context.Context, collection interface{}, query string) (DBInnerModel, error)
+		// CompareAndSwap replaces the item with id by data if its stored version equals version
+		CompareAndSwap(ctx context.Context, collection interface{}, id string, version int64, data interface{}) error
+		// ModifyItem atomically replaces the item with id by the result of modify applied to it
+		ModifyItem(ctx context.Context, collection interface{}, id string, version int64, modify func(doc map[string]interface{}) (map[string]interface{}, error)) (map[string]interface{}, error)
+		// RemoveFromCollection removes the item with id if its stored version equals version
+		RemoveFromCollection(ctx context.Context, collection interface{}, id string, version int64) error
+		// ExecuteBatch applies inserts, modifications and removals with a single write of the database
+		ExecuteBatch(ctx context.Context, collection interface{}, operations []BatchOperation, atomic bool) ([]BatchResult, error)
+		// OnChange calls hook after every successful write of an item of collection and returns the function removing it
+		OnChange(collection interface{}, hook ChangeHook) func()
+		// EnableChangeLog records the changes of a collection in the database, keeping the last retention ones
+		EnableChangeLog(collection interface{}, retention int)
+		// Changes returns at most limit logged changes of a collection after fromSeq
+		Changes(ctx context.Context, collection interface{}, fromSeq int64, limit int) ([]Change, error)
+		// ChangeSeq returns the sequence number of the last logged change
+		ChangeSeq(ctx context.Context) (int64, error)
+		// Watch returns the logged changes of a collection after fromSeq, then the new ones as they are written
+		Watch(ctx context.Context, collection interface{}, fromSeq int64) (<-chan Change, error)
+		// Ping fails when the database file can not be read, is not valid json or can not be written
+		Ping(ctx context.Context) error
+		// WriteLag returns how long writes have been failing to reach the database file, zero after a successful write
+		WriteLag() time.Duration
 		// getCollections returns a collections of DbModel (creates one if does not exist)
 		getCollections(collection interface{}) (*DbModelCollection, error)
 		// getCollectionName returns collection name as string
@@ -32,6 +63,20 @@ type (
 	database struct {
 		// The lock for the database (experimental to provide acid)
 		lock sync.RWMutex
+		// indexes holds the full-text indexes by collection name
+		indexes map[string]*invertedIndex
+		// ttls holds the lifetime of items by collection name
+		ttls map[string]time.Duration
+		// bucketName is the json file holding the database
+		bucketName string
+		// metrics are recorded when the database is created WithMetrics
+		metrics *dbMetrics
+		// changes are the hooks called after writes
+		changes changeHooks
+		// changeLogs holds the retention of logged changes by collection name
+		changeLogs map[string]int
+		// failingSince is the unix nano time of the first failed write after the last successful one, zero when none failed
+		failingSince int64
 	}
 	DbModel struct {
 		CreatedAt time.Time  `json:"created_at"`
@@ -39,6 +84,8 @@ type (
 		DeletedAt *time.Time `json:"deleted_at"`
 		Deleted   bool       `json:"deleted"`
 		Id        string     `json:"id"`
+		// Version is incremented on every write of the item
+		Version int64 `json:"version"`
 	}
 	DbModelCollection struct {
 		Items map[string]DBInnerModel `json:"items"`
@@ -46,6 +93,12 @@ type (
 			Total int `json:"total"`
 		} `json:"meta"`
 		DataIndexes map[string]int `json:"data_indexes"` // to save count of items stored in collection
+		// ChangeSeq is the sequence number of the last logged change
+		ChangeSeq int64 `json:"change_seq,omitempty"`
+		// Changes are the logged changes by collection name, oldest first
+		Changes map[string][]Change `json:"changes,omitempty"`
+		// TrimmedSeq is the sequence number of the last change dropped from the log of a collection
+		TrimmedSeq map[string]int64 `json:"trimmed_seq,omitempty"`
 	}
 	DbModelCollectionInterface interface {
 		Where(fieldName string, value interface{}) *DBInnerModel
@@ -56,52 +109,108 @@ type (
 	}
 )
 
-// NewDatabase creates a new Database instance
-func NewDatabase() Database {
-	return &database{
-		lock: sync.RWMutex{},
+// NewDatabase creates a new Database instance, databases with different bucket names are independent
+func NewDatabase(options ...Option) Database {
+	db := &database{
+		lock:       sync.RWMutex{},
+		indexes:    make(map[string]*invertedIndex),
+		ttls:       make(map[string]time.Duration),
+		changeLogs: make(map[string]int),
+		bucketName: DefaultBucketName,
+	}
+	for _, option := range options {
+		option(db)
 	}
+	return db
 }
 
+var (
+	// ErrNoIndex is returned when searching a collection without indexed fields
+	ErrNoIndex = errors.New("collection is not indexed")
+	// ErrNotFound is returned when a collection has no item with the requested id
+	ErrNotFound = errors.New("item not found")
+	// ErrVersionConflict is returned when the stored version of an item is not the expected one
+	ErrVersionConflict = errors.New("version conflict")
+	// ErrNoDocument is returned when a modification returns no document
+	ErrNoDocument = errors.New("modification returned no document")
+)
+
 // readDatabase reads the database
 func (db *database) readDatabase() (*DbModelCollection, error) {
 	var result DbModelCollection
-	b, err := ioutil.ReadFile(config.AppConf.DatabaseConfig.BucketName)
+	b, err := ioutil.ReadFile(db.bucketName)
+	if os.IsNotExist(err) {
+		// a missing bucket is an empty database
+		b, err = nil, nil
+	}
 	if err != nil {
 		return &result, err
 	}
+	db.observeFileSize(len(b))
 	err = json.Unmarshal(b, &result)
 	if err != nil {
 		result = DbModelCollection{Items: make(map[string]DBInnerModel), DataIndexes: make(map[string]int)}
 	}
+	db.dropExpired(&result)
 	return &result, nil
 }
 
+// dropExpired removes items older than the ttl of their collection,
+// they are gone from the file on the next write
+func (db *database) dropExpired(dbCollection *DbModelCollection) {
+	now := time.Now()
+	for collectionName, ttl := range db.ttls {
+		if ttl <= 0 {
+			continue
+		}
+		live := DBInnerModel{}
+		for _, item := range dbCollection.Items[collectionName] {
+			doc, err := toDocument(item)
+			if err != nil {
+				continue
+			}
+			updatedAt, err := time.Parse(time.RFC3339Nano, fmt.Sprint(doc["updated_at"]))
+			if err == nil && now.Sub(updatedAt) > ttl {
+				continue
+			}
+			live = append(live, item)
+		}
+		if _, ok := dbCollection.Items[collectionName]; ok {
+			dbCollection.Items[collectionName] = live
+		}
+	}
+}
+
 // writeDatabase writes the database
 func (db *database) writeDatabase(dbCollection *DbModelCollection) error {
 	marshaledDbData, err := json.Marshal(dbCollection)
-	if err != nil {
-		return err
+	if err == nil {
+		err = ioutil.WriteFile(db.bucketName, []byte(marshaledDbData), 0666)
 	}
-	err = ioutil.WriteFile(config.AppConf.DatabaseConfig.BucketName, []byte(marshaledDbData), 0666)
 	if err != nil {
+		atomic.CompareAndSwapInt64(&db.failingSince, 0, time.Now().UnixNano())
 		return err
 	}
+	atomic.StoreInt64(&db.failingSince, 0)
+	db.observeFileSize(len(marshaledDbData))
 	return nil
 }
 
 // getCollection returns a collections of DbModel (creates one if does not exist)
+// the caller must hold the database lock
 func (db *database) getCollections(collection interface{}) (*DbModelCollection, error) {
-	db.lock.RLock()
-	defer db.lock.RUnlock()
 	dbCollection, err := db.readDatabase()
 	if err != nil {
 		return nil, err
 	}
 	// create dbCollection if not exists
-	collectionName := db.getCollectionName(collection)
-	// check collectionName exists in dbCollection
+	ensureCollection(dbCollection, db.getCollectionName(collection))
+	return dbCollection, nil
+}
 
+// ensureCollection creates collectionName in dbCollection if it does not exist
+func ensureCollection(dbCollection *DbModelCollection, collectionName string) {
+	// check collectionName exists in dbCollection
 	if _, ok := dbCollection.Items[collectionName]; !ok {
 
 		// if not exists, create a new collection
@@ -115,7 +224,6 @@ func (db *database) getCollections(collection interface{}) (*DbModelCollection,
 		dbCollection.Items[collectionName] = []interface{}{}
 		dbCollection.DataIndexes[collectionName] = 0
 	}
-	return dbCollection, nil
 }
 
 // getCollectionName  returns collection name as string
@@ -124,7 +232,11 @@ func (db *database) getCollectionName(collection interface{}) string {
 }
 
 // WriteToCollection writes a collection to the database
-func (db *database) WriteToCollection(collection interface{}) error {
+func (db *database) WriteToCollection(ctx context.Context, collection interface{}) error {
+	defer db.acquire(ctx, "write", db.getCollectionName(collection), true)()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	dbCollection, err := db.getCollections(collection)
 	if err != nil {
 		return err
@@ -135,6 +247,7 @@ func (db *database) WriteToCollection(collection interface{}) error {
 	f.FieldByName("Id").SetString(strconv.Itoa(lastId + 1))
 	f.FieldByName("CreatedAt").Set(reflect.ValueOf(time.Now()))
 	f.FieldByName("UpdatedAt").Set(reflect.ValueOf(time.Now()))
+	f.FieldByName("Version").SetInt(1)
 
 	jsonC, err := json.Marshal(f.Interface())
 	if err != nil {
@@ -142,52 +255,210 @@ func (db *database) WriteToCollection(collection interface{}) error {
 	}
 	_ = json.Unmarshal(jsonC, collection)
 
+	doc, err := toDocument(collection)
+	if err != nil {
+		return err
+	}
 	c := dbCollection.Items[db.getCollectionName(collection)]
 	c = append(c, collection)
 	dbCollection.Items[db.getCollectionName(collection)] = c
 	dbCollection.DataIndexes[db.getCollectionName(collection)]++
-	return db.writeDatabase(dbCollection)
+	changes := db.recordChanges(dbCollection, db.getCollectionName(collection), []Change{{Kind: ChangeInsert, Id: documentId(doc), After: doc}})
+	err = db.writeDatabase(dbCollection)
+	if err != nil {
+		return err
+	}
+	db.notify(db.getCollectionName(collection), changes...)
+	if idx, ok := db.indexes[db.getCollectionName(collection)]; ok && idx.isBuilt() {
+		return idx.add(collection)
+	}
+	return nil
 }
 
-// UpdateCollection updates a collection in the database
-func (db *database) UpdateCollection(typecollectionData interface{}) error {
-	reflection := reflect.TypeOf(typecollectionData)
-	var typeTarget interface{}
-	if reflection.Kind() != reflect.Array && reflection.Kind() == reflect.Slice {
-		typeTarget = typecollectionData
-	} else {
-		// get typeOf firstIndex
-		typeTarget = reflect.Indirect(reflect.ValueOf(typecollectionData)).Index(0).Interface()
+// UpdateCollection replaces a collection in the database with a slice of items,
+// version and update time are bumped only for items which changed
+func (db *database) UpdateCollection(ctx context.Context, typecollectionData interface{}) error {
+	value := reflect.Indirect(reflect.ValueOf(typecollectionData))
+	if value.Kind() != reflect.Array && value.Kind() != reflect.Slice {
+		return nil
 	}
-	dbCollection, err := db.getCollections(typeTarget)
+	collectionName := value.Type().Elem().String()
+	if !strings.HasPrefix(collectionName, "*") {
+		collectionName = "*" + collectionName
+	}
+	defer db.acquire(ctx, "update", collectionName, true)()
+	dbCollection, err := db.readDatabase()
 	if err != nil {
 		return err
 	}
-	tmpName := db.getCollectionName(typeTarget)
-	if !strings.HasPrefix(tmpName, "*") {
-		tmpName = "*" + tmpName
-	}
-	c := dbCollection.Items[db.getCollectionName(tmpName)]
-	// if typecollectionData is array, then update all items
-	if reflection.Kind() == reflect.Array || reflection.Kind() == reflect.Slice || reflection.Kind() == reflect.Ptr {
-		for i := 0; i < reflect.Indirect(reflect.ValueOf(typecollectionData)).Len(); i++ {
-			f := reflect.Indirect(reflect.ValueOf(typecollectionData)).Index(i)
-			f.FieldByName("UpdatedAt").Set(reflect.ValueOf(time.Now()))
-			jsonC, err := json.Marshal(f.Interface())
-			if err != nil {
+	ensureCollection(dbCollection, collectionName)
+	storedById := map[string]map[string]interface{}{}
+	for _, item := range dbCollection.Items[collectionName] {
+		if stored, err := toDocument(item); err == nil {
+			storedById[documentId(stored)] = stored
+		}
+	}
+
+	c := DBInnerModel{}
+	var changes []Change
+	for i := 0; i < value.Len(); i++ {
+		if err := checkCancelled(ctx, i); err != nil {
+			return err
+		}
+		f := value.Index(i)
+		doc, err := toDocument(f.Interface())
+		if err != nil {
+			return err
+		}
+		stored, ok := storedById[documentId(doc)]
+		delete(storedById, documentId(doc))
+		if ok && sameContent(stored, doc) {
+			doc["version"] = stored["version"]
+			doc["updated_at"] = stored["updated_at"]
+		} else {
+			doc["version"] = documentVersion(stored) + 1
+			doc["updated_at"] = time.Now()
+			if ok {
+				changes = append(changes, Change{Kind: ChangeUpdate, Id: documentId(doc), Before: stored, After: doc})
+			} else {
+				changes = append(changes, Change{Kind: ChangeInsert, Id: documentId(doc), After: doc})
+			}
+		}
+		if f.CanAddr() {
+			if err := fromDocument(doc, f.Addr().Interface()); err != nil {
 				return err
 			}
-			_ = json.Unmarshal(jsonC, f.Interface())
-			c = append(c, f.Interface())
 		}
-		dbCollection.Items[tmpName] = c
-		return db.writeDatabase(dbCollection)
+		c = append(c, doc)
+	}
+	// items left in storedById are not in the new collection
+	for id, stored := range storedById {
+		changes = append(changes, Change{Kind: ChangeDelete, Id: id, Before: stored})
+	}
+	dbCollection.Items[collectionName] = c
+	changes = db.recordChanges(dbCollection, collectionName, changes)
+	err = db.writeDatabase(dbCollection)
+	if err != nil {
+		return err
+	}
+	db.notify(collectionName, changes...)
+	if idx, ok := db.indexes[collectionName]; ok {
+		idx.rebuild(c)
+	}
+	return nil
+}
+
+// CompareAndSwap replaces the item with id by data if its stored version equals version,
+// a zero version skips the check. data receives the new version on success
+func (db *database) CompareAndSwap(ctx context.Context, collection interface{}, id string, version int64, data interface{}) error {
+	doc, err := db.ModifyItem(ctx, collection, id, version, func(map[string]interface{}) (map[string]interface{}, error) {
+		return toDocument(data)
+	})
+	if err != nil {
+		return err
+	}
+	return fromDocument(doc, data)
+}
+
+// ModifyItem atomically replaces the item with id by the result of modify applied to its stored document,
+// a non zero version must equal the stored one. id, creation time, version and update time are managed
+// by the database whatever modify returns
+func (db *database) ModifyItem(ctx context.Context, collection interface{}, id string, version int64, modify func(doc map[string]interface{}) (map[string]interface{}, error)) (map[string]interface{}, error) {
+	defer db.acquire(ctx, "modify", db.getCollectionName(collection), true)()
+	dbCollection, err := db.getCollections(collection)
+	if err != nil {
+		return nil, err
+	}
+	collectionName := db.getCollectionName(collection)
+	c := dbCollection.Items[collectionName]
+	i, stored, err := findItem(ctx, c, id, version)
+	if err != nil {
+		return nil, err
+	}
+	storedVersion := documentVersion(stored)
+	createdAt := stored["created_at"]
+	before := copyDocument(stored)
+	doc, err := modify(stored)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, ErrNoDocument
+	}
+	doc["id"] = id
+	doc["created_at"] = createdAt
+	doc["version"] = storedVersion + 1
+	doc["updated_at"] = time.Now()
+	c[i] = doc
+	changes := db.recordChanges(dbCollection, collectionName, []Change{{Kind: ChangeUpdate, Id: id, Before: before, After: doc}})
+	err = db.writeDatabase(dbCollection)
+	if err != nil {
+		return nil, err
+	}
+	db.notify(collectionName, changes...)
+	if idx, ok := db.indexes[collectionName]; ok && idx.isBuilt() {
+		if err := idx.add(doc); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// RemoveFromCollection removes the item with id if its stored version equals version,
+// a zero version skips the check
+func (db *database) RemoveFromCollection(ctx context.Context, collection interface{}, id string, version int64) error {
+	defer db.acquire(ctx, "remove", db.getCollectionName(collection), true)()
+	dbCollection, err := db.getCollections(collection)
+	if err != nil {
+		return err
+	}
+	collectionName := db.getCollectionName(collection)
+	c := dbCollection.Items[collectionName]
+	i, stored, err := findItem(ctx, c, id, version)
+	if err != nil {
+		return err
+	}
+	dbCollection.Items[collectionName] = append(c[:i:i], c[i+1:]...)
+	changes := db.recordChanges(dbCollection, collectionName, []Change{{Kind: ChangeDelete, Id: id, Before: stored}})
+	err = db.writeDatabase(dbCollection)
+	if err != nil {
+		return err
+	}
+	db.notify(collectionName, changes...)
+	if idx, ok := db.indexes[collectionName]; ok && idx.isBuilt() {
+		idx.rebuild(dbCollection.Items[collectionName])
 	}
 	return nil
 }
 
+// findItem returns the position and document of the item with id,
+// it fails when the item does not exist, a non zero version does not match or ctx is done
+func findItem(ctx context.Context, c DBInnerModel, id string, version int64) (int, map[string]interface{}, error) {
+	for i, item := range c {
+		if err := checkCancelled(ctx, i); err != nil {
+			return 0, nil, err
+		}
+		stored, err := toDocument(item)
+		if err != nil {
+			return 0, nil, err
+		}
+		if documentId(stored) != id {
+			continue
+		}
+		if version != 0 && documentVersion(stored) != version {
+			return 0, nil, ErrVersionConflict
+		}
+		return i, stored, nil
+	}
+	return 0, nil, ErrNotFound
+}
+
 // GetFromCollection returns a collection from the database
-func (db *database) GetFromCollection(collection interface{}) (DBInnerModel, error) {
+func (db *database) GetFromCollection(ctx context.Context, collection interface{}) (DBInnerModel, error) {
+	defer db.acquire(ctx, "read", db.getCollectionName(collection), false)()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	dbCollection, err := db.getCollections(collection)
 	if err != nil {
 		return nil, err
@@ -202,6 +473,123 @@ func (db *database) GetFromCollection(collection interface{}) (DBInnerModel, err
 	return c, nil
 }
 
+// IndexFields enables full-text search on fields (json names) of a collection,
+// the index is built from stored items on the first search and kept in sync on writes
+func (db *database) IndexFields(collection interface{}, fields ...string) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.indexes[db.getCollectionName(collection)] = newInvertedIndex(fields)
+}
+
+// SetTTL makes items of a collection expire ttl after their last update, a zero ttl keeps them,
+// expired items are never returned and removed from the file on the next write
+func (db *database) SetTTL(collection interface{}, ttl time.Duration) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.ttls[db.getCollectionName(collection)] = ttl
+}
+
+// Search returns items of a collection matching every word of query, the most relevant first
+func (db *database) Search(ctx context.Context, collection interface{}, query string) (DBInnerModel, error) {
+	defer db.acquire(ctx, "search", db.getCollectionName(collection), false)()
+	collectionName := db.getCollectionName(collection)
+	idx, ok := db.indexes[collectionName]
+	if !ok {
+		return nil, ErrNoIndex
+	}
+	dbCollection, err := db.getCollections(collection)
+	if err != nil {
+		return nil, err
+	}
+	c := dbCollection.Items[collectionName]
+	if !idx.isBuilt() {
+		idx.rebuild(c)
+	}
+	itemsById := make(map[string]interface{}, len(c))
+	for i, item := range c {
+		if err := checkCancelled(ctx, i); err != nil {
+			return nil, err
+		}
+		if doc, err := toDocument(item); err == nil {
+			itemsById[documentId(doc)] = item
+		}
+	}
+	result := DBInnerModel{}
+	for _, hit := range idx.search(query) {
+		if item, ok := itemsById[hit.Id]; ok {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+// scanCheckInterval is the number of items scanned between two checks for cancellation
+const scanCheckInterval = 256
+
+// checkCancelled returns the error of ctx on every scanCheckInterval-th item of a scan
+func checkCancelled(ctx context.Context, i int) error {
+	if i%scanCheckInterval != 0 {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// toDocument converts a stored item or a model to its json field map
+func toDocument(item interface{}) (map[string]interface{}, error) {
+	if doc, ok := item.(map[string]interface{}); ok {
+		return doc, nil
+	}
+	var doc map[string]interface{}
+	b, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &doc)
+	return doc, err
+}
+
+// fromDocument fills a model from a json field map
+func fromDocument(doc map[string]interface{}, model interface{}) error {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, model)
+}
+
+// documentVersion returns the version of a document, zero for documents written before versioning
+func documentVersion(doc map[string]interface{}) int64 {
+	switch version := doc["version"].(type) {
+	case float64:
+		return int64(version)
+	case int64:
+		return version
+	case int:
+		return int64(version)
+	}
+	return 0
+}
+
+// sameContent reports whether two documents are equal apart from their version and update time
+func sameContent(a, b map[string]interface{}) bool {
+	strip := func(doc map[string]interface{}) map[string]interface{} {
+		result := make(map[string]interface{}, len(doc))
+		for k, v := range doc {
+			if k != "version" && k != "updated_at" {
+				result[k] = v
+			}
+		}
+		return result
+	}
+	return reflect.DeepEqual(strip(a), strip(b))
+}
+
+// documentId returns the id assigned to a document by WriteToCollection
+func documentId(doc map[string]interface{}) string {
+	id, _ := doc["id"].(string)
+	return id
+}
+
 // Where return one or more items from the database where the filter is true
 func (dbm *DBInnerModel) Where(fieldName string, value interface{}) *DBInnerModel {
 	var temp []interface{}
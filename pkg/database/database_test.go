@@ -0,0 +1,444 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestModifyItem(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	item := &testItem{Name: "car"}
+	if err := db.WriteToCollection(ctx, item); err != nil {
+		t.Fatal(err)
+	}
+	rename := func(doc map[string]interface{}) (map[string]interface{}, error) {
+		doc["name"] = "boat"
+		return doc, nil
+	}
+	tests := []struct {
+		name    string
+		id      string
+		version int64
+		modify  func(doc map[string]interface{}) (map[string]interface{}, error)
+		err     error
+	}{
+		{"missing item", "9", 0, rename, ErrNotFound},
+		{"old version", item.Id, 7, rename, ErrVersionConflict},
+		{"nil document", item.Id, 0, func(map[string]interface{}) (map[string]interface{}, error) { return nil, nil }, ErrNoDocument},
+		{"failing modification", item.Id, 0, func(map[string]interface{}) (map[string]interface{}, error) { return nil, ErrInvalidOperation }, ErrInvalidOperation},
+	}
+	for _, test := range tests {
+		if _, err := db.ModifyItem(ctx, item, test.id, test.version, test.modify); !errors.Is(err, test.err) {
+			t.Errorf("%s: error %v, want %v", test.name, err, test.err)
+		}
+	}
+	doc, err := db.ModifyItem(ctx, item, item.Id, 1, rename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc["name"] != "boat" || documentVersion(doc) != 2 || documentId(doc) != item.Id {
+		t.Errorf("modified document is %v", doc)
+	}
+}
+
+func TestZeroTTLKeepsItems(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	db.SetTTL(&testItem{}, 0)
+	if err := db.WriteToCollection(ctx, &testItem{Name: "car"}); err != nil {
+		t.Fatal(err)
+	}
+	items, err := db.GetFromCollection(ctx, &testItem{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Errorf("%d items are left with a zero ttl, want 1", len(items))
+	}
+}
+
+func TestOnChange(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	var changes []Change
+	remove := db.OnChange(&testItem{}, func(change Change) {
+		changes = append(changes, change)
+	})
+	item := &testItem{Name: "car"}
+	if err := db.WriteToCollection(ctx, item); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ModifyItem(ctx, item, item.Id, 0, func(doc map[string]interface{}) (map[string]interface{}, error) {
+		doc["name"] = "boat"
+		return doc, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// failed writes are not changes
+	db.RemoveFromCollection(ctx, item, item.Id, 7)
+	if err := db.RemoveFromCollection(ctx, item, item.Id, 0); err != nil {
+		t.Fatal(err)
+	}
+	remove()
+	if err := db.WriteToCollection(ctx, &testItem{Name: "plane"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		kind   ChangeKind
+		before string
+		after  string
+	}{
+		{ChangeInsert, "", "car"},
+		{ChangeUpdate, "car", "boat"},
+		{ChangeDelete, "boat", ""},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("%d changes, want %d: %+v", len(changes), len(want), changes)
+	}
+	for i, change := range changes {
+		before, _ := change.Before["name"].(string)
+		after, _ := change.After["name"].(string)
+		if change.Kind != want[i].kind || change.Id != item.Id || change.Collection == "" || before != want[i].before || after != want[i].after {
+			t.Errorf("change %d is %s of %q from %q to %q, want %s from %q to %q", i, change.Kind, change.Id, before, after, want[i].kind, want[i].before, want[i].after)
+		}
+	}
+}
+
+func TestDatabasesAreIndependent(t *testing.T) {
+	// pkg/database can not read config.AppConf, the config package imports it,
+	// so databases only use the bucket names of their options
+	ctx := context.Background()
+	dir := t.TempDir()
+	first := NewDatabase(WithBucketName(filepath.Join(dir, "first.json")))
+	second := NewDatabase(WithBucketName(filepath.Join(dir, "second.json")))
+	var item *testItem
+	firstChanges := 0
+	first.OnChange(item, func(Change) { firstChanges++ })
+	first.IndexFields(item, "name")
+
+	if err := first.WriteToCollection(ctx, &testItem{Name: "car"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.WriteToCollection(ctx, &testItem{Name: "boat"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		db   Database
+		name string
+	}{{first, "car"}, {second, "boat"}} {
+		items, err := test.db.GetFromCollection(ctx, item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 1 {
+			t.Fatalf("the database of %s holds %d items, want 1", test.name, len(items))
+		}
+		// every database numbers its own items
+		if doc, _ := toDocument(items[0]); doc["name"] != test.name || documentId(doc) != "1" {
+			t.Errorf("the database of %s holds %v", test.name, doc)
+		}
+	}
+	if firstChanges != 1 {
+		t.Errorf("the hook of the first database ran %d times, want 1", firstChanges)
+	}
+	if _, err := second.Search(ctx, item, "boat"); !errors.Is(err, ErrNoIndex) {
+		t.Errorf("search of the second database: %v, want %v", err, ErrNoIndex)
+	}
+	for _, name := range []string{"first.json", "second.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("bucket %s was not written: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(DefaultBucketName); err == nil {
+		t.Errorf("a database with a bucket name wrote %s", DefaultBucketName)
+	}
+}
+
+func TestWriteLag(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "missing")
+	db := NewDatabase(WithBucketName(filepath.Join(dir, "database.json")))
+	if lag := db.WriteLag(); lag != 0 {
+		t.Errorf("lag before any write is %s", lag)
+	}
+	// the directory of the bucket does not exist, so writes fail
+	for i := 0; i < 2; i++ {
+		if err := db.WriteToCollection(ctx, &testItem{Name: "car"}); err == nil {
+			t.Fatal("write to a missing directory succeeded")
+		}
+	}
+	first := db.WriteLag()
+	time.Sleep(10 * time.Millisecond)
+	if lag := db.WriteLag(); first <= 0 || lag < first+10*time.Millisecond {
+		t.Errorf("lag of failing writes is %s then %s, want it to grow from the first failure", first, lag)
+	}
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WriteToCollection(ctx, &testItem{Name: "car"}); err != nil {
+		t.Fatal(err)
+	}
+	if lag := db.WriteLag(); lag != 0 {
+		t.Errorf("lag after a successful write is %s", lag)
+	}
+}
+
+func TestCheckCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// scans check every scanCheckInterval-th item to stay cheap
+	for _, test := range []struct {
+		i   int
+		err error
+	}{{0, context.Canceled}, {1, nil}, {scanCheckInterval - 1, nil}, {scanCheckInterval, context.Canceled}, {3 * scanCheckInterval, context.Canceled}} {
+		if err := checkCancelled(ctx, test.i); !errors.Is(err, test.err) {
+			t.Errorf("item %d: %v, want %v", test.i, err, test.err)
+		}
+	}
+	if err := checkCancelled(context.Background(), scanCheckInterval); err != nil {
+		t.Errorf("a live context: %v", err)
+	}
+}
+
+func TestCancelledWritesChangeNothing(t *testing.T) {
+	db := newTestDatabase(t)
+	item := &testItem{Name: "car"}
+	if err := db.WriteToCollection(context.Background(), item); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rename := func(doc map[string]interface{}) (map[string]interface{}, error) {
+		doc["name"] = "boat"
+		return doc, nil
+	}
+	if _, err := db.ModifyItem(ctx, item, item.Id, 0, rename); !errors.Is(err, context.Canceled) {
+		t.Errorf("modify: %v, want %v", err, context.Canceled)
+	}
+	if _, err := db.ExecuteBatch(ctx, item, []BatchOperation{{Kind: BatchModify, Id: item.Id, Modify: rename}}, false); !errors.Is(err, context.Canceled) {
+		t.Errorf("batch: %v, want %v", err, context.Canceled)
+	}
+	items, err := db.GetFromCollection(context.Background(), item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc, _ := toDocument(items[0]); doc["name"] != "car" || documentVersion(doc) != 1 {
+		t.Errorf("a cancelled write changed the item to %v", doc)
+	}
+}
+
+// renameTo returns a modification renaming a document to name
+func renameTo(name string) func(doc map[string]interface{}) (map[string]interface{}, error) {
+	return func(doc map[string]interface{}) (map[string]interface{}, error) {
+		doc["name"] = name
+		return doc, nil
+	}
+}
+
+// changeNames returns the changes as "seq:kind:before:after" with the names of the documents
+func changeNames(changes []Change) []string {
+	var names []string
+	for _, change := range changes {
+		before, _ := change.Before["name"].(string)
+		after, _ := change.After["name"].(string)
+		names = append(names, strconv.FormatInt(change.Seq, 10)+":"+string(change.Kind)+":"+before+":"+after)
+	}
+	return names
+}
+
+func TestChanges(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	var item *testItem
+	if _, err := db.Changes(ctx, item, 0, 0); !errors.Is(err, ErrNoChangeLog) {
+		t.Errorf("changes without change log: %v, want %v", err, ErrNoChangeLog)
+	}
+	db.EnableChangeLog(item, 0)
+	car := &testItem{Name: "car"}
+	if err := db.WriteToCollection(ctx, car); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ModifyItem(ctx, car, car.Id, 0, renameTo("boat")); err != nil {
+		t.Fatal(err)
+	}
+	// failed writes are not logged
+	db.ModifyItem(ctx, car, car.Id, 7, renameTo("bike"))
+	if _, err := db.ExecuteBatch(ctx, item, []BatchOperation{
+		{Kind: BatchInsert, Data: &testItem{Name: "plane"}},
+		{Kind: BatchRemove, Id: car.Id},
+	}, true); err != nil {
+		t.Fatal(err)
+	}
+	all := []string{"1:insert::car", "2:update:car:boat", "3:insert::plane", "4:delete:boat:"}
+	tests := []struct {
+		from  int64
+		limit int
+		want  []string
+	}{
+		{0, 0, all},
+		{0, 2, all[:2]},
+		{2, 1, all[2:3]},
+		{3, 10, all[3:]},
+		{4, 0, nil},
+		{9, 0, nil},
+	}
+	for _, test := range tests {
+		changes, err := db.Changes(ctx, item, test.from, test.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := changeNames(changes); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("changes after %d limited to %d: %q, want %q", test.from, test.limit, got, test.want)
+		}
+	}
+	changes, _ := db.Changes(ctx, item, 0, 0)
+	for _, change := range changes {
+		if change.Collection == "" || change.Time.IsZero() || change.Id == "" {
+			t.Errorf("change %d is not stamped: %+v", change.Seq, change)
+		}
+	}
+	if seq, err := db.ChangeSeq(ctx); err != nil || seq != 4 {
+		t.Errorf("change seq is %d, %v, want 4", seq, err)
+	}
+}
+
+func TestChangesRetention(t *testing.T) {
+	bucket := filepath.Join(t.TempDir(), "database.json")
+	db := NewDatabase(WithBucketName(bucket))
+	ctx := context.Background()
+	var item *testItem
+	db.EnableChangeLog(item, 2)
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		if err := db.WriteToCollection(ctx, &testItem{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		from int64
+		want []string
+		err  error
+	}{
+		{0, nil, ErrChangesTrimmed},
+		{2, nil, ErrChangesTrimmed},
+		{3, []string{"4:insert::d", "5:insert::e"}, nil},
+		{4, []string{"5:insert::e"}, nil},
+	}
+	for _, test := range tests {
+		changes, err := db.Changes(ctx, item, test.from, 0)
+		if !errors.Is(err, test.err) {
+			t.Errorf("changes after %d: %v, want %v", test.from, err, test.err)
+		}
+		if got := changeNames(changes); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("changes after %d: %q, want %q", test.from, got, test.want)
+		}
+	}
+	if _, err := db.Watch(ctx, item, 1); !errors.Is(err, ErrChangesTrimmed) {
+		t.Errorf("watch after a trimmed change: %v, want %v", err, ErrChangesTrimmed)
+	}
+
+	// the log and its sequence numbers are kept in the database file
+	reopened := NewDatabase(WithBucketName(bucket))
+	reopened.EnableChangeLog(item, 2)
+	if err := reopened.WriteToCollection(ctx, &testItem{Name: "f"}); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := reopened.Changes(ctx, item, 4, 0)
+	if got := changeNames(changes); err != nil || !reflect.DeepEqual(got, []string{"5:insert::e", "6:insert::f"}) {
+		t.Errorf("changes of the reopened database: %q, %v", got, err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var item *testItem
+	db.EnableChangeLog(item, 0)
+	for i := 0; i < 5; i++ {
+		if err := db.WriteToCollection(ctx, &testItem{Name: "old"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// writes racing the watch are delivered once, either from the log or live
+	const total = 20
+	written := make(chan error, 1)
+	go func() {
+		for i := 5; i < total; i++ {
+			if err := db.WriteToCollection(ctx, &testItem{Name: "new"}); err != nil {
+				written <- err
+				return
+			}
+		}
+		written <- nil
+	}()
+	changes, err := db.Watch(ctx, item, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for seq := int64(3); seq <= total; seq++ {
+		select {
+		case change := <-changes:
+			if change.Seq != seq || change.Kind != ChangeInsert {
+				t.Fatalf("received change %d (%s), want %d", change.Seq, change.Kind, seq)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("change %d was not received", seq)
+		}
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case change := <-changes:
+		t.Fatalf("received change %d after the last write", change.Seq)
+	default:
+	}
+	cancel()
+	for range changes {
+	}
+}
+
+func TestWatchClosesLaggingWatchers(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	var item *testItem
+	db.EnableChangeLog(item, 0)
+	changes, err := db.Watch(ctx, item, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one batch notifies every change while the watcher is not read
+	batch := make([]BatchOperation, watchBuffer+2)
+	for i := range batch {
+		batch[i] = BatchOperation{Kind: BatchInsert, Data: &testItem{Name: "car"}}
+	}
+	if _, err := db.ExecuteBatch(ctx, item, batch, true); err != nil {
+		t.Fatal(err)
+	}
+	var last int64
+	for change := range changes {
+		if change.Seq != last+1 {
+			t.Fatalf("received change %d after %d", change.Seq, last)
+		}
+		last = change.Seq
+	}
+	if last == 0 || last >= int64(len(batch)) {
+		t.Fatalf("the watcher received %d of %d changes before it was closed", last, len(batch))
+	}
+	// watching again from the last received change resumes without a gap
+	resumed, err := db.Watch(ctx, item, last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change := <-resumed; change.Seq != last+1 {
+		t.Errorf("the resumed watcher starts at %d, want %d", change.Seq, last+1)
+	}
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/amupxm/pure-webserver/pkg/metrics"
+	"github.com/amupxm/pure-webserver/pkg/tracing"
+)
+
+// dbMetrics are the metrics of a database created with WithMetrics
+type dbMetrics struct {
+	operations *metrics.CounterVec
+	duration   *metrics.HistogramVec
+	lockWait   *metrics.HistogramVec
+	fileSize   *metrics.GaugeVec
+}
+
+// WithMetrics records operations, their latency, the lock wait time and the file size in registry
+func WithMetrics(registry *metrics.Registry) Option {
+	return func(db *database) {
+		db.metrics = &dbMetrics{
+			operations: registry.Counter("database_operations_total",
+				"Database operations by operation and collection.", "op", "collection"),
+			duration: registry.Histogram("database_operation_duration_seconds",
+				"Latency of database operations including the lock wait.", nil, "op", "collection"),
+			lockWait: registry.Histogram("database_lock_wait_seconds",
+				"Time spent waiting for the database lock.", nil, "op", "mode"),
+			fileSize: registry.Gauge("database_file_size_bytes",
+				"Size of the database file after the last read or write.", "bucket"),
+		}
+	}
+}
+
+// acquire takes the database lock for op on a collection, exclusively when write is true,
+// and returns the function releasing it and recording the operation and its span
+func (db *database) acquire(ctx context.Context, op, collectionName string, write bool) func() {
+	_, span := tracing.Start(ctx, "database."+op)
+	span.SetAttribute("db.system", "jsonfile")
+	span.SetAttribute("db.operation", op)
+	span.SetAttribute("db.collection", collectionName)
+	start := time.Now()
+	mode := "read"
+	if write {
+		mode = "write"
+		db.lock.Lock()
+	} else {
+		db.lock.RLock()
+	}
+	lockWait := time.Since(start)
+	span.SetAttribute("db.lock_wait_ms", float64(lockWait.Microseconds())/1000)
+	if db.metrics != nil {
+		db.metrics.lockWait.With(op, mode).Observe(lockWait.Seconds())
+	}
+	return func() {
+		if write {
+			db.lock.Unlock()
+		} else {
+			db.lock.RUnlock()
+		}
+		if db.metrics != nil {
+			db.metrics.operations.With(op, collectionName).Inc()
+			db.metrics.duration.With(op, collectionName).Observe(time.Since(start).Seconds())
+		}
+		span.End()
+	}
+}
+
+// observeFileSize records the size of the database file
+func (db *database) observeFileSize(size int) {
+	if db.metrics != nil {
+		db.metrics.fileSize.With(db.bucketName).Set(float64(size))
+	}
+}
@@ -0,0 +1,14 @@
+package database
+
+// Option configures a database created by NewDatabase
+type Option func(db *database)
+
+// DefaultBucketName is the json file of a database created without WithBucketName
+const DefaultBucketName = "database.json"
+
+// WithBucketName sets the json file holding the database, it is created on the first write
+func WithBucketName(bucketName string) Option {
+	return func(db *database) {
+		db.bucketName = bucketName
+	}
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// ErrCorrupted is returned by Ping when the database file is not valid json
+var ErrCorrupted = errors.New("database file is not valid json")
+
+// Ping fails when the database file can not be read, is not valid json or can not be written,
+// a missing file is fine as long as its directory is writable
+func (db *database) Ping(ctx context.Context) error {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	b, err := ioutil.ReadFile(db.bucketName)
+	if os.IsNotExist(err) {
+		probe, err := ioutil.TempFile(filepath.Dir(db.bucketName), ".ping-*")
+		if err != nil {
+			return err
+		}
+		probe.Close()
+		return os.Remove(probe.Name())
+	}
+	if err != nil {
+		return err
+	}
+	if len(b) > 0 && !json.Valid(b) {
+		return ErrCorrupted
+	}
+	f, err := os.OpenFile(db.bucketName, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// WriteLag returns how long writes have been failing to reach the database file, zero after a successful write.
+// Writes are persisted before they return and there is no write-ahead log, so failing writes are the only lag
+func (db *database) WriteLag() time.Duration {
+	since := atomic.LoadInt64(&db.failingSince)
+	if since == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, since))
+}
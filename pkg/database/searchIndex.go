@@ -0,0 +1,195 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+type (
+	// invertedIndex maps the tokens of the indexed fields to the items containing them
+	invertedIndex struct {
+		lock sync.RWMutex
+		// fields are the json field names which are tokenized
+		fields []string
+		// built is false until the index is filled from the stored collection
+		built bool
+		// postings maps token -> item id -> term frequency
+		postings map[string]map[string]int
+		// itemTokens keeps tokens of every item to remove stale postings on update
+		itemTokens map[string][]string
+		// terms is the sorted list of tokens used for prefix matching
+		terms []string
+		// termsSorted is false when terms must be rebuilt before a lookup
+		termsSorted bool
+	}
+	// searchHit is one item matched by a query and its relevance
+	searchHit struct {
+		Id    string
+		Score float64
+	}
+)
+
+const (
+	// exactMatchWeight is the weight of a query token which equals an indexed token
+	exactMatchWeight = 1.0
+	// prefixMatchWeight is the weight of a query token which is a prefix of an indexed token
+	prefixMatchWeight = 0.5
+)
+
+// newInvertedIndex creates an empty index over fields
+func newInvertedIndex(fields []string) *invertedIndex {
+	return &invertedIndex{
+		fields:     fields,
+		postings:   make(map[string]map[string]int),
+		itemTokens: make(map[string][]string),
+	}
+}
+
+// tokenize splits text into case folded words of letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// isBuilt reports whether the index has been filled from the stored collection
+func (idx *invertedIndex) isBuilt() bool {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+	return idx.built
+}
+
+// rebuild drops the index content and fills it with items
+func (idx *invertedIndex) rebuild(items DBInnerModel) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	idx.postings = make(map[string]map[string]int)
+	idx.itemTokens = make(map[string][]string)
+	for _, item := range items {
+		if doc, err := toDocument(item); err == nil {
+			idx.put(doc)
+		}
+	}
+	idx.termsSorted = false
+	idx.built = true
+}
+
+// add indexes item, replacing any previous version of the same id
+func (idx *invertedIndex) add(item interface{}) error {
+	doc, err := toDocument(item)
+	if err != nil {
+		return err
+	}
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	idx.put(doc)
+	idx.termsSorted = false
+	return nil
+}
+
+// put indexes doc, the caller must hold the write lock
+func (idx *invertedIndex) put(doc map[string]interface{}) {
+	id := documentId(doc)
+	if id == "" {
+		return
+	}
+	idx.remove(id)
+	var tokens []string
+	for _, field := range idx.fields {
+		value, ok := doc[field]
+		if !ok || value == nil {
+			continue
+		}
+		for _, token := range tokenize(fmt.Sprint(value)) {
+			if idx.postings[token] == nil {
+				idx.postings[token] = make(map[string]int)
+			}
+			idx.postings[token][id]++
+			tokens = append(tokens, token)
+		}
+	}
+	idx.itemTokens[id] = tokens
+}
+
+// remove drops every posting of id, the caller must hold the write lock
+func (idx *invertedIndex) remove(id string) {
+	for _, token := range idx.itemTokens[id] {
+		delete(idx.postings[token], id)
+		if len(idx.postings[token]) == 0 {
+			delete(idx.postings, token)
+		}
+	}
+	delete(idx.itemTokens, id)
+}
+
+// sortTerms rebuilds the sorted term list if postings changed since the last lookup
+func (idx *invertedIndex) sortTerms() {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	if idx.termsSorted {
+		return
+	}
+	idx.terms = idx.terms[:0]
+	for token := range idx.postings {
+		idx.terms = append(idx.terms, token)
+	}
+	sort.Strings(idx.terms)
+	idx.termsSorted = true
+}
+
+// search returns ids of items matching every token of query, the most relevant first.
+// A query token matches an indexed token which equals it or starts with it,
+// exact matches score higher than prefix ones and rare tokens higher than common ones.
+func (idx *invertedIndex) search(query string) []searchHit {
+	queryTokens := tokenize(query)
+	if len(queryTokens) == 0 {
+		return nil
+	}
+	idx.sortTerms()
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+
+	total := float64(len(idx.itemTokens))
+	scores := map[string]float64{}
+	matched := map[string]int{}
+	for _, queryToken := range queryTokens {
+		tokenScores := map[string]float64{}
+		for i := sort.SearchStrings(idx.terms, queryToken); i < len(idx.terms); i++ {
+			term := idx.terms[i]
+			if !strings.HasPrefix(term, queryToken) {
+				break
+			}
+			weight := prefixMatchWeight
+			if term == queryToken {
+				weight = exactMatchWeight
+			}
+			postings := idx.postings[term]
+			idf := math.Log(1 + total/float64(len(postings)))
+			for id, frequency := range postings {
+				tokenScores[id] += float64(frequency) * idf * weight
+			}
+		}
+		for id, score := range tokenScores {
+			scores[id] += score
+			matched[id]++
+		}
+	}
+
+	var hits []searchHit
+	for id, score := range scores {
+		if matched[id] == len(queryTokens) {
+			hits = append(hits, searchHit{Id: id, Score: score})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Id < hits[j].Id
+	})
+	return hits
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testItem is a model stored by the tests of the database
+type testItem struct {
+	DbModel
+	Name  string `json:"name"`
+	Brand string `json:"brand"`
+}
+
+// newTestDatabase returns a database in a temporary bucket
+func newTestDatabase(t *testing.T) Database {
+	t.Helper()
+	return NewDatabase(WithBucketName(filepath.Join(t.TempDir(), "database.json")))
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"Lego", []string{"lego"}},
+		{"Red-Car 2000!", []string{"red", "car", "2000"}},
+		{"  Über   Toy ", []string{"über", "toy"}},
+	}
+	for _, test := range tests {
+		got := tokenize(test.text)
+		if len(got) == 0 && len(test.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestInvertedIndexSearch(t *testing.T) {
+	idx := newInvertedIndex([]string{"name", "brand"})
+	idx.rebuild(DBInnerModel{
+		map[string]interface{}{"id": "1", "name": "Red racing car", "brand": "Toyco"},
+		map[string]interface{}{"id": "2", "name": "Blue car", "brand": "Seaside"},
+		map[string]interface{}{"id": "3", "name": "Rubber duck", "brand": "Toyco"},
+		map[string]interface{}{"id": "4", "name": "Card game", "brand": "Fun"},
+	})
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// exact matches rank before prefix matches of the same token
+		{"car", []string{"1", "2", "4"}},
+		{"toyco", []string{"1", "3"}},
+		{"red car", []string{"1"}},
+		{"RED", []string{"1"}},
+		{"duck toyco", []string{"3"}},
+		{"plane", nil},
+		{"car plane", nil},
+		{"  ", nil},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, hit := range idx.search(test.query) {
+			got = append(got, hit.Id)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("search(%q) = %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+func TestInvertedIndexReplacesStaleTokens(t *testing.T) {
+	idx := newInvertedIndex([]string{"name"})
+	idx.rebuild(nil)
+	if err := idx.add(map[string]interface{}{"id": "1", "name": "wooden train"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.add(map[string]interface{}{"id": "1", "name": "plastic train"}); err != nil {
+		t.Fatal(err)
+	}
+	if hits := idx.search("wooden"); len(hits) != 0 {
+		t.Errorf("search of a replaced token = %v, want no hits", hits)
+	}
+	if hits := idx.search("plastic"); len(hits) != 1 || hits[0].Id != "1" {
+		t.Errorf("search of the new token = %v, want item 1", hits)
+	}
+}
+
+func TestDatabaseSearch(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	var item *testItem
+	if _, err := db.Search(ctx, item, "car"); err != ErrNoIndex {
+		t.Fatalf("search without index error = %v, want %v", err, ErrNoIndex)
+	}
+	db.IndexFields(item, "name", "brand")
+	for _, name := range []string{"Red car", "Blue boat"} {
+		if err := db.WriteToCollection(ctx, &testItem{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := db.Search(ctx, item, "car")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 1 {
+		t.Fatalf("search returned %d items, want 1", len(result))
+	}
+	// items written after the index is built are found too
+	if err := db.WriteToCollection(ctx, &testItem{Name: "Toy car"}); err != nil {
+		t.Fatal(err)
+	}
+	result, err = db.Search(ctx, item, "car")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 2 {
+		t.Errorf("search after a write returned %d items, want 2", len(result))
+	}
+}
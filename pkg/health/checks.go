@@ -0,0 +1,58 @@
+package health
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// DiskSpace fails when the file system holding dir has less than minFree() bytes available,
+// minFree is called on every run so the limit can follow configuration reloads
+func DiskSpace(dir string, minFree func() uint64) Check {
+	return func(ctx context.Context) error {
+		free, err := freeSpace(dir)
+		if err != nil {
+			return err
+		}
+		if limit := minFree(); free < limit {
+			return fmt.Errorf("%d bytes free on %s, at least %d required", free, dir, limit)
+		}
+		return nil
+	}
+}
+
+// WriteLag fails when lag() is over max(), a zero max disables the check.
+// max is called on every run so the limit can follow configuration reloads
+func WriteLag(lag func() time.Duration, max func() time.Duration) Check {
+	return func(ctx context.Context) error {
+		limit := max()
+		if limit <= 0 {
+			return nil
+		}
+		if current := lag(); current > limit {
+			return fmt.Errorf("writes have been failing for %s, at most %s allowed", current.Round(time.Millisecond), limit)
+		}
+		return nil
+	}
+}
+
+var publishRuntime sync.Once
+
+// PublishRuntimeVars publishes a "runtime" variable with goroutines, cpus, go version and uptime
+// next to the memstats and cmdline variables of expvar, calling it again has no effect
+func PublishRuntimeVars(started time.Time) {
+	publishRuntime.Do(func() {
+		expvar.Publish("runtime", expvar.Func(func() interface{} {
+			return map[string]interface{}{
+				"goroutines":     runtime.NumGoroutine(),
+				"cpus":           runtime.NumCPU(),
+				"go_version":     runtime.Version(),
+				"uptime_seconds": time.Since(started).Seconds(),
+				"started_at":     started.UTC().Format(time.RFC3339),
+			}
+		}))
+	})
+}
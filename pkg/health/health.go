@@ -0,0 +1,129 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type (
+	// Check returns an error when the checked dependency is not healthy
+	Check func(ctx context.Context) error
+
+	// Checker runs named checks for one probe, like liveness or readiness
+	Checker struct {
+		lock    sync.RWMutex
+		checks  map[string]Check
+		timeout time.Duration
+	}
+
+	// Report is the outcome of every check of a probe
+	Report struct {
+		Status string                 `json:"status"`
+		Checks map[string]CheckReport `json:"checks,omitempty"`
+	}
+	// CheckReport is the outcome of one check
+	CheckReport struct {
+		Status     string  `json:"status"`
+		Error      string  `json:"error,omitempty"`
+		DurationMs float64 `json:"duration_ms"`
+	}
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// DefaultTimeout is the time a check may take before it is reported as failed
+const DefaultTimeout = 2 * time.Second
+
+// ErrTimeout is reported for checks which do not finish in time
+var ErrTimeout = errors.New("check timed out")
+
+// NewChecker creates a checker without checks, a zero timeout means DefaultTimeout
+func NewChecker(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Checker{checks: make(map[string]Check), timeout: timeout}
+}
+
+// Add registers a check under name, replacing a check with the same name
+func (c *Checker) Add(name string, check Check) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.checks[name] = check
+}
+
+// Run runs every check concurrently, the report fails when one of them fails
+func (c *Checker) Run(ctx context.Context) Report {
+	c.lock.RLock()
+	names := make([]string, 0, len(c.checks))
+	checks := make([]Check, 0, len(c.checks))
+	for name, check := range c.checks {
+		names = append(names, name)
+		checks = append(checks, check)
+	}
+	c.lock.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	reports := make([]CheckReport, len(checks))
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			reports[i] = runCheck(ctx, checks[i])
+		}(i)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckReport, len(names))}
+	for i, name := range names {
+		report.Checks[name] = reports[i]
+		if reports[i].Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+// runCheck runs check and gives up when ctx is done, the check goroutine is left to finish on its own
+func runCheck(ctx context.Context, check Check) CheckReport {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ErrTimeout
+	}
+	report := CheckReport{Status: StatusOK, DurationMs: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		report.Status, report.Error = StatusFail, err.Error()
+	}
+	return report
+}
+
+// Handler serves the report of the checker as json, with 503 when a check fails
+func (c *Checker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Run(r.Context())
+		code := http.StatusOK
+		if report.Status != StatusOK {
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,107 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckerRun(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	failing := func(context.Context) error { return errors.New("database is gone") }
+	hanging := func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(time.Second)
+		return nil
+	}
+	tests := []struct {
+		name   string
+		checks map[string]Check
+		status string
+		errors map[string]string
+	}{
+		{"no checks", nil, StatusOK, nil},
+		{"healthy", map[string]Check{"a": ok, "b": ok}, StatusOK, nil},
+		{"one failing", map[string]Check{"a": ok, "db": failing}, StatusFail, map[string]string{"db": "database is gone"}},
+		{"timeout", map[string]Check{"slow": hanging}, StatusFail, map[string]string{"slow": ErrTimeout.Error()}},
+	}
+	for _, test := range tests {
+		checker := NewChecker(50 * time.Millisecond)
+		for name, check := range test.checks {
+			checker.Add(name, check)
+		}
+		start := time.Now()
+		report := checker.Run(context.Background())
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("%s: run took %s", test.name, elapsed)
+		}
+		if report.Status != test.status || len(report.Checks) != len(test.checks) {
+			t.Errorf("%s: report is %+v", test.name, report)
+		}
+		for name, check := range report.Checks {
+			if check.Error != test.errors[name] {
+				t.Errorf("%s: check %s error is %q, want %q", test.name, name, check.Error, test.errors[name])
+			}
+		}
+	}
+}
+
+func TestCheckerHandler(t *testing.T) {
+	checker := NewChecker(0)
+	checker.Add("db", func(context.Context) error { return nil })
+	for _, test := range []struct {
+		err  error
+		code int
+	}{{nil, http.StatusOK}, {errors.New("down"), http.StatusServiceUnavailable}} {
+		checker.Add("disk", func(context.Context) error { return test.err })
+		recorder := httptest.NewRecorder()
+		checker.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+		var report Report
+		if err := json.NewDecoder(recorder.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		if recorder.Code != test.code || recorder.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("disk error %v: code %d, headers %v", test.err, recorder.Code, recorder.Header())
+		}
+		if report.Checks["db"].Status != StatusOK {
+			t.Errorf("disk error %v: report is %+v", test.err, report)
+		}
+	}
+}
+
+func TestDiskSpace(t *testing.T) {
+	dir := t.TempDir()
+	if err := DiskSpace(dir, func() uint64 { return 0 })(context.Background()); err != nil {
+		t.Errorf("no free space required: %v", err)
+	}
+	if err := DiskSpace(dir, func() uint64 { return math.MaxUint64 })(context.Background()); err == nil {
+		t.Error("more free space than any disk has is available")
+	}
+	if err := DiskSpace(dir+"/missing", func() uint64 { return 0 })(context.Background()); err == nil {
+		t.Error("a missing directory has free space")
+	}
+}
+
+func TestWriteLag(t *testing.T) {
+	lag := func() time.Duration { return time.Minute }
+	tests := []struct {
+		name string
+		max  time.Duration
+		ok   bool
+	}{
+		{"under the limit", time.Hour, true},
+		{"over the limit", time.Second, false},
+		{"disabled", 0, true},
+	}
+	for _, test := range tests {
+		err := WriteLag(lag, func() time.Duration { return test.max })(context.Background())
+		if (err == nil) != test.ok {
+			t.Errorf("%s: error %v", test.name, err)
+		}
+	}
+}
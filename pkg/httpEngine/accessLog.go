@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/amupxm/pure-webserver/pkg/logger"
+	"github.com/amupxm/pure-webserver/pkg/tracing"
+)
+
+type (
+	// statusWriter is a http.ResponseWriter which counts the status and bytes written
+	statusWriter struct {
+		http.ResponseWriter
+		status int
+		bytes  int
+	}
+	// requestIDKey is the context key of the request id
+	requestIDKey struct{}
+)
+
+// RequestIDHeader carries the request id from clients and to downstream services
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds request ids accepted from clients
+const maxRequestIDLength = 128
+
+// RequestID reuses the X-Request-ID header of the request or generates one, exposes it on
+// ServerContext.RequestID and the request context, and echoes it in the response header
+func RequestID() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *ServerContext) {
+			id := c.Request.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			c.RequestID = id
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDKey{}, id))
+			c.Response.Header().Set(RequestIDHeader, id)
+			next(c)
+		}
+	}
+}
+
+// RequestIDFromContext returns the request id set by the RequestID middleware
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// AccessLog writes one entry per request with method, route pattern, status, bytes, latency and client ip
+func AccessLog(l logger.Logger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *ServerContext) {
+			start := time.Now()
+			writer := &statusWriter{ResponseWriter: c.Response}
+			c.Response = writer
+			next(c)
+			fields := logger.Fields{
+				"method":     c.Request.Method,
+				"route":      c.Route,
+				"path":       c.Request.URL.Path,
+				"status":     writer.Status(),
+				"bytes":      writer.bytes,
+				"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+				"client_ip":  clientIP(c.Request),
+			}
+			if c.RequestID != "" {
+				fields["request_id"] = c.RequestID
+			}
+			if sc, ok := tracing.SpanContextFromContext(c.Context()); ok && sc.IsValid() {
+				fields["trace_id"] = sc.TraceID.String()
+			}
+			if forwardedFor := c.Request.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+				fields["forwarded_for"] = forwardedFor
+			}
+			l.Info("request", fields)
+		}
+	}
+}
+
+// validRequestID accepts short printable ascii ids so clients can not inject into logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if r <= ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns 16 random bytes as hex
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return hex.EncodeToString([]byte(time.Now().String()))[:32]
+	}
+	return hex.EncodeToString(b)
+}
+
+// clientIP returns the host of the remote address of a request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// WriteHeader counts and sends the status code
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write counts and sends a part of the body
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Status returns the status code sent, 200 when the handler wrote nothing
+func (w *statusWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Flush sends buffered data to the client when the wrapped writer supports it
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hijack takes over the connection when the wrapped writer supports it, the status is counted as 101
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amupxm/pure-webserver/pkg/logger"
+)
+
+func TestRequestID(t *testing.T) {
+	s := NewServer()
+	s.Use(RequestID())
+	var seen, fromContext string
+	s.AddHandler("/v1/toys", http.MethodGet, func(c *ServerContext) {
+		seen, fromContext = c.RequestID, RequestIDFromContext(c.Context())
+	})
+	tests := []struct {
+		name   string
+		header string
+		reused bool
+	}{
+		{"client id", "abc-123", true},
+		{"no id", "", false},
+		{"control characters", "abc\x01", false},
+		{"space", "abc 123", false},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+	generated := map[string]bool{}
+	for _, test := range tests {
+		recorder := serve(s, http.MethodGet, "/v1/toys", RequestIDHeader, test.header)
+		id := recorder.Header().Get(RequestIDHeader)
+		if id == "" || seen != id || fromContext != id {
+			t.Errorf("%s: response id %q, ServerContext.RequestID %q, context %q", test.name, id, seen, fromContext)
+		}
+		if test.reused {
+			if id != test.header {
+				t.Errorf("%s: id %q, want the id of the client %q", test.name, id, test.header)
+			}
+			continue
+		}
+		if len(id) != 32 || generated[id] {
+			t.Errorf("%s: generated id %q is not 16 new random bytes", test.name, id)
+		}
+		generated[id] = true
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var out bytes.Buffer
+	s := NewServer()
+	s.Use(RequestID(), AccessLog(logger.New(&out, logger.FormatJSON, logger.LevelInfo)))
+	s.AddHandler("/v1/toys/:iid", http.MethodGet, func(c *ServerContext) {
+		c.Response.WriteHeader(http.StatusTeapot)
+		c.Response.Write([]byte("short and stout"))
+	})
+	s.AddHandler("/v1/toys", http.MethodGet, func(c *ServerContext) {})
+
+	tests := []struct {
+		target string
+		route  string
+		status float64
+		bytes  float64
+	}{
+		{"/v1/toys/42", "/v1/toys/:iid", http.StatusTeapot, 15},
+		// handlers which write nothing answer 200
+		{"/v1/toys", "/v1/toys", http.StatusOK, 0},
+	}
+	for _, test := range tests {
+		out.Reset()
+		r := httptest.NewRequest(http.MethodGet, test.target, nil)
+		r.RemoteAddr = "192.0.2.7:4321"
+		r.Header.Set(RequestIDHeader, "abc-123")
+		s.ServeHTTP(httptest.NewRecorder(), r)
+		var entry map[string]interface{}
+		if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+			t.Fatalf("%s: entry %q is not json: %v", test.target, out.String(), err)
+		}
+		want := map[string]interface{}{
+			"msg":        "request",
+			"method":     http.MethodGet,
+			"route":      test.route,
+			"path":       test.target,
+			"status":     test.status,
+			"bytes":      test.bytes,
+			"client_ip":  "192.0.2.7",
+			"request_id": "abc-123",
+		}
+		for key, value := range want {
+			if entry[key] != value {
+				t.Errorf("%s: %s is %v, want %v", test.target, key, entry[key], value)
+			}
+		}
+		if _, ok := entry["latency_ms"].(float64); !ok {
+			t.Errorf("%s: entry has no latency: %q", test.target, out.String())
+		}
+	}
+}
@@ -0,0 +1,418 @@
+package controller
+
+import (
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amupxm/pure-webserver/constants"
+)
+
+type (
+	// BindOption configures one Bind call
+	BindOption func(o *bindOptions)
+
+	bindOptions struct {
+		maxBodySize           int64
+		disallowUnknownFields bool
+	}
+
+	// BindError points at the part of a request which could not be bound
+	BindError struct {
+		// Source is where the value came from: body, form, query, path or header
+		Source string
+		// Field names the offending field, empty when the whole input is malformed
+		Field string
+		Err   error
+	}
+)
+
+// DefaultMultipartMemory is how much of a multipart form is kept in memory, larger files are stored in temporary files
+const DefaultMultipartMemory = 32 << 20
+
+var (
+	// ErrBodyTooLarge is returned when a request body exceeds its limit, ErrorHandler answers it with 413
+	ErrBodyTooLarge = errors.New(constants.BodyTooLarge)
+	// ErrUnsupportedMediaType is returned for bodies Bind can not decode, ErrorHandler answers it with 415
+	ErrUnsupportedMediaType = errors.New(constants.UnsupportedMediaType)
+
+	errEmptyBody      = errors.New(constants.EmptyBody)
+	errUnknownField   = errors.New(constants.UnknownField)
+	durationType      = reflect.TypeOf(time.Duration(0))
+	fileHeaderType    = reflect.TypeOf((*multipart.FileHeader)(nil))
+	textUnmarshalType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// WithMaxBodySize fails binding bodies larger than size bytes with ErrBodyTooLarge
+func WithMaxBodySize(size int64) BindOption {
+	return func(o *bindOptions) {
+		o.maxBodySize = size
+	}
+}
+
+// DisallowUnknownFields fails binding json bodies, forms and query strings with fields the target does not have
+func DisallowUnknownFields() BindOption {
+	return func(o *bindOptions) {
+		o.disallowUnknownFields = true
+	}
+}
+
+// Error returns the source, field and reason of the failure
+func (e *BindError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("invalid %s: %v", e.Source, e.Err)
+	}
+	return fmt.Sprintf("invalid %s field %q: %v", e.Source, e.Field, e.Err)
+}
+
+// Unwrap returns the reason of the failure
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
+
+// Bind populates v from the path params, query string and headers of the request by the path, query and header
+// tags of its fields, then from the body by its Content-Type: json, xml, url-encoded or multipart forms.
+// Errors are BindErrors, ErrBodyTooLarge and ErrUnsupportedMediaType can be checked with errors.Is
+func (s *ServerContext) Bind(v interface{}, options ...BindOption) error {
+	// bodies may also bind to slices or maps, which have no tagged fields
+	if isStructPointer(v) {
+		if err := s.BindPath(v); err != nil {
+			return err
+		}
+		if err := s.BindQuery(v, options...); err != nil {
+			return err
+		}
+		if err := s.BindHeader(v); err != nil {
+			return err
+		}
+	}
+	if s.Request.Body == nil || s.Request.Body == http.NoBody {
+		return nil
+	}
+	switch s.ContentType() {
+	case "", "application/json":
+		return s.BindJSON(v, options...)
+	case "application/xml", "text/xml":
+		return s.BindXML(v, options...)
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		return s.BindForm(v, options...)
+	}
+	return &BindError{Source: "body", Err: ErrUnsupportedMediaType}
+}
+
+// BindJSON decodes the json body into v
+func (s *ServerContext) BindJSON(v interface{}, options ...BindOption) error {
+	o := newBindOptions(options)
+	body, err := s.limitedBody(o)
+	if err != nil {
+		return &BindError{Source: "body", Err: err}
+	}
+	decoder := json.NewDecoder(body)
+	if o.disallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(v); err != nil {
+		return jsonBindError(err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return &BindError{Source: "body", Err: errors.New("unexpected data after the json value")}
+	}
+	return nil
+}
+
+// BindXML decodes the xml body into v, unknown elements are always ignored
+func (s *ServerContext) BindXML(v interface{}, options ...BindOption) error {
+	body, err := s.limitedBody(newBindOptions(options))
+	if err != nil {
+		return &BindError{Source: "body", Err: err}
+	}
+	if err := xml.NewDecoder(body).Decode(v); err != nil {
+		if err == io.EOF {
+			err = errEmptyBody
+		}
+		return &BindError{Source: "body", Err: err}
+	}
+	return nil
+}
+
+// BindForm populates v from an url-encoded or multipart form by the form tags of its fields, falling back
+// to their json tags. Uploaded files bind to *multipart.FileHeader and []*multipart.FileHeader fields
+func (s *ServerContext) BindForm(v interface{}, options ...BindOption) error {
+	o := newBindOptions(options)
+	body, err := s.limitedBody(o)
+	if err != nil {
+		return &BindError{Source: "form", Err: err}
+	}
+	s.Request.Body = body
+	var values map[string][]string
+	var files map[string][]*multipart.FileHeader
+	if s.ContentType() == "multipart/form-data" {
+		err = s.Request.ParseMultipartForm(DefaultMultipartMemory)
+		if s.Request.MultipartForm != nil {
+			values, files = s.Request.MultipartForm.Value, s.Request.MultipartForm.File
+		}
+	} else {
+		err = s.Request.ParseForm()
+		values = s.Request.PostForm
+	}
+	if err != nil {
+		if errors.Is(err, ErrBodyTooLarge) {
+			err = ErrBodyTooLarge
+		}
+		return &BindError{Source: "form", Err: err}
+	}
+	return bindValues(v, "form", values, files, o.disallowUnknownFields)
+}
+
+// BindQuery populates v from the query string by the query tags of its fields,
+// the format param of Render is never an unknown field
+func (s *ServerContext) BindQuery(v interface{}, options ...BindOption) error {
+	values := s.Request.URL.Query()
+	values.Del(FormatQueryParam)
+	return bindValues(v, "query", values, nil, newBindOptions(options).disallowUnknownFields)
+}
+
+// BindPath populates v from the url params by the path tags of its fields
+func (s *ServerContext) BindPath(v interface{}) error {
+	values := make(map[string][]string, len(s.URLParams))
+	for name, value := range s.URLParams {
+		values[name] = []string{value}
+	}
+	return bindValues(v, "path", values, nil, false)
+}
+
+// BindHeader populates v from the request headers by the header tags of its fields
+func (s *ServerContext) BindHeader(v interface{}) error {
+	return bindValues(v, "header", s.Request.Header, nil, false)
+}
+
+// newBindOptions applies options
+func newBindOptions(options []BindOption) bindOptions {
+	var o bindOptions
+	for _, option := range options {
+		option(&o)
+	}
+	return o
+}
+
+// limitedBody returns the decoded body, limited to the max body size of o
+func (s *ServerContext) limitedBody(o bindOptions) (io.ReadCloser, error) {
+	body, err := s.decodedBody()
+	if err != nil {
+		if errors.Is(err, errUnsupportedEncoding) {
+			err = ErrUnsupportedMediaType
+		}
+		return nil, err
+	}
+	if o.maxBodySize > 0 {
+		body = &limitedBody{ReadCloser: body, limit: o.maxBodySize}
+	}
+	return body, nil
+}
+
+// jsonBindError converts a json decoding error to a BindError naming the field when it is known
+func jsonBindError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.Is(err, ErrBodyTooLarge):
+		return &BindError{Source: "body", Err: ErrBodyTooLarge}
+	case err == io.EOF:
+		return &BindError{Source: "body", Err: errEmptyBody}
+	case errors.As(err, &typeErr):
+		return &BindError{Source: "body", Field: typeErr.Field, Err: fmt.Errorf("must be %s, got %s", typeErr.Type, typeErr.Value)}
+	case errors.As(err, &syntaxErr):
+		return &BindError{Source: "body", Err: fmt.Errorf("%v at offset %d", syntaxErr, syntaxErr.Offset)}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		return &BindError{Source: "body", Field: field, Err: errUnknownField}
+	}
+	return &BindError{Source: "body", Err: err}
+}
+
+// bindValues sets the fields of the struct v points to from values by the source tag of the fields,
+// header names are compared canonically
+func bindValues(v interface{}, source string, values map[string][]string, files map[string][]*multipart.FileHeader, disallowUnknown bool) error {
+	if !isStructPointer(v) {
+		return &BindError{Source: source, Err: fmt.Errorf("can not bind to %T, it needs a pointer to a struct", v)}
+	}
+	value := reflect.ValueOf(v)
+	known := make(map[string]bool)
+	if err := bindFields(value.Elem(), source, values, files, known); err != nil {
+		return err
+	}
+	if !disallowUnknown {
+		return nil
+	}
+	var unknown []string
+	for name := range values {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	for name := range files {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return &BindError{Source: source, Field: unknown[0], Err: errUnknownField}
+	}
+	return nil
+}
+
+// isStructPointer reports whether v is a non nil pointer to a struct
+func isStructPointer(v interface{}) bool {
+	value := reflect.ValueOf(v)
+	return value.Kind() == reflect.Ptr && !value.IsNil() && value.Elem().Kind() == reflect.Struct
+}
+
+// bindFields sets the tagged fields of value, embedded structs are bound as if their fields were value's
+func bindFields(value reflect.Value, source string, values map[string][]string, files map[string][]*multipart.FileHeader, known map[string]bool) error {
+	t := value.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldValue := value.Field(i)
+		name := fieldName(field, source)
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			embedded := fieldValue
+			if embedded.Kind() == reflect.Ptr && embedded.Type().Elem().Kind() == reflect.Struct {
+				if embedded.IsNil() {
+					if !embedded.CanSet() {
+						continue
+					}
+					embedded.Set(reflect.New(embedded.Type().Elem()))
+				}
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if err := bindFields(embedded, source, values, files, known); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if name == "" || !fieldValue.CanSet() {
+			continue
+		}
+		if source == "header" {
+			name = textproto.CanonicalMIMEHeaderKey(name)
+		}
+		known[name] = true
+		if field.Type == fileHeaderType || field.Type == reflect.SliceOf(fileHeaderType) {
+			if headers := files[name]; len(headers) > 0 {
+				if field.Type == fileHeaderType {
+					fieldValue.Set(reflect.ValueOf(headers[0]))
+				} else {
+					fieldValue.Set(reflect.ValueOf(headers))
+				}
+			}
+			continue
+		}
+		raw, ok := values[name]
+		if !ok || len(raw) == 0 {
+			continue
+		}
+		if err := setField(fieldValue, raw); err != nil {
+			return &BindError{Source: source, Field: name, Err: err}
+		}
+	}
+	return nil
+}
+
+// fieldName returns the name of a field in source, form fields fall back to their json names
+func fieldName(field reflect.StructField, source string) string {
+	name := strings.Split(field.Tag.Get(source), ",")[0]
+	if name == "" && source == "form" {
+		name = strings.Split(field.Tag.Get("json"), ",")[0]
+	}
+	return name
+}
+
+// setField parses raw into a field, slices take every value and other fields the first one
+func setField(field reflect.Value, raw []string) error {
+	if field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.Uint8 && !reflect.PtrTo(field.Type()).Implements(textUnmarshalType) {
+		slice := reflect.MakeSlice(field.Type(), len(raw), len(raw))
+		for i, text := range raw {
+			if err := setValue(slice.Index(i), text); err != nil {
+				return fmt.Errorf("value %d: %w", i+1, err)
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+	return setValue(field, raw[0])
+}
+
+// setValue parses text into a value of a basic type, a time.Duration or an encoding.TextUnmarshaler
+func setValue(value reflect.Value, text string) error {
+	if value.Kind() == reflect.Ptr {
+		target := reflect.New(value.Type().Elem())
+		if err := setValue(target.Elem(), text); err != nil {
+			return err
+		}
+		value.Set(target)
+		return nil
+	}
+	if unmarshaler, ok := value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(text))
+	}
+	if value.Type() == durationType {
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return fmt.Errorf("must be a duration like \"30s\", got %q", text)
+		}
+		value.SetInt(int64(d))
+		return nil
+	}
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(text)
+	case reflect.Bool:
+		if text == "on" {
+			// checked html checkboxes
+			text = "true"
+		}
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("must be true or false, got %q", text)
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(text, 10, value.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be an integer, got %q", text)
+		}
+		value.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(text, 10, value.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a positive integer, got %q", text)
+		}
+		value.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(text, value.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a number, got %q", text)
+		}
+		value.SetFloat(f)
+	default:
+		return fmt.Errorf("can not bind to a field of type %s", value.Type())
+	}
+	return nil
+}
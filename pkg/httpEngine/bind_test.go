@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bindTarget struct {
+	ID      int           `path:"id"`
+	Limit   int           `query:"limit"`
+	Tags    []string      `query:"tag"`
+	Timeout time.Duration `query:"timeout"`
+	Token   string        `header:"x-token"`
+	Name    string        `json:"name" xml:"name" form:"name"`
+	Price   float64       `json:"price" xml:"price" form:"price"`
+	InStock bool          `json:"in_stock" xml:"in_stock" form:"in_stock"`
+}
+
+// bindContext returns the context of a request to target with body of contentType and the url params
+func bindContext(target, contentType, body string, params map[string]string) *ServerContext {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	if body == "" {
+		r.Body = http.NoBody
+	}
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	r.Header.Set("X-Token", "secret")
+	return &ServerContext{Response: httptest.NewRecorder(), Request: r, URLParams: params}
+}
+
+func TestBind(t *testing.T) {
+	want := bindTarget{ID: 7, Limit: 10, Tags: []string{"a", "b"}, Timeout: 2 * time.Second, Token: "secret", Name: "car", Price: 9.5, InStock: true}
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"json", "application/json", `{"name":"car","price":9.5,"in_stock":true}`},
+		{"json without content type", "", `{"name":"car","price":9.5,"in_stock":true}`},
+		{"xml", "application/xml", `<toy><name>car</name><price>9.5</price><in_stock>true</in_stock></toy>`},
+		{"form", "application/x-www-form-urlencoded", `name=car&price=9.5&in_stock=on`},
+	}
+	for _, test := range tests {
+		var got bindTarget
+		c := bindContext("/v1/toys/7?limit=10&tag=a&tag=b&timeout=2s&format=xml", test.contentType, test.body, map[string]string{"id": "7"})
+		if err := c.Bind(&got, DisallowUnknownFields()); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: bound %+v, want %+v", test.name, got, want)
+		}
+	}
+}
+
+func TestBindFieldErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		options     []BindOption
+		source      string
+		field       string
+		err         error
+	}{
+		{"path", "/v1/toys/x", "", "", nil, "path", "id", nil},
+		{"query integer", "/v1/toys/7?limit=ten", "", "", nil, "query", "limit", nil},
+		{"query duration", "/v1/toys/7?timeout=soon", "", "", nil, "query", "timeout", nil},
+		{"unknown query", "/v1/toys/7?color=red", "", "", []BindOption{DisallowUnknownFields()}, "query", "color", errUnknownField},
+		{"json type", "/v1/toys/7", "application/json", `{"price":"cheap"}`, nil, "body", "price", nil},
+		{"unknown json field", "/v1/toys/7", "application/json", `{"color":"red"}`, []BindOption{DisallowUnknownFields()}, "body", "color", errUnknownField},
+		{"json syntax", "/v1/toys/7", "application/json", `{"name":`, nil, "body", "", nil},
+		{"trailing json", "/v1/toys/7", "application/json", `{"name":"car"} {}`, nil, "body", "", nil},
+		{"empty json", "/v1/toys/7", "application/json", ` `, nil, "body", "", errEmptyBody},
+		{"large json", "/v1/toys/7", "application/json", `{"name":"` + strings.Repeat("a", 100) + `"}`, []BindOption{WithMaxBodySize(64)}, "body", "", ErrBodyTooLarge},
+		{"form bool", "/v1/toys/7", "application/x-www-form-urlencoded", `in_stock=maybe`, nil, "form", "in_stock", nil},
+		{"unknown form field", "/v1/toys/7", "application/x-www-form-urlencoded", `color=red`, []BindOption{DisallowUnknownFields()}, "form", "color", errUnknownField},
+		{"media type", "/v1/toys/7", "text/plain", `car`, nil, "body", "", ErrUnsupportedMediaType},
+	}
+	for _, test := range tests {
+		var got bindTarget
+		id := strings.TrimPrefix(strings.SplitN(test.target, "?", 2)[0], "/v1/toys/")
+		err := bindContext(test.target, test.contentType, test.body, map[string]string{"id": id}).Bind(&got, test.options...)
+		var bindErr *BindError
+		if !errors.As(err, &bindErr) {
+			t.Errorf("%s: error %v is no BindError", test.name, err)
+			continue
+		}
+		if bindErr.Source != test.source || bindErr.Field != test.field {
+			t.Errorf("%s: error points at %s field %q, want %s field %q", test.name, bindErr.Source, bindErr.Field, test.source, test.field)
+		}
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("%s: error %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestErrorHandlerBindErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		code        int
+		response    map[string]string
+	}{
+		{"field", "application/json", `{"price":"cheap"}`, http.StatusBadRequest, map[string]string{
+			"error": `invalid body field "price": must be float64, got string`, "source": "body", "field": "price",
+		}},
+		{"too large", "application/json", `{"name":"` + strings.Repeat("a", 100) + `"}`, http.StatusRequestEntityTooLarge, nil},
+		{"media type", "text/plain", "car", http.StatusUnsupportedMediaType, nil},
+	}
+	for _, test := range tests {
+		c := bindContext("/v1/toys/7", test.contentType, test.body, nil)
+		var got bindTarget
+		c.ErrorHandler(http.StatusBadRequest, c.Bind(&got, WithMaxBodySize(64)))
+		recorder := c.Response.(*httptest.ResponseRecorder)
+		if recorder.Code != test.code {
+			t.Errorf("%s: code %d, want %d", test.name, recorder.Code, test.code)
+		}
+		if test.response == nil {
+			continue
+		}
+		var response map[string]string
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(response, test.response) {
+			t.Errorf("%s: response %v, want %v", test.name, response, test.response)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"io"
+	"net/http"
+)
+
+// limitedBody fails with ErrBodyTooLarge once more than limit bytes were read
+type limitedBody struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+}
+
+// BodyLimit limits request bodies to the size limitFor returns for their method and route pattern, zero means
+// no limit. Bodies declaring a larger Content-Length get 413 right away, reading past the limit fails
+// with ErrBodyTooLarge which ErrorHandler answers with 413. Gzip bodies are limited before and after decompression
+func BodyLimit(limitFor func(method, route string) int64) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *ServerContext) {
+			limit := limitFor(c.Request.Method, c.Route)
+			if limit <= 0 || c.Route == "" || c.Request.Body == nil || c.Request.Body == http.NoBody {
+				next(c)
+				return
+			}
+			if c.Request.ContentLength > limit {
+				// the client is told not to send the body, so the connection can not be reused
+				c.Response.Header().Set("Connection", "close")
+				c.ErrorHandler(http.StatusRequestEntityTooLarge, ErrBodyTooLarge)
+				return
+			}
+			c.Request.Body = &limitedBody{ReadCloser: c.Request.Body, limit: limit}
+			next(c)
+		}
+	}
+}
+
+// Read reads from the body until more than limit bytes were read
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.read > b.limit {
+		return 0, ErrBodyTooLarge
+	}
+	// read one byte past the limit to tell a body of exactly limit bytes from a larger one
+	if remaining := b.limit - b.read + 1; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		return n - int(b.read-b.limit), ErrBodyTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,279 @@
+package controller
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// CompressOptions decides which responses are compressed
+	CompressOptions struct {
+		// MinSize is the smallest body in bytes which is compressed
+		MinSize int
+		// ContentTypes are the compressed media types like "application/json", "text/*" matches all text types
+		ContentTypes []string
+		// Level is a compress/flate level, flate.DefaultCompression when zero
+		Level int
+	}
+
+	// compressor creates the encoders of one compression middleware
+	compressor struct {
+		options CompressOptions
+		gzip    sync.Pool
+		deflate sync.Pool
+	}
+
+	// encoder is a pooled gzip or zlib writer
+	encoder interface {
+		io.WriteCloser
+		Flush() error
+		Reset(w io.Writer)
+	}
+
+	// compressWriter buffers the start of a response until it knows whether to compress it
+	compressWriter struct {
+		http.ResponseWriter
+		compressor *compressor
+		// encoding is the negotiated content coding, empty when the client accepts none
+		encoding string
+		status   int
+		buffer   []byte
+		decided  bool
+		encoder  encoder
+		// codedCondition is set when a conditional header held a tag of the compressed body
+		codedCondition bool
+	}
+)
+
+// Compress compresses responses with gzip or deflate as negotiated by Accept-Encoding.
+// Bodies are compressed when they reach options.MinSize, have an allowed content type and no Content-Encoding,
+// responses which could be compressed get Vary: Accept-Encoding. Compressed bodies get their own ETag like "abc-gzip",
+// which is accepted in If-Match and If-None-Match as the tag of the uncompressed body. Flushes are passed through, so streams keep working
+func Compress(options CompressOptions) Middleware {
+	if options.Level == 0 {
+		options.Level = flate.DefaultCompression
+	}
+	cmp := &compressor{options: options}
+	cmp.gzip.New = func() interface{} {
+		w, _ := gzip.NewWriterLevel(io.Discard, options.Level)
+		return w
+	}
+	cmp.deflate.New = func() interface{} {
+		w, _ := zlib.NewWriterLevel(io.Discard, options.Level)
+		return w
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *ServerContext) {
+			if c.Request.Method == http.MethodHead {
+				next(c)
+				return
+			}
+			w := &compressWriter{
+				ResponseWriter: c.Response,
+				compressor:     cmp,
+				encoding:       negotiateEncoding(c.Request.Header.Get("Accept-Encoding")),
+			}
+			if w.encoding != "" {
+				// handlers compare conditions with the tag of the uncompressed body
+				for _, name := range []string{"If-Match", "If-None-Match"} {
+					if tags, ok := uncodedETags(c.Request.Header.Get(name), w.encoding); ok {
+						c.Request.Header.Set(name, tags)
+						w.codedCondition = true
+					}
+				}
+			}
+			c.Response = w
+			next(c)
+			w.close()
+		}
+	}
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header by quality, gzip on ties
+func negotiateEncoding(header string) string {
+	best, bestQuality := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		coding, quality := part, 1.0
+		if i := strings.Index(part, ";"); i != -1 {
+			coding = part[:i]
+			param := strings.TrimSpace(part[i+1:])
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					continue
+				}
+				quality = q
+			}
+		}
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "*" {
+			coding = "gzip"
+		}
+		if coding != "gzip" && coding != "deflate" || quality <= 0 {
+			continue
+		}
+		if quality > bestQuality || quality == bestQuality && coding == "gzip" {
+			best, bestQuality = coding, quality
+		}
+	}
+	return best
+}
+
+// setCodedETag appends the content coding to the ETag of a response, like "abc" to "abc-gzip",
+// so the compressed body does not share the strong tag of the uncompressed one
+func setCodedETag(header http.Header, coding string) {
+	if etag := header.Get("ETag"); strings.HasSuffix(etag, `"`) {
+		header.Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+coding+`"`)
+	}
+}
+
+// uncodedETags removes the content coding from the tags of an If-Match or If-None-Match header,
+// reporting whether one of them had it
+func uncodedETags(header, coding string) (string, bool) {
+	suffix := "-" + coding + `"`
+	found := false
+	tags := strings.Split(header, ",")
+	for i, tag := range tags {
+		if tag = strings.TrimSpace(tag); strings.HasSuffix(tag, suffix) {
+			tags[i] = strings.TrimSuffix(tag, suffix) + `"`
+			found = true
+		}
+	}
+	return strings.Join(tags, ","), found
+}
+
+// allowed reports whether responses of contentType are compressed
+func (cmp *compressor) allowed(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range cmp.options.ContentTypes {
+		if allowed == mediaType || strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteHeader keeps the status until the response is known to be compressed or not
+func (w *compressWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// Write buffers the body until it reaches the minimum size, then writes it compressed or as it is
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.decided {
+		w.buffer = append(w.buffer, b...)
+		if len(w.buffer) < w.compressor.options.MinSize {
+			return len(b), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// decide writes the header and the buffered body, compressed when large is set and the response qualifies
+func (w *compressWriter) decide(large bool) error {
+	w.decided = true
+	header := w.Header()
+	if header.Get("Content-Type") == "" && len(w.buffer) > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buffer))
+	}
+	compressible := w.status != http.StatusNoContent && w.status != http.StatusNotModified && w.status >= http.StatusOK &&
+		header.Get("Content-Encoding") == "" && w.compressor.allowed(header.Get("Content-Type"))
+	if compressible && large {
+		addVary(header, "Accept-Encoding")
+	}
+	if w.status == http.StatusNotModified {
+		// the validated copy may be compressed, and is when its tag was
+		addVary(header, "Accept-Encoding")
+		if w.codedCondition {
+			setCodedETag(header, w.encoding)
+		}
+	}
+	if compressible && large && w.encoding != "" {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		setCodedETag(header, w.encoding)
+		if w.encoding == "gzip" {
+			w.encoder = w.compressor.gzip.Get().(*gzip.Writer)
+		} else {
+			w.encoder = w.compressor.deflate.Get().(*zlib.Writer)
+		}
+		w.encoder.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	buffer := w.buffer
+	w.buffer = nil
+	if len(buffer) == 0 {
+		return nil
+	}
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(buffer)
+	} else {
+		_, err = w.ResponseWriter.Write(buffer)
+	}
+	return err
+}
+
+// Flush sends what was written so far, a stream is compressed from its first flush on when it qualifies
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		w.decide(true)
+	}
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// close writes a response smaller than the minimum size as it is and finishes a compressed one
+func (w *compressWriter) close() {
+	if !w.decided {
+		if w.status == 0 {
+			// nothing was written, net/http answers 200 with an empty body
+			return
+		}
+		w.decide(false)
+	}
+	if w.encoder == nil {
+		return
+	}
+	w.encoder.Close()
+	if w.encoding == "gzip" {
+		w.compressor.gzip.Put(w.encoder)
+	} else {
+		w.compressor.deflate.Put(w.encoder)
+	}
+	w.encoder = nil
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
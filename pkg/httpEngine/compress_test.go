@@ -0,0 +1,285 @@
+package controller
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newCompressServer returns a server compressing json and text bodies of at least 64 bytes,
+// /small, /large, /image and /encoded write bodies which are too small, large, not allowed and already encoded
+func newCompressServer() Server {
+	s := NewServer()
+	s.Use(Compress(CompressOptions{MinSize: 64, ContentTypes: []string{"application/json", "text/*"}}))
+	write := func(contentType, body string, header ...string) HandlerFunc {
+		return func(c *ServerContext) {
+			c.Response.Header().Set("Content-Type", contentType)
+			for i := 0; i+1 < len(header); i += 2 {
+				c.Response.Header().Set(header[i], header[i+1])
+			}
+			c.Response.Write([]byte(body))
+		}
+	}
+	large := strings.Repeat("toy ", 100)
+	s.AddHandler("/small", http.MethodGet, write("application/json", `{"name":"car"}`))
+	s.AddHandler("/large", http.MethodGet, write("text/plain; charset=utf-8", large))
+	s.AddHandler("/image", http.MethodGet, write("image/png", large))
+	s.AddHandler("/encoded", http.MethodGet, write("text/plain", large, "Content-Encoding", "br"))
+	return s
+}
+
+// decompress returns the body of a response decoded by its Content-Encoding
+func decompress(t *testing.T, response *httptest.ResponseRecorder) string {
+	t.Helper()
+	var reader io.Reader = response.Body
+	var err error
+	switch response.Header().Get("Content-Encoding") {
+	case "gzip":
+		reader, err = gzip.NewReader(response.Body)
+	case "deflate":
+		reader, err = zlib.NewReader(response.Body)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"br", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"GZIP", "gzip"},
+		{"*", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip;q=0.8, deflate;q=0.9", "deflate"},
+		{"gzip;q=0, deflate;q=0.1", "deflate"},
+		{"gzip;q=0", ""},
+		{"gzip;q=x, deflate;q=0.2", "deflate"},
+		{"br, gzip ; q=0.3", "gzip"},
+	}
+	for _, test := range tests {
+		if encoding := negotiateEncoding(test.header); encoding != test.want {
+			t.Errorf("%q: %q, want %q", test.header, encoding, test.want)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	s := newCompressServer()
+	large := strings.Repeat("toy ", 100)
+	tests := []struct {
+		name     string
+		target   string
+		accept   string
+		encoding string
+		vary     string
+		body     string
+	}{
+		{"gzip", "/large", "gzip", "gzip", "Accept-Encoding", large},
+		{"deflate", "/large", "deflate", "deflate", "Accept-Encoding", large},
+		{"preferred deflate", "/large", "gzip;q=0.1, deflate", "deflate", "Accept-Encoding", large},
+		// the body could be compressed for other clients, so caches must tell them apart
+		{"not accepted", "/large", "br", "", "Accept-Encoding", large},
+		{"no accept encoding", "/large", "", "", "Accept-Encoding", large},
+		{"under min size", "/small", "gzip", "", "", `{"name":"car"}`},
+		{"not allowed content type", "/image", "gzip", "", "", large},
+		{"already encoded", "/encoded", "gzip", "br", "", large},
+	}
+	for _, test := range tests {
+		response := serve(s, http.MethodGet, test.target, "Accept-Encoding", test.accept)
+		header := response.Header()
+		if response.Code != http.StatusOK || header.Get("Content-Encoding") != test.encoding {
+			t.Errorf("%s: %d with Content-Encoding %q, want 200 with %q", test.name, response.Code, header.Get("Content-Encoding"), test.encoding)
+			continue
+		}
+		if vary := strings.Join(header.Values("Vary"), ", "); vary != test.vary {
+			t.Errorf("%s: Vary is %q, want %q", test.name, vary, test.vary)
+		}
+		body := response.Body.String()
+		if test.encoding == "gzip" || test.encoding == "deflate" {
+			body = decompress(t, response)
+		}
+		if body != test.body {
+			t.Errorf("%s: body is %q, want %q", test.name, body, test.body)
+		}
+	}
+}
+
+func TestCompressFlushStreams(t *testing.T) {
+	s := NewServer()
+	s.Use(Compress(CompressOptions{MinSize: 1024, ContentTypes: []string{"text/*"}}))
+	response := httptest.NewRecorder()
+	var flushed string
+	s.AddHandler("/v1/toys/events", http.MethodGet, func(c *ServerContext) {
+		c.Response.Header().Set("Content-Type", "text/event-stream")
+		c.Response.Write([]byte("data: car\n\n"))
+		c.Response.(http.Flusher).Flush()
+		// the first event is readable before the stream reaches the minimum size or ends
+		reader, err := gzip.NewReader(bytes.NewReader(response.Body.Bytes()))
+		if err != nil {
+			t.Errorf("flushed stream: %v", err)
+			return
+		}
+		event := make([]byte, len("data: car\n\n"))
+		if _, err := io.ReadFull(reader, event); err != nil {
+			t.Errorf("flushed event: %v", err)
+		}
+		flushed = string(event)
+		c.Response.Write([]byte("data: boat\n\n"))
+	})
+	r := httptest.NewRequest(http.MethodGet, "/v1/toys/events", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	s.ServeHTTP(response, r)
+
+	if flushed != "data: car\n\n" || !response.Flushed {
+		t.Errorf("flushed %q, recorder flushed %v", flushed, response.Flushed)
+	}
+	if encoding := response.Header().Get("Content-Encoding"); encoding != "gzip" {
+		t.Fatalf("stream Content-Encoding is %q, want gzip", encoding)
+	}
+	if body := decompress(t, response); body != "data: car\n\ndata: boat\n\n" {
+		t.Errorf("stream body is %q", body)
+	}
+}
+
+func TestCompressNotModifiedKeepsCodedETag(t *testing.T) {
+	s := NewServer()
+	s.Use(Compress(CompressOptions{MinSize: 64, ContentTypes: []string{"application/json"}}))
+	var condition string
+	s.AddHandler("/v1/toys/:id", http.MethodGet, func(c *ServerContext) {
+		condition = c.Request.Header.Get("If-None-Match")
+		etag := ETag("abc")
+		c.SetETag(etag)
+		if c.NotModified(etag) {
+			return
+		}
+		c.JSON(http.StatusOK, map[string]string{"name": strings.Repeat("car", 100)})
+	})
+	tests := []struct {
+		name      string
+		header    []string
+		code      int
+		etag      string
+		condition string
+	}{
+		{"gzip tag", []string{"Accept-Encoding", "gzip", "If-None-Match", `"abc-gzip"`}, http.StatusNotModified, `"abc-gzip"`, `"abc"`},
+		{"deflate tag", []string{"Accept-Encoding", "deflate", "If-None-Match", `"abc-deflate"`}, http.StatusNotModified, `"abc-deflate"`, `"abc"`},
+		// a client which cached the uncompressed body keeps its tag
+		{"plain tag", []string{"Accept-Encoding", "gzip", "If-None-Match", `"abc"`}, http.StatusNotModified, `"abc"`, `"abc"`},
+		{"tag of another coding", []string{"Accept-Encoding", "gzip", "If-None-Match", `"abc-deflate"`}, http.StatusOK, `"abc-gzip"`, `"abc-deflate"`},
+		{"stale gzip tag", []string{"Accept-Encoding", "gzip", "If-None-Match", `"old-gzip"`}, http.StatusOK, `"abc-gzip"`, `"old"`},
+	}
+	for _, test := range tests {
+		response := serve(s, http.MethodGet, "/v1/toys/1", test.header...)
+		header := response.Header()
+		if response.Code != test.code || header.Get("ETag") != test.etag {
+			t.Errorf("%s: %d with ETag %s, want %d with %s", test.name, response.Code, header.Get("ETag"), test.code, test.etag)
+		}
+		if condition != test.condition {
+			t.Errorf("%s: the handler saw If-None-Match %s, want %s", test.name, condition, test.condition)
+		}
+		if vary := header.Get("Vary"); vary != "Accept-Encoding" {
+			t.Errorf("%s: Vary is %q", test.name, vary)
+		}
+		if test.code == http.StatusNotModified && (response.Body.Len() != 0 || header.Get("Content-Encoding") != "") {
+			t.Errorf("%s: not modified response has a %d byte body encoded %q", test.name, response.Body.Len(), header.Get("Content-Encoding"))
+		}
+	}
+}
+
+func TestCompressedRequestBodies(t *testing.T) {
+	s := NewServer()
+	s.Use(BodyLimit(func(method, route string) int64 { return 64 }))
+	s.AddHandler("/v1/toys", http.MethodPost, func(c *ServerContext) {
+		var toy struct {
+			Name string `json:"name"`
+		}
+		if err := c.BindToJson(&toy); err != nil {
+			c.ErrorHandler(http.StatusBadRequest, err)
+			return
+		}
+		c.JSON(http.StatusOK, map[string]string{"name": toy.Name, "encoding": c.Request.Header.Get("Content-Encoding")})
+	})
+	compressed := func(body string) []byte {
+		var buffer bytes.Buffer
+		w := gzip.NewWriter(&buffer)
+		w.Write([]byte(body))
+		w.Close()
+		return buffer.Bytes()
+	}
+	// the compressed body fits the limit, the decompressed one does not
+	bomb := `{"name":"` + strings.Repeat("a", 1000) + `"}`
+	if n := len(compressed(bomb)); n > 64 {
+		t.Fatalf("the compressed large body has %d bytes", n)
+	}
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		code     int
+		want     string
+	}{
+		{"plain", "", []byte(`{"name":"car"}`), http.StatusOK, `{"encoding":"","name":"car"}`},
+		{"gzip", "gzip", compressed(`{"name":"car"}`), http.StatusOK, `{"encoding":"","name":"car"}`},
+		{"x-gzip", "X-Gzip", compressed(`{"name":"boat"}`), http.StatusOK, `{"encoding":"","name":"boat"}`},
+		{"decompressed body over the limit", "gzip", compressed(bomb), http.StatusRequestEntityTooLarge, ""},
+		{"unsupported encoding", "br", []byte(`{"name":"car"}`), http.StatusUnsupportedMediaType, ""},
+		{"not gzip", "gzip", []byte(`{"name":"car"}`), http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/v1/toys", bytes.NewReader(test.body))
+		r.Header.Set("Content-Type", "application/json")
+		if test.encoding != "" {
+			r.Header.Set("Content-Encoding", test.encoding)
+		}
+		response := httptest.NewRecorder()
+		s.ServeHTTP(response, r)
+		if response.Code != test.code {
+			t.Errorf("%s: code %d, want %d: %s", test.name, response.Code, test.code, response.Body)
+			continue
+		}
+		if body := strings.TrimSpace(response.Body.String()); test.want != "" && body != test.want {
+			t.Errorf("%s: body %s, want %s", test.name, body, test.want)
+		}
+	}
+}
+
+func TestDecodedBodyIsDecodedOnce(t *testing.T) {
+	var buffer bytes.Buffer
+	w := gzip.NewWriter(&buffer)
+	w.Write([]byte("car"))
+	w.Close()
+	c := bindContext("/v1/toys", "text/plain", buffer.String(), nil)
+	c.Request.Header.Set("Content-Encoding", "gzip")
+	first, err := c.decodedBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.decodedBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Error("a decoded body was decoded again")
+	}
+	if body, err := c.ReadBody(); err != nil || string(body) != "car" {
+		t.Errorf("body is %q, %v", body, err)
+	}
+}
@@ -0,0 +1,222 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amupxm/pure-webserver/constants"
+)
+
+type (
+	// CORSOptions decides which cross-origin requests browsers may send
+	CORSOptions struct {
+		// AllowedOrigins are exact origins like "https://toys.example.com", wildcard subdomains like
+		// "https://*.example.com", patterns like "regex:^https://pr-[0-9]+\.example\.com$" or "*" for any origin
+		AllowedOrigins []string
+		// AllowedMethods may be used by cross-origin requests, GET, HEAD and POST when empty
+		AllowedMethods []string
+		// AllowedHeaders may be sent by cross-origin requests, "*" allows any header
+		AllowedHeaders []string
+		// ExposedHeaders of responses may be read by scripts
+		ExposedHeaders []string
+		// AllowCredentials lets browsers send cookies and authorization headers
+		AllowCredentials bool
+		// MaxAge is how long browsers may cache the answer to a preflight, zero leaves it to the browser
+		MaxAge time.Duration
+	}
+
+	// CORSPolicy is a compiled CORSOptions
+	CORSPolicy struct {
+		options   CORSOptions
+		anyOrigin bool
+		origins   map[string]bool
+		wildcards []wildcardOrigin
+		patterns  []*regexp.Regexp
+		methods   map[string]bool
+		anyHeader bool
+		headers   map[string]bool
+	}
+
+	// wildcardOrigin matches origins like "https://*.example.com" by the parts around the star
+	wildcardOrigin struct {
+		prefix string
+		suffix string
+	}
+)
+
+// regexOriginPrefix marks allowed origins which are regular expressions
+const regexOriginPrefix = "regex:"
+
+var errCORSNotAllowed = errors.New(constants.CORSNotAllowed)
+
+// NewCORSPolicy compiles options, it fails on malformed origins
+func NewCORSPolicy(options CORSOptions) (*CORSPolicy, error) {
+	p := &CORSPolicy{
+		options: options,
+		origins: make(map[string]bool),
+		methods: make(map[string]bool),
+		headers: make(map[string]bool),
+	}
+	for _, origin := range options.AllowedOrigins {
+		switch {
+		case origin == "*":
+			p.anyOrigin = true
+		case strings.HasPrefix(origin, regexOriginPrefix):
+			// origins are compared in lower case, so patterns match case-insensitively
+			pattern, err := regexp.Compile("(?i)^(?:" + strings.TrimPrefix(origin, regexOriginPrefix) + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("allowed origin %q is not a valid regular expression: %w", origin, err)
+			}
+			p.patterns = append(p.patterns, pattern)
+		case strings.Contains(origin, "*"):
+			scheme := strings.Index(origin, "://")
+			if scheme == -1 || !strings.HasPrefix(origin[scheme+3:], "*.") || strings.Count(origin, "*") != 1 {
+				return nil, fmt.Errorf("allowed origin %q must look like \"https://*.example.com\"", origin)
+			}
+			p.wildcards = append(p.wildcards, wildcardOrigin{
+				prefix: strings.ToLower(origin[:scheme+3]),
+				suffix: strings.ToLower(origin[scheme+4:]),
+			})
+		default:
+			if !strings.Contains(origin, "://") || strings.HasSuffix(origin, "/") {
+				return nil, fmt.Errorf("allowed origin %q must look like \"https://example.com\"", origin)
+			}
+			p.origins[strings.ToLower(origin)] = true
+		}
+	}
+	methods := options.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+	for _, method := range methods {
+		p.methods[strings.ToUpper(method)] = true
+	}
+	for _, header := range options.AllowedHeaders {
+		if header == "*" {
+			p.anyHeader = true
+		}
+		p.headers[strings.ToLower(header)] = true
+	}
+	return p, nil
+}
+
+// AllowOrigin reports whether requests from origin are allowed
+func (p *CORSPolicy) AllowOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+	for _, wildcard := range p.wildcards {
+		if len(origin) > len(wildcard.prefix)+len(wildcard.suffix) &&
+			strings.HasPrefix(origin, wildcard.prefix) && strings.HasSuffix(origin, wildcard.suffix) &&
+			!strings.ContainsAny(origin[len(wildcard.prefix):len(origin)-len(wildcard.suffix)], "/:@") {
+			return true
+		}
+	}
+	for _, pattern := range p.patterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowHeaders reports whether every header of a comma separated list is allowed
+func (p *CORSPolicy) allowHeaders(list string) bool {
+	if p.anyHeader {
+		return true
+	}
+	for _, header := range strings.Split(list, ",") {
+		header = strings.ToLower(strings.TrimSpace(header))
+		if header != "" && !p.headers[header] {
+			return false
+		}
+	}
+	return true
+}
+
+// CORS adds the cors headers of the policy returned by policy to responses of allowed origins
+// and answers preflights of registered routes with 204, or 403 when the origin, method or headers are not allowed.
+// A nil policy disables cors, so it can be switched by configuration reloads
+func CORS(policy func() *CORSPolicy) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *ServerContext) {
+			p := policy()
+			if p == nil {
+				next(c)
+				return
+			}
+			header := c.Response.Header()
+			origin := c.Request.Header.Get("Origin")
+			if !p.anyOrigin || p.options.AllowCredentials {
+				header.Add("Vary", "Origin")
+			}
+			requestMethod := strings.ToUpper(c.Request.Header.Get("Access-Control-Request-Method"))
+			preflight := c.Request.Method == http.MethodOptions && origin != "" && requestMethod != ""
+			if preflight && c.Route != "" {
+				p.preflight(c, origin, requestMethod)
+				return
+			}
+			if p.AllowOrigin(origin) {
+				p.allowOrigin(header, origin)
+				if len(p.options.ExposedHeaders) > 0 {
+					header.Set("Access-Control-Expose-Headers", strings.Join(p.options.ExposedHeaders, ", "))
+				}
+			}
+			next(c)
+		}
+	}
+}
+
+// preflight answers a preflight request for a registered route
+func (p *CORSPolicy) preflight(c *ServerContext, origin, requestMethod string) {
+	header := c.Response.Header()
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+	requestHeaders := c.Request.Header.Get("Access-Control-Request-Headers")
+	var methods []string
+	registered := false
+	for _, method := range c.methods {
+		if p.methods[method] {
+			methods = append(methods, method)
+			registered = registered || method == requestMethod
+		}
+	}
+	if !p.AllowOrigin(origin) || !registered || !p.allowHeaders(requestHeaders) {
+		c.ErrorHandler(http.StatusForbidden, errCORSNotAllowed)
+		return
+	}
+	p.allowOrigin(header, origin)
+	header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if requestHeaders != "" {
+		header.Set("Access-Control-Allow-Headers", requestHeaders)
+	}
+	if p.options.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(p.options.MaxAge.Seconds())))
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+}
+
+// allowOrigin sets the headers allowing origin, the origin is echoed when credentials are allowed
+// since browsers reject "*" then
+func (p *CORSPolicy) allowOrigin(header http.Header, origin string) {
+	if p.anyOrigin && !p.options.AllowCredentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.options.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
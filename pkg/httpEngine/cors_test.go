@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serve sends a request built from method, target and header pairs to s and records the response
+func serve(s Server, method, target string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, r)
+	return recorder
+}
+
+// newCORSServer returns a server with GET and PUT /v1/toys/:id behind the cors policy of options
+func newCORSServer(t *testing.T, options CORSOptions) Server {
+	t.Helper()
+	policy, err := NewCORSPolicy(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	s.Use(CORS(func() *CORSPolicy { return policy }))
+	for _, method := range []string{http.MethodGet, http.MethodPut} {
+		s.AddHandler("/v1/toys/:id", method, func(c *ServerContext) {
+			c.JSON(http.StatusOK, map[string]string{"id": c.URLParams["id"]})
+		})
+	}
+	return s
+}
+
+func TestCORSPreflight(t *testing.T) {
+	s := newCORSServer(t, CORSOptions{
+		AllowedOrigins: []string{"https://toys.example.com", "https://*.shop.example.com", `regex:^https://pr-[0-9]+\.example\.com$`},
+		AllowedMethods: []string{"GET", "PUT", "DELETE"},
+		AllowedHeaders: []string{"Content-Type", "If-Match"},
+		MaxAge:         10 * time.Minute,
+	})
+	tests := []struct {
+		name    string
+		target  string
+		origin  string
+		method  string
+		headers string
+		code    int
+	}{
+		{"exact origin", "/v1/toys/1", "https://toys.example.com", "PUT", "content-type, If-Match", http.StatusNoContent},
+		{"origin case", "/v1/toys/1", "HTTPS://TOYS.example.com", "get", "", http.StatusNoContent},
+		{"wildcard subdomain", "/v1/toys/1", "https://eu.shop.example.com", "PUT", "", http.StatusNoContent},
+		{"wildcard without subdomain", "/v1/toys/1", "https://shop.example.com", "PUT", "", http.StatusForbidden},
+		{"wildcard with userinfo", "/v1/toys/1", "https://evil.com@x.shop.example.com", "PUT", "", http.StatusForbidden},
+		{"regex origin", "/v1/toys/1", "https://pr-42.example.com", "GET", "", http.StatusNoContent},
+		{"regex is anchored", "/v1/toys/1", "https://pr-42.example.com.evil.com", "GET", "", http.StatusForbidden},
+		{"unknown origin", "/v1/toys/1", "https://evil.com", "GET", "", http.StatusForbidden},
+		{"method allowed but not registered", "/v1/toys/1", "https://toys.example.com", "DELETE", "", http.StatusForbidden},
+		{"method registered but not allowed", "/v1/toys/1", "https://toys.example.com", "POST", "", http.StatusForbidden},
+		{"header not allowed", "/v1/toys/1", "https://toys.example.com", "PUT", "X-Secret", http.StatusForbidden},
+		{"unknown route", "/v1/cars", "https://toys.example.com", "GET", "", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		response := serve(s, http.MethodOptions, test.target,
+			"Origin", test.origin, "Access-Control-Request-Method", test.method, "Access-Control-Request-Headers", test.headers)
+		if response.Code != test.code {
+			t.Errorf("%s: code %d, want %d", test.name, response.Code, test.code)
+			continue
+		}
+		header := response.Header()
+		if test.code != http.StatusNoContent {
+			if test.code == http.StatusForbidden && header.Get("Access-Control-Allow-Origin") != "" {
+				t.Errorf("%s: rejected preflight allows origin %q", test.name, header.Get("Access-Control-Allow-Origin"))
+			}
+			continue
+		}
+		if header.Get("Access-Control-Allow-Origin") != test.origin ||
+			header.Get("Access-Control-Allow-Methods") != "GET, PUT" ||
+			header.Get("Access-Control-Allow-Headers") != test.headers ||
+			header.Get("Access-Control-Max-Age") != "600" {
+			t.Errorf("%s: headers %v", test.name, header)
+		}
+		if vary := strings.Join(header.Values("Vary"), ", "); vary != "Origin, Access-Control-Request-Method, Access-Control-Request-Headers" {
+			t.Errorf("%s: Vary is %q", test.name, vary)
+		}
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	tests := []struct {
+		credentials bool
+		allowOrigin string
+		vary        string
+	}{
+		{false, "*", ""},
+		{true, "https://toys.example.com", "Origin"},
+	}
+	for _, test := range tests {
+		s := newCORSServer(t, CORSOptions{
+			AllowedOrigins:   []string{"*"},
+			AllowedHeaders:   []string{"*"},
+			ExposedHeaders:   []string{"ETag", "X-Request-ID"},
+			AllowCredentials: test.credentials,
+		})
+		preflight := serve(s, http.MethodOptions, "/v1/toys/1",
+			"Origin", "https://toys.example.com", "Access-Control-Request-Method", "GET", "Access-Control-Request-Headers", "X-Anything")
+		if preflight.Code != http.StatusNoContent || preflight.Header().Get("Access-Control-Allow-Origin") != test.allowOrigin {
+			t.Errorf("credentials %v: preflight %d with headers %v", test.credentials, preflight.Code, preflight.Header())
+		}
+		response := serve(s, http.MethodGet, "/v1/toys/1", "Origin", "https://toys.example.com")
+		header := response.Header()
+		if header.Get("Access-Control-Allow-Origin") != test.allowOrigin || header.Get("Vary") != test.vary ||
+			header.Get("Access-Control-Expose-Headers") != "ETag, X-Request-ID" ||
+			(header.Get("Access-Control-Allow-Credentials") == "true") != test.credentials {
+			t.Errorf("credentials %v: response headers %v", test.credentials, header)
+		}
+	}
+}
+
+func TestCORSSimpleRequest(t *testing.T) {
+	s := newCORSServer(t, CORSOptions{AllowedOrigins: []string{"https://toys.example.com"}})
+	if header := serve(s, http.MethodGet, "/v1/toys/1", "Origin", "https://evil.com").Header(); header.Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("a request of an unknown origin is allowed: %v", header)
+	}
+	// OPTIONS without Access-Control-Request-Method is no preflight and gets the allowed methods
+	response := serve(s, http.MethodOptions, "/v1/toys/1", "Origin", "https://toys.example.com")
+	if response.Code != http.StatusNoContent || response.Header().Get("Allow") != "GET, PUT, OPTIONS" {
+		t.Errorf("options request: %d with headers %v", response.Code, response.Header())
+	}
+}
+
+func TestCORSDisabled(t *testing.T) {
+	s := NewServer()
+	s.Use(CORS(func() *CORSPolicy { return nil }))
+	s.AddHandler("/v1/toys", http.MethodGet, func(c *ServerContext) {})
+	response := serve(s, http.MethodOptions, "/v1/toys", "Origin", "https://toys.example.com", "Access-Control-Request-Method", "GET")
+	if response.Header().Get("Access-Control-Allow-Origin") != "" || response.Header().Get("Vary") != "" {
+		t.Errorf("disabled cors sets headers %v", response.Header())
+	}
+}
+
+func TestNewCORSPolicyRejectsMalformedOrigins(t *testing.T) {
+	for _, origin := range []string{"toys.example.com", "https://toys.example.com/", "https://*example.com", "*.example.com", "https://*.*.example.com", "regex:("} {
+		if _, err := NewCORSPolicy(CORSOptions{AllowedOrigins: []string{origin}}); err == nil {
+			t.Errorf("origin %q is accepted", origin)
+		}
+	}
+}
+
+func TestCORSOriginsIgnoreCase(t *testing.T) {
+	policy, err := NewCORSPolicy(CORSOptions{AllowedOrigins: []string{"https://Toys.Example.com", "https://*.Shop.Example.com", `regex:^https://PR-[0-9]+\.Example\.com$`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://toys.example.com", true},
+		{"https://TOYS.EXAMPLE.COM", true},
+		{"https://cars.shop.example.com", true},
+		{"https://pr-42.example.com", true},
+		{"https://PR-42.EXAMPLE.COM", true},
+		{"https://pr-x.example.com", false},
+	}
+	for _, test := range tests {
+		if allowed := policy.AllowOrigin(test.origin); allowed != test.allowed {
+			t.Errorf("origin %s allowed %t, want %t", test.origin, allowed, test.allowed)
+		}
+	}
+}
@@ -1,34 +1,64 @@
 package controller
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
+	"io"
+	"io/fs"
 	"io/ioutil"
-	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"strings"
+	"time"
 
-	"github.com/amupxm/pure-webserver/config"
 	"github.com/amupxm/pure-webserver/constants"
+	"github.com/amupxm/pure-webserver/pkg/logger"
+	"github.com/amupxm/pure-webserver/pkg/tracing"
+	"github.com/amupxm/pure-webserver/pkg/websocket"
 )
 
 type (
 	Server interface {
 		// AddHandler adds a new handler to the server
 		AddHandler(path, method string, handler func(c *ServerContext))
+		// Use adds middlewares which wrap every handler, the first one runs first
+		Use(middlewares ...Middleware)
+		// Static serves the files of a file system under a path prefix
+		Static(prefix string, fsys fs.FS, options StaticOptions)
+		// WebSocket serves websockets on a path
+		WebSocket(path string, handler WebSocketHandler, options websocket.Options)
 		//mainEngineHandler is the main handler which calls on every request to find the right handler
 		mainEngineHandler(w http.ResponseWriter, r *http.Request)
 		// filterRoutesByPath is a helper function to filter routes by path
 		filterRoutesByPath(path string) []serverRoutes
 		// StartServer starts the server
-		StartServer(port string)
+		StartServer() error
+		// ServeHTTP serves one request, so the server can be mounted in other http servers
+		ServeHTTP(w http.ResponseWriter, r *http.Request)
 		//filterMatchedRoutesByMethod is a helper function to filter matched routes by method
 		filterMatchedRoutesByMethod(method string, mc []serverRoutes) []serverRoutes
+		// filterMostSpecificRoutes is a helper function to prefer static path segments over url params
+		filterMostSpecificRoutes(mc []serverRoutes) []serverRoutes
 		// extractURLParams is a helper function to extract url params
 		extractURLParams(master, slave string) map[string]string
+		// findHandler is a helper function to find the handler and url params of a request
+		findHandler(c *ServerContext) HandlerFunc
 	}
 	server struct {
-		Port string
+		Port        string
+		routes      []serverRoutes
+		middlewares []Middleware
+		// tracer records spans of requests when the server is created WithTracer
+		tracer *tracing.Tracer
+		// renderers are the formats of ServerContext.Render
+		renderers *Renderers
+		// timeouts protect the server from slow clients, zero ones are disabled
+		timeouts Timeouts
+		// maxHeaderBytes limits request headers, http.DefaultMaxHeaderBytes when zero
+		maxHeaderBytes int
 	}
 	serverRoutes struct {
 		Path          string
@@ -41,43 +71,110 @@ type (
 		Response  http.ResponseWriter
 		Request   *http.Request
 		URLParams map[string]string
+		// Route is the path pattern of the matched route, empty when no route matched
+		Route string
+		// RequestID identifies the request in logs, set by the RequestID middleware
+		RequestID string
+		// methods are the methods registered for the path of the request
+		methods []string
+		// renderers are the formats of Render
+		renderers *Renderers
 	}
 	ServerContextInterface interface {
 		// ErrorHandler is a helper function to handle errors and return them to the client
 		ErrorHandler(code int, err error)
+		// Context returns the context of the request, carrying its trace span
+		Context() context.Context
 		// GetURLParam is a helper function to get url param
 		GetURLParam(param string) (string, error)
+		// GetQueryParam is a helper function to get query string param
+		GetQueryParam(param string) (string, error)
 		// JSON is a helper function to return json response
 		JSON(core int, response interface{})
+		// Render writes response in the format negotiated by Accept or ?format=
+		Render(code int, response interface{})
+		// RepresentationETag returns etag for the format Render picks for the request
+		RepresentationETag(etag string) string
 		// BindToJson is a helper function to bind struct to json
 		BindToJson(c interface{}) error
+		// Bind populates a struct from path params, query, headers and the body by its Content-Type
+		Bind(v interface{}, options ...BindOption) error
+		// BindJSON decodes the json body
+		BindJSON(v interface{}, options ...BindOption) error
+		// BindXML decodes the xml body
+		BindXML(v interface{}, options ...BindOption) error
+		// BindForm populates a struct from an url-encoded or multipart form
+		BindForm(v interface{}, options ...BindOption) error
+		// BindQuery populates a struct from the query string
+		BindQuery(v interface{}, options ...BindOption) error
+		// BindPath populates a struct from the url params
+		BindPath(v interface{}) error
+		// BindHeader populates a struct from the request headers
+		BindHeader(v interface{}) error
+		// EventStream starts a stream of server-sent events
+		EventStream(retry time.Duration) (*EventStream, error)
+		// LastEventID returns the id of the last event a reconnecting client received
+		LastEventID() string
+		// StreamMultipart calls handle for every part of a multipart body as it arrives
+		StreamMultipart(handle func(part *multipart.Part) error) error
+		// ReadBody is a helper function to read the whole request body, gzip bodies are decompressed
+		ReadBody() ([]byte, error)
+		// ContentType is a helper function to get the media type of the request body
+		ContentType() string
+		// SetETag sets the ETag header of the response
+		SetETag(etag string)
+		// NotModified writes 304 when If-None-Match matches etag on reads
+		NotModified(etag string) bool
+		// PreconditionFailed writes 412 when If-Match or If-None-Match do not hold for etag on writes
+		PreconditionFailed(etag string) bool
 	}
 )
 
-var serverRoutesMap []serverRoutes
+var (
+	errPreconditionFailed  = errors.New(constants.PreconditionFailed)
+	errUnsupportedEncoding = errors.New(constants.UnsupportedEncoding)
+)
 
-// NewServer creates new server instance with port defined in config
-func NewServer() Server {
+// NewServer creates new server instance, every server has its own routes and middlewares
+func NewServer(options ...Option) Server {
 	serverAbstract := &server{
-		Port: config.AppConf.Http.Port,
+		Port:      DefaultPort,
+		renderers: DefaultRenderers(),
+	}
+	for _, option := range options {
+		option(serverAbstract)
 	}
 	return serverAbstract
 }
 
-// StartServer starts the server
-func (s *server) StartServer(port string) {
+// StartServer starts the server and blocks until it fails
+func (s *server) StartServer() error {
+	return s.httpServer().ListenAndServe()
+}
+
+// httpServer creates the net/http server of StartServer with the timeouts and header limit of the server
+func (s *server) httpServer() *http.Server {
 	router := http.NewServeMux()
 	router.HandleFunc("/", s.mainEngineHandler)
-	httpServer := &http.Server{
-		Addr:    ":" + port,
-		Handler: router,
+	return &http.Server{
+		Addr:              ":" + s.Port,
+		Handler:           router,
+		ReadHeaderTimeout: s.timeouts.ReadHeader,
+		ReadTimeout:       s.timeouts.Read,
+		WriteTimeout:      s.timeouts.Write,
+		IdleTimeout:       s.timeouts.Idle,
+		MaxHeaderBytes:    s.maxHeaderBytes,
 	}
-	httpServer.ListenAndServe()
+}
+
+// ServeHTTP serves one request, so the server can be mounted in other http servers
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mainEngineHandler(w, r)
 }
 
 // AddHandler adds a new handler to the server
 func (s *server) AddHandler(path, method string, handler func(c *ServerContext)) {
-	serverRoutesMap = append(serverRoutesMap, serverRoutes{
+	s.routes = append(s.routes, serverRoutes{
 		Path:          path,
 		RequestMethod: method,
 		Handler:       handler,
@@ -85,30 +182,86 @@ func (s *server) AddHandler(path, method string, handler func(c *ServerContext))
 	})
 }
 
+// WrapHandler adapts a net/http handler so it can be added as a route handler
+func WrapHandler(h http.Handler) HandlerFunc {
+	return func(c *ServerContext) {
+		h.ServeHTTP(c.Response, c.Request)
+	}
+}
+
 //mainEngineHandler is the main handler which calls on every request to find the right handler
 func (s *server) mainEngineHandler(w http.ResponseWriter, r *http.Request) {
-	method := r.Method
+	c := &ServerContext{
+		Response:  w,
+		Request:   r,
+		renderers: s.renderers,
+	}
+	var handler HandlerFunc
+	if s.tracer != nil {
+		defer s.traceRequest(c)()
+		handler = s.findTracedHandler(c)
+	} else {
+		handler = s.findHandler(c)
+	}
+	for i := len(s.middlewares) - 1; i >= 0; i-- {
+		handler = s.middlewares[i](handler)
+	}
+	handler(c)
+}
+
+// findHandler is a helper function to find the handler and url params of a request,
+// it returns a not found handler when no route matches
+func (s *server) findHandler(c *ServerContext) HandlerFunc {
+	method := c.Request.Method
 
 	// to Check if is path allowed
-	matchedRoutes := s.filterRoutesByPath(r.RequestURI)
+	matchedRoutes := s.filterRoutesByPath(c.Request.URL.Path)
 	if len(matchedRoutes) == 0 {
-		http.NotFound(w, r)
-		return
+		return notFoundHandler
+	}
+	c.methods = routeMethods(matchedRoutes)
+
+	// OPTIONS is answered for every registered path unless a route handles it
+	if method == http.MethodOptions && len(s.filterMatchedRoutesByMethod(method, matchedRoutes)) == 0 {
+		route := s.filterMostSpecificRoutes(matchedRoutes)[0]
+		c.Route = route.Path
+		c.URLParams = s.extractURLParams(route.Path, c.Request.URL.Path)
+		return optionsHandler
 	}
 
 	// to check if the method is allowed
 	matchedRoutes = s.filterMatchedRoutesByMethod(method, matchedRoutes)
+	matchedRoutes = s.filterMostSpecificRoutes(matchedRoutes)
 	if len(matchedRoutes) != 1 {
-		http.NotFound(w, r)
-		return
-	}
-	matchedRoutes[0].Handler(
-		&ServerContext{
-			Response:  w,
-			Request:   r,
-			URLParams: s.extractURLParams(matchedRoutes[0].Path, r.RequestURI),
-		},
-	)
+		return notFoundHandler
+	}
+	c.Route = matchedRoutes[0].Path
+	c.URLParams = s.extractURLParams(matchedRoutes[0].Path, c.Request.URL.Path)
+	return matchedRoutes[0].Handler
+}
+
+// notFoundHandler writes 404 for requests without a matching route
+func notFoundHandler(c *ServerContext) {
+	http.NotFound(c.Response, c.Request)
+}
+
+// optionsHandler writes 204 with the methods registered for the path in Allow
+func optionsHandler(c *ServerContext) {
+	c.Response.Header().Set("Allow", strings.Join(append(c.methods, http.MethodOptions), ", "))
+	c.Response.WriteHeader(http.StatusNoContent)
+}
+
+// routeMethods returns the methods of routes without duplicates
+func routeMethods(routes []serverRoutes) []string {
+	var methods []string
+	seen := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		if !seen[route.RequestMethod] {
+			seen[route.RequestMethod] = true
+			methods = append(methods, route.RequestMethod)
+		}
+	}
+	return methods
 }
 
 // extractURLParams is a helper function to extract url params
@@ -117,6 +270,15 @@ func (s *server) extractURLParams(master, slave string) map[string]string {
 	masterArr := strings.Split(master, "/")
 	slaveArr := strings.Split(slave, "/")
 	for i, str := range masterArr {
+		if strings.HasPrefix(str, "*") {
+			// a wildcard takes the rest of the path
+			if i < len(slaveArr) {
+				res[str[1:]] = strings.Join(slaveArr[i:], "/")
+			} else {
+				res[str[1:]] = ""
+			}
+			break
+		}
 		if strings.Contains(str, ":") {
 			// to compare if be mistake in arr
 			// FIXME : may cause error on master or slave size difference
@@ -129,17 +291,27 @@ func (s *server) extractURLParams(master, slave string) map[string]string {
 // filterRoutesByPath is a helper function to filter routes by path
 func (s *server) filterRoutesByPath(path string) []serverRoutes {
 	var matchedRoutes []serverRoutes
-	for _, route := range serverRoutesMap {
+	for _, route := range s.routes {
 		// add extra slash to the end of pathes
 		if !strings.HasSuffix(path, "/") {
 			path = path + "/"
 		}
-		if !strings.HasSuffix(route.Path, "/") {
-			route.Path = route.Path + "/"
+		routePath := route.Path
+		if !strings.HasSuffix(routePath, "/") {
+			routePath = routePath + "/"
 		}
-		splittedMasterRoute := strings.Split(route.Path, "/") // it will be simething like ["s","s2","s3",":id"] which id is structure of route
+		splittedMasterRoute := strings.Split(routePath, "/") // it will be simething like ["s","s2","s3",":id"] which id is structure of route
 		splittedSlaveRoute := strings.Split(path, "/")        // it will be something like ["s","s2","s3","1212"] which 1212 is id in request
 
+		// a wildcard segment like "*filepath" ends the pattern and matches the rest of the path
+		if wildcard := len(splittedMasterRoute) - 2; wildcard > 0 && strings.HasPrefix(splittedMasterRoute[wildcard], "*") {
+			if len(splittedSlaveRoute) <= wildcard {
+				continue
+			}
+			splittedMasterRoute = splittedMasterRoute[:wildcard]
+			splittedSlaveRoute = splittedSlaveRoute[:wildcard]
+		}
+
 		if len(splittedMasterRoute) == len(splittedSlaveRoute) {
 			matched := true
 			for i, c := range splittedMasterRoute {
@@ -167,6 +339,33 @@ func (s *server) filterMatchedRoutesByMethod(method string, mc []serverRoutes) [
 	return matchedRoutes
 }
 
+// filterMostSpecificRoutes is a helper function to prefer static path segments over url params
+// so "/v1/toys/search" wins over "/v1/toys/:iid", and url params over wildcards
+func (s *server) filterMostSpecificRoutes(mc []serverRoutes) []serverRoutes {
+	var matchedRoutes []serverRoutes
+	leastParams := -1
+	for _, route := range mc {
+		params := strings.Count(route.Path, ":")
+		// a wildcard is less specific than any number of params
+		if strings.Contains(route.Path, "*") {
+			params += 1 << 16
+		}
+		if leastParams == -1 || params < leastParams {
+			leastParams = params
+			matchedRoutes = nil
+		}
+		if params == leastParams {
+			matchedRoutes = append(matchedRoutes, route)
+		}
+	}
+	return matchedRoutes
+}
+
+// Context returns the context of the request, carrying its trace span
+func (s *ServerContext) Context() context.Context {
+	return s.Request.Context()
+}
+
 // GetURLParam is a helper function to get url param
 func (s *ServerContext) GetURLParam(param string) (string, error) {
 	if s.URLParams[param] == "" {
@@ -175,21 +374,50 @@ func (s *ServerContext) GetURLParam(param string) (string, error) {
 	return s.URLParams[param], nil
 }
 
-// ErrorHandler is a helper function to handle errors and return them to the client
+// GetQueryParam is a helper function to get query string param
+func (s *ServerContext) GetQueryParam(param string) (string, error) {
+	value := s.Request.URL.Query().Get(param)
+	if value == "" {
+		return "", errors.New(constants.NoQuery)
+	}
+	return value, nil
+}
+
+// ErrorHandler is a helper function to handle errors and return them to the client,
+// work stopped by a deadline or a cancellation gets 504 or 503 whatever code the handler chose,
+// bodies too large get 413 and bodies which can not be decoded 415
 func (s *ServerContext) ErrorHandler(code int, err error) {
-	s.JSON(code, map[string]string{
+	if status, contextErr := contextErrorStatus(err); status != 0 {
+		code, err = status, contextErr
+	} else if status, contextErr := contextErrorStatus(s.Context().Err()); status != 0 {
+		code, err = status, contextErr
+	}
+	switch {
+	case errors.Is(err, ErrBodyTooLarge):
+		code = http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrUnsupportedMediaType), errors.Is(err, errUnsupportedEncoding):
+		code = http.StatusUnsupportedMediaType
+	}
+	response := map[string]string{
 		"error": err.Error(),
-	})
+	}
+	// binding errors point at the offending field
+	var bindErr *BindError
+	if errors.As(err, &bindErr) && bindErr.Field != "" {
+		response["source"], response["field"] = bindErr.Source, bindErr.Field
+	}
+	s.JSON(code, response)
 }
 
 // JSON is a helper function to return json response
 func (s *ServerContext) JSON(core int, response interface{}) {
-	s.Response.Header().Set("Content-Type", "application/json")
-	s.Response.WriteHeader(core)
 	jsoned, err := json.Marshal(response)
 	if err != nil {
-		log.Fatal(err)
+		logger.Default().Error("can not encode response", logger.Fields{"error": err, "request_id": s.RequestID})
+		core, jsoned = http.StatusInternalServerError, []byte(`{"error":"internal server error"}`)
 	}
+	s.Response.Header().Set("Content-Type", "application/json")
+	s.Response.WriteHeader(core)
 	s.Response.Write(
 		[]byte(
 			jsoned,
@@ -197,20 +425,48 @@ func (s *ServerContext) JSON(core int, response interface{}) {
 	)
 }
 
-// BindToJson is a helper function to bind struct to json
-func (s *ServerContext) BindToJson(c interface{}) error {
-	body, err := ioutil.ReadAll(s.Request.Body)
+// ReadBody is a helper function to read the whole request body, gzip bodies are decompressed
+func (s *ServerContext) ReadBody() ([]byte, error) {
+	body, err := s.decodedBody()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = json.Unmarshal(
-		[]byte(
-			body,
-		),
-		c,
-	)
+	return ioutil.ReadAll(body)
+}
+
+// decodedBody replaces a gzip request body by its decompressed stream and drops Content-Encoding,
+// so the body is decoded once however often it is read or restored
+func (s *ServerContext) decodedBody() (io.ReadCloser, error) {
+	switch strings.ToLower(s.Request.Header.Get("Content-Encoding")) {
+	case "", "identity":
+		return s.Request.Body, nil
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(s.Request.Body)
+		if err != nil {
+			return nil, err
+		}
+		// a limit of the compressed body also limits the decompressed one
+		if limited, ok := s.Request.Body.(*limitedBody); ok {
+			s.Request.Body = &limitedBody{ReadCloser: reader, limit: limited.limit}
+		} else {
+			s.Request.Body = reader
+		}
+		s.Request.Header.Del("Content-Encoding")
+		return s.Request.Body, nil
+	}
+	return nil, errUnsupportedEncoding
+}
+
+// ContentType is a helper function to get the media type of the request body without parameters
+func (s *ServerContext) ContentType() string {
+	mediaType, _, err := mime.ParseMediaType(s.Request.Header.Get("Content-Type"))
 	if err != nil {
-		return err
+		return ""
 	}
-	return nil
+	return mediaType
+}
+
+// BindToJson is a helper function to bind struct to json
+func (s *ServerContext) BindToJson(c interface{}) error {
+	return s.BindJSON(c)
 }
@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ETag quotes value to a strong entity tag
+func ETag(value string) string {
+	return `"` + value + `"`
+}
+
+// SetETag sets the ETag header of the response
+func (s *ServerContext) SetETag(etag string) {
+	s.Response.Header().Set("ETag", etag)
+}
+
+// NotModified checks If-None-Match of GET and HEAD requests against etag
+// and writes 304 when the client already has the current representation
+func (s *ServerContext) NotModified(etag string) bool {
+	if s.Request.Method != http.MethodGet && s.Request.Method != http.MethodHead {
+		return false
+	}
+	header := s.Request.Header.Get("If-None-Match")
+	if header == "" || etag == "" || !matchETag(header, etag, false) {
+		return false
+	}
+	s.SetETag(etag)
+	s.Response.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// PreconditionFailed checks If-Match and If-None-Match of write requests against etag
+// (empty when the resource does not exist) and writes 412 when one of them fails
+func (s *ServerContext) PreconditionFailed(etag string) bool {
+	failed := false
+	if header := s.Request.Header.Get("If-Match"); header != "" {
+		failed = etag == "" || !matchETag(header, etag, true)
+	}
+	if header := s.Request.Header.Get("If-None-Match"); header != "" && !failed {
+		failed = etag != "" && matchETag(header, etag, false)
+	}
+	if failed {
+		s.ErrorHandler(http.StatusPreconditionFailed, errPreconditionFailed)
+	}
+	return failed
+}
+
+// matchETag reports whether a comma separated If-Match or If-None-Match header matches etag,
+// strong comparison never matches weak tags (RFC 7232 section 2.3.2)
+func matchETag(header, etag string, strong bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strong {
+			if !strings.HasPrefix(candidate, "W/") && !strings.HasPrefix(etag, "W/") && candidate == etag {
+				return true
+			}
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// newETagServer returns a server rendering a large list with the tag "abc" like the product handlers,
+// PUT answers 204 unless its preconditions fail
+func newETagServer() Server {
+	type toy struct {
+		Name string `json:"name" xml:"name"`
+	}
+	s := NewServer()
+	s.Use(Compress(CompressOptions{MinSize: 1024, ContentTypes: []string{"application/json", "text/*"}}))
+	s.AddHandler("/v1/toys", http.MethodGet, func(c *ServerContext) {
+		etag := c.RepresentationETag(ETag("abc"))
+		c.SetETag(etag)
+		if c.NotModified(etag) {
+			return
+		}
+		c.Render(http.StatusOK, []toy{{Name: strings.Repeat("toy", 1000)}})
+	})
+	s.AddHandler("/v1/toys", http.MethodPut, func(c *ServerContext) {
+		if c.PreconditionFailed(ETag("abc")) {
+			return
+		}
+		c.Response.WriteHeader(http.StatusNoContent)
+	})
+	return s
+}
+
+func TestRepresentationETag(t *testing.T) {
+	s := newETagServer()
+	tests := []struct {
+		name   string
+		target string
+		header []string
+		code   int
+		etag   string
+		vary   string
+	}{
+		{"json", "/v1/toys", nil, 200, `"abc"`, "Accept, Accept-Encoding"},
+		{"gzip json", "/v1/toys", []string{"Accept-Encoding", "gzip"}, 200, `"abc-gzip"`, "Accept, Accept-Encoding"},
+		{"deflate json", "/v1/toys", []string{"Accept-Encoding", "deflate"}, 200, `"abc-deflate"`, "Accept, Accept-Encoding"},
+		{"csv", "/v1/toys", []string{"Accept", "text/csv"}, 200, `"abc-csv"`, "Accept, Accept-Encoding"},
+		{"gzip csv", "/v1/toys?format=csv", []string{"Accept-Encoding", "gzip"}, 200, `"abc-csv-gzip"`, "Accept, Accept-Encoding"},
+		{"json not modified", "/v1/toys", []string{"If-None-Match", `"abc"`}, 304, `"abc"`, "Accept, Accept-Encoding"},
+		{"gzip not modified", "/v1/toys", []string{"Accept-Encoding", "gzip", "If-None-Match", `"abc-gzip"`}, 304, `"abc-gzip"`, "Accept, Accept-Encoding"},
+		{"weak gzip not modified", "/v1/toys", []string{"Accept-Encoding", "gzip", "If-None-Match", `W/"abc-gzip"`}, 304, `"abc-gzip"`, "Accept, Accept-Encoding"},
+		{"csv not modified", "/v1/toys", []string{"Accept", "text/csv", "If-None-Match", `"abc-csv"`}, 304, `"abc-csv"`, "Accept, Accept-Encoding"},
+		{"json tag for csv", "/v1/toys", []string{"Accept", "text/csv", "If-None-Match", `"abc"`}, 200, `"abc-csv"`, "Accept, Accept-Encoding"},
+		{"gzip tag without gzip", "/v1/toys", []string{"If-None-Match", `"abc-gzip"`}, 200, `"abc"`, "Accept, Accept-Encoding"},
+		{"csv tag for json", "/v1/toys", []string{"If-None-Match", `"abc-csv"`}, 200, `"abc"`, "Accept, Accept-Encoding"},
+	}
+	for _, test := range tests {
+		response := serve(s, http.MethodGet, test.target, test.header...)
+		header := response.Header()
+		if response.Code != test.code || header.Get("ETag") != test.etag {
+			t.Errorf("%s: %d with ETag %s, want %d with %s", test.name, response.Code, header.Get("ETag"), test.code, test.etag)
+		}
+		if vary := strings.Join(header.Values("Vary"), ", "); vary != test.vary {
+			t.Errorf("%s: Vary is %q, want %q", test.name, vary, test.vary)
+		}
+	}
+}
+
+func TestIfMatchAcceptsCompressedTags(t *testing.T) {
+	s := newETagServer()
+	tests := []struct {
+		name   string
+		header []string
+		code   int
+	}{
+		{"json tag", []string{"If-Match", `"abc"`}, http.StatusNoContent},
+		{"gzip tag", []string{"Accept-Encoding", "gzip", "If-Match", `"abc-gzip"`}, http.StatusNoContent},
+		{"gzip tag in a list", []string{"Accept-Encoding", "gzip", "If-Match", `"old", "abc-gzip"`}, http.StatusNoContent},
+		{"gzip tag without gzip", []string{"If-Match", `"abc-gzip"`}, http.StatusPreconditionFailed},
+		{"weak tag", []string{"If-Match", `W/"abc"`}, http.StatusPreconditionFailed},
+		{"csv tag", []string{"If-Match", `"abc-csv"`}, http.StatusPreconditionFailed},
+	}
+	for _, test := range tests {
+		if response := serve(s, http.MethodPut, "/v1/toys", test.header...); response.Code != test.code {
+			t.Errorf("%s: code %d, want %d", test.name, response.Code, test.code)
+		}
+	}
+}
+
+func TestMatchETag(t *testing.T) {
+	tests := []struct {
+		header string
+		etag   string
+		strong bool
+		match  bool
+	}{
+		{`"a"`, `"a"`, true, true},
+		{`"b", "a"`, `"a"`, true, true},
+		{`*`, `"a"`, true, true},
+		{`W/"a"`, `"a"`, true, false},
+		{`"a"`, `W/"a"`, true, false},
+		{`W/"a"`, `"a"`, false, true},
+		{`"a"`, `W/"a"`, false, true},
+		{`"b"`, `"a"`, false, false},
+	}
+	for _, test := range tests {
+		if got := matchETag(test.header, test.etag, test.strong); got != test.match {
+			t.Errorf("matchETag(%s, %s, strong %v) = %v, want %v", test.header, test.etag, test.strong, got, test.match)
+		}
+	}
+}
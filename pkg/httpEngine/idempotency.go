@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amupxm/pure-webserver/constants"
+	"github.com/amupxm/pure-webserver/pkg/database"
+	"github.com/amupxm/pure-webserver/pkg/logger"
+)
+
+type (
+	// IdempotencyStore keeps the responses of requests sent with an Idempotency-Key header
+	IdempotencyStore interface {
+		// Get returns the record of key, nil when there is none
+		Get(ctx context.Context, key string) (*IdempotencyRecord, error)
+		// Save stores a record
+		Save(ctx context.Context, record *IdempotencyRecord) error
+	}
+	// IdempotencyRecord is the response of the first request sent with a key
+	IdempotencyRecord struct {
+		database.DbModel
+		Key string `json:"key"`
+		// Fingerprint identifies method, path and body of the request
+		Fingerprint string      `json:"fingerprint"`
+		Status      int         `json:"status"`
+		Header      http.Header `json:"header"`
+		Body        []byte      `json:"body"`
+	}
+	databaseIdempotencyStore struct {
+		db database.Database
+	}
+	// idempotencyLocks serializes requests which share a key
+	idempotencyLocks struct {
+		lock     sync.Mutex
+		inFlight map[string]chan struct{}
+	}
+)
+
+// IdempotencyKeyHeader is the request header holding the client generated key
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+var (
+	errIdempotencyMismatch = errors.New(constants.IdempotencyMismatch)
+	// idempotentMethods are the write methods whose responses are replayed
+	idempotentMethods = map[string]bool{
+		http.MethodPost:   true,
+		http.MethodPut:    true,
+		http.MethodPatch:  true,
+		http.MethodDelete: true,
+	}
+)
+
+// NewDatabaseIdempotencyStore creates a store keeping records in a database collection for ttl
+func NewDatabaseIdempotencyStore(db database.Database, ttl time.Duration) IdempotencyStore {
+	var record *IdempotencyRecord
+	db.SetTTL(record, ttl)
+	return &databaseIdempotencyStore{
+		db: db,
+	}
+}
+
+// Get returns the record of key, nil when there is none or it expired
+func (s *databaseIdempotencyStore) Get(ctx context.Context, key string) (*IdempotencyRecord, error) {
+	var record *IdempotencyRecord
+	records, err := s.db.GetFromCollection(ctx, record)
+	if err != nil || records == nil {
+		return nil, err
+	}
+	matched := *records.Where("key", key)
+	if len(matched) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(matched[0])
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &record)
+	return record, err
+}
+
+// Save stores a record
+func (s *databaseIdempotencyStore) Save(ctx context.Context, record *IdempotencyRecord) error {
+	return s.db.WriteToCollection(ctx, record)
+}
+
+// Idempotency replays the stored response of write requests retried with the same Idempotency-Key,
+// a retry with another path or body gets 422 and concurrent retries wait for the first one.
+// Keys are scoped by method, route pattern and client, so clients can not replay the responses of others.
+// Clients are identified by client, falling back to their ip
+func Idempotency(store IdempotencyStore, client KeyFunc) Middleware {
+	locks := &idempotencyLocks{inFlight: make(map[string]chan struct{})}
+	byIP := KeyByIP()
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *ServerContext) {
+			key := c.Request.Header.Get(IdempotencyKeyHeader)
+			if key == "" || c.Route == "" || !idempotentMethods[c.Request.Method] {
+				next(c)
+				return
+			}
+			scope := client(c)
+			if scope == "" {
+				scope = byIP(c)
+			}
+			key = c.Request.Method + " " + c.Route + "|" + scope + "|" + key
+			body, err := c.ReadBody()
+			if err != nil {
+				c.ErrorHandler(http.StatusBadRequest, err)
+				return
+			}
+			c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+			fingerprint := requestFingerprint(c.Request, body)
+
+			unlock := locks.acquire(key)
+			defer unlock()
+			record, err := store.Get(c.Context(), key)
+			if err != nil {
+				c.ErrorHandler(http.StatusInternalServerError, err)
+				return
+			}
+			if record != nil {
+				if record.Fingerprint != fingerprint {
+					c.ErrorHandler(http.StatusUnprocessableEntity, errIdempotencyMismatch)
+					return
+				}
+				replay(c.Response, record)
+				return
+			}
+
+			recorder := newResponseRecorder(c.Response)
+			c.Response = recorder
+			next(c)
+			// server errors are not stored so the client can retry them
+			if recorder.Status() >= http.StatusInternalServerError {
+				return
+			}
+			err = store.Save(c.Context(), &IdempotencyRecord{
+				Key:         key,
+				Fingerprint: fingerprint,
+				Status:      recorder.Status(),
+				Header:      recordedHeader(recorder.Header()),
+				Body:        recorder.body.Bytes(),
+			})
+			if err != nil {
+				logger.Default().Error("can not store idempotency key", logger.Fields{"key": key, "error": err, "request_id": c.RequestID})
+			}
+		}
+	}
+}
+
+// acquire blocks until no other request holds key and returns the function releasing it
+func (l *idempotencyLocks) acquire(key string) func() {
+	for {
+		l.lock.Lock()
+		wait, busy := l.inFlight[key]
+		if !busy {
+			done := make(chan struct{})
+			l.inFlight[key] = done
+			l.lock.Unlock()
+			return func() {
+				l.lock.Lock()
+				delete(l.inFlight, key)
+				l.lock.Unlock()
+				close(done)
+			}
+		}
+		l.lock.Unlock()
+		<-wait
+	}
+}
+
+// requestFingerprint hashes what makes two requests with the same key the same request
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// recordedHeader copies the response header without the headers of Compress, which runs around
+// Idempotency and sees the uncompressed body, so replays are compressed again as their request negotiates
+func recordedHeader(header http.Header) http.Header {
+	recorded := header.Clone()
+	if coding := recorded.Get("Content-Encoding"); coding != "" {
+		if etag, ok := uncodedETags(recorded.Get("ETag"), coding); ok {
+			recorded.Set("ETag", etag)
+		}
+	}
+	recorded.Del("Content-Encoding")
+	recorded.Del("Content-Length")
+	var vary []string
+	for _, value := range recorded.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" && !strings.EqualFold(name, "Accept-Encoding") {
+				vary = append(vary, name)
+			}
+		}
+	}
+	recorded.Del("Vary")
+	if len(vary) > 0 {
+		recorded["Vary"] = vary
+	}
+	return recorded
+}
+
+// replay writes a stored response
+func replay(w http.ResponseWriter, record *IdempotencyRecord) {
+	for name, values := range record.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(record.Status)
+	w.Write(record.Body)
+}
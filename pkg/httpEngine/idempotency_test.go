@@ -0,0 +1,290 @@
+package controller
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryIdempotencyStore keeps records in a map
+type memoryIdempotencyStore struct {
+	lock    sync.Mutex
+	records map[string]*IdempotencyRecord
+}
+
+func (s *memoryIdempotencyStore) Get(_ context.Context, key string) (*IdempotencyRecord, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.records[key], nil
+}
+
+func (s *memoryIdempotencyStore) Save(_ context.Context, record *IdempotencyRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.records[record.Key] = record
+	return nil
+}
+
+// sendWithKey serves a request to s with an Idempotency-Key, an empty key sends none
+func sendWithKey(s Server, method, target, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if key != "" {
+		r.Header.Set(IdempotencyKeyHeader, key)
+	}
+	recorder := httptest.NewRecorder()
+	s.mainEngineHandler(recorder, r)
+	return recorder
+}
+
+// readBody returns the body of a response, decompressing gzip bodies
+func readBody(t *testing.T, response *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body io.Reader = response.Body
+	if response.Header().Get("Content-Encoding") == "gzip" {
+		reader, err := gzip.NewReader(response.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = reader
+	}
+	b, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestIdempotencyReplaysCompressedResponses(t *testing.T) {
+	store := &memoryIdempotencyStore{records: make(map[string]*IdempotencyRecord)}
+	s := NewServer()
+	s.Use(Compress(CompressOptions{MinSize: 1024, ContentTypes: []string{"application/json"}}), Idempotency(store, KeyByAPIKey("X-API-Key")))
+	calls := 0
+	s.AddHandler("/v1/toys", http.MethodPost, func(c *ServerContext) {
+		calls++
+		c.Response.Header().Add("Vary", "Accept")
+		c.JSON(http.StatusCreated, map[string]string{"name": strings.Repeat("toy", 1000)})
+	})
+	post := func(acceptEncoding string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/toys", strings.NewReader(`{"name":"toy"}`))
+		r.Header.Set(IdempotencyKeyHeader, "key-1")
+		if acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	first := post("gzip")
+	if first.Code != http.StatusCreated || first.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("first response: %d with headers %v", first.Code, first.Header())
+	}
+	want := readBody(t, first)
+	if len(want) < 1024 {
+		t.Fatalf("body has %d bytes, too short to be compressed", len(want))
+	}
+	if len(store.records) != 1 {
+		t.Fatalf("%d records are stored, want 1", len(store.records))
+	}
+	var record *IdempotencyRecord
+	for _, stored := range store.records {
+		record = stored
+	}
+	if record.Header.Get("Content-Encoding") != "" || record.Header.Get("Content-Length") != "" ||
+		strings.Join(record.Header.Values("Vary"), ", ") != "Accept" {
+		t.Errorf("stored header %v describes the compressed body", record.Header)
+	}
+
+	tests := []struct {
+		acceptEncoding string
+		encoding       string
+	}{
+		{"gzip", "gzip"},
+		{"", ""},
+		{"identity", ""},
+	}
+	for _, test := range tests {
+		replayed := post(test.acceptEncoding)
+		header := replayed.Header()
+		if replayed.Code != http.StatusCreated || header.Get("Idempotent-Replayed") != "true" || header.Get("Content-Encoding") != test.encoding {
+			t.Errorf("replay accepting %q: %d with headers %v", test.acceptEncoding, replayed.Code, header)
+			continue
+		}
+		if vary := strings.Join(header.Values("Vary"), ", "); vary != "Accept, Accept-Encoding" {
+			t.Errorf("replay accepting %q: Vary is %q", test.acceptEncoding, vary)
+		}
+		if got := readBody(t, replayed); got != want {
+			t.Errorf("replay accepting %q: body has %d bytes, want %d", test.acceptEncoding, len(got), len(want))
+		}
+	}
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want 1", calls)
+	}
+}
+
+func TestIdempotencyReplaysResponses(t *testing.T) {
+	store := &memoryIdempotencyStore{records: make(map[string]*IdempotencyRecord)}
+	s := NewServer()
+	s.Use(Idempotency(store, KeyByAPIKey("X-API-Key")))
+	calls := 0
+	s.AddHandler("/v1/replays", http.MethodPost, func(c *ServerContext) {
+		calls++
+		c.Response.Header().Set("Location", "/v1/replays/1")
+		c.JSON(http.StatusCreated, map[string]int{"call": calls})
+	})
+
+	first := sendWithKey(s, http.MethodPost, "/v1/replays", "key-1", `{"name":"toy"}`)
+	if first.Code != http.StatusCreated || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("first response: %d with headers %v", first.Code, first.Header())
+	}
+	replayed := sendWithKey(s, http.MethodPost, "/v1/replays", "key-1", `{"name":"toy"}`)
+	header := replayed.Header()
+	if replayed.Code != http.StatusCreated || header.Get("Idempotent-Replayed") != "true" || header.Get("Location") != "/v1/replays/1" {
+		t.Errorf("replay: %d with headers %v", replayed.Code, header)
+	}
+	if replayed.Body.String() != first.Body.String() {
+		t.Errorf("replayed body %q, want %q", replayed.Body.String(), first.Body.String())
+	}
+	if other := sendWithKey(s, http.MethodPost, "/v1/replays", "key-2", `{"name":"toy"}`); other.Code != http.StatusCreated || other.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("another key: %d with headers %v", other.Code, other.Header())
+	}
+	sendWithKey(s, http.MethodPost, "/v1/replays", "", `{"name":"toy"}`)
+	if calls != 3 {
+		t.Errorf("handler ran %d times, want 3", calls)
+	}
+}
+
+func TestIdempotencyRejectsAnotherRequestWithTheKey(t *testing.T) {
+	store := &memoryIdempotencyStore{records: make(map[string]*IdempotencyRecord)}
+	s := NewServer()
+	s.Use(Idempotency(store, KeyByAPIKey("X-API-Key")))
+	s.AddHandler("/v1/mismatches/:id", http.MethodPost, func(c *ServerContext) {
+		c.JSON(http.StatusCreated, map[string]string{"name": "toy"})
+	})
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		code   int
+	}{
+		{"first", "/v1/mismatches/1", `{"name":"toy"}`, http.StatusCreated},
+		{"another body", "/v1/mismatches/1", `{"name":"car"}`, http.StatusUnprocessableEntity},
+		{"another path of the route", "/v1/mismatches/2", `{"name":"toy"}`, http.StatusUnprocessableEntity},
+	}
+	for _, test := range tests {
+		if recorder := sendWithKey(s, http.MethodPost, test.target, "key-1", test.body); recorder.Code != test.code {
+			t.Errorf("%s: code %d, want %d", test.name, recorder.Code, test.code)
+		}
+	}
+}
+
+func TestIdempotencyDoesNotStoreServerErrors(t *testing.T) {
+	store := &memoryIdempotencyStore{records: make(map[string]*IdempotencyRecord)}
+	s := NewServer()
+	s.Use(Idempotency(store, KeyByAPIKey("X-API-Key")))
+	calls := 0
+	s.AddHandler("/v1/failures", http.MethodPost, func(c *ServerContext) {
+		calls++
+		if calls == 1 {
+			c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "try again"})
+			return
+		}
+		c.JSON(http.StatusCreated, map[string]string{"name": "toy"})
+	})
+	for _, code := range []int{http.StatusServiceUnavailable, http.StatusCreated, http.StatusCreated} {
+		if recorder := sendWithKey(s, http.MethodPost, "/v1/failures", "key-1", `{}`); recorder.Code != code {
+			t.Errorf("code %d, want %d", recorder.Code, code)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want 2", calls)
+	}
+}
+
+func TestIdempotencyRunsConcurrentRetriesOnce(t *testing.T) {
+	store := &memoryIdempotencyStore{records: make(map[string]*IdempotencyRecord)}
+	s := NewServer()
+	s.Use(Idempotency(store, KeyByAPIKey("X-API-Key")))
+	var lock sync.Mutex
+	calls := 0
+	s.AddHandler("/v1/retries", http.MethodPost, func(c *ServerContext) {
+		lock.Lock()
+		calls++
+		lock.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		c.JSON(http.StatusCreated, map[string]string{"name": "toy"})
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if recorder := sendWithKey(s, http.MethodPost, "/v1/retries", "key-1", `{}`); recorder.Code != http.StatusCreated {
+				t.Errorf("code %d, want %d", recorder.Code, http.StatusCreated)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want 1", calls)
+	}
+}
+
+func TestIdempotencyScopesKeys(t *testing.T) {
+	store := &memoryIdempotencyStore{records: make(map[string]*IdempotencyRecord)}
+	s := NewServer()
+	s.Use(Idempotency(store, KeyByAPIKey("X-API-Key")))
+	calls := 0
+	for _, method := range []string{http.MethodPost, http.MethodPut} {
+		for _, route := range []string{"/v1/toys", "/v1/cars"} {
+			s.AddHandler(route, method, func(c *ServerContext) {
+				calls++
+				c.JSON(http.StatusCreated, map[string]int{"call": calls})
+			})
+		}
+	}
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		apiKey     string
+		remoteAddr string
+		replayed   bool
+	}{
+		{"first", http.MethodPost, "/v1/toys", "alice", "192.0.2.1:1234", false},
+		{"same client from another ip", http.MethodPost, "/v1/toys", "alice", "192.0.2.2:1234", true},
+		{"another client", http.MethodPost, "/v1/toys", "bob", "192.0.2.1:1234", false},
+		{"another method", http.MethodPut, "/v1/toys", "alice", "192.0.2.1:1234", false},
+		{"another route", http.MethodPost, "/v1/cars", "alice", "192.0.2.1:1234", false},
+		{"anonymous client", http.MethodPost, "/v1/toys", "", "192.0.2.1:1234", false},
+		{"anonymous client retrying", http.MethodPost, "/v1/toys", "", "192.0.2.1:5678", true},
+		{"anonymous client from another ip", http.MethodPost, "/v1/toys", "", "192.0.2.2:1234", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.target, strings.NewReader(`{}`))
+		r.RemoteAddr = test.remoteAddr
+		r.Header.Set(IdempotencyKeyHeader, "key-1")
+		if test.apiKey != "" {
+			r.Header.Set("X-API-Key", test.apiKey)
+		}
+		recorder := httptest.NewRecorder()
+		s.mainEngineHandler(recorder, r)
+		if replayed := recorder.Header().Get("Idempotent-Replayed") == "true"; recorder.Code != http.StatusCreated || replayed != test.replayed {
+			t.Errorf("%s: code %d, replayed %t, want %d and %t", test.name, recorder.Code, replayed, http.StatusCreated, test.replayed)
+		}
+	}
+	for key := range store.records {
+		if strings.Contains(key, "alice") || strings.Contains(key, "bob") {
+			t.Errorf("stored key %q holds an api key", key)
+		}
+	}
+	if calls != 6 {
+		t.Errorf("handler ran %d times, want 6", calls)
+	}
+}
@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/amupxm/pure-webserver/pkg/metrics"
+)
+
+// unmatchedRoute labels requests without a matching route so unknown paths do not create series
+const unmatchedRoute = "unmatched"
+
+// otherMethod labels requests with a non standard method so clients can not create series
+const otherMethod = "OTHER"
+
+// metricMethods are the methods labelled by their name
+var metricMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// Metrics records the count, latency and size of responses per method, route pattern and status,
+// and the number of requests in flight
+func Metrics(registry *metrics.Registry) Middleware {
+	requests := registry.Counter("http_requests_total",
+		"HTTP requests by method, route pattern and status code.", "method", "route", "status")
+	duration := registry.Histogram("http_request_duration_seconds",
+		"Latency of HTTP requests by method and route pattern.", nil, "method", "route")
+	responseSize := registry.Histogram("http_response_size_bytes",
+		"Size of HTTP response bodies by method and route pattern.",
+		[]float64{100, 1000, 10000, 100000, 1000000, 10000000}, "method", "route")
+	inFlight := registry.Gauge("http_requests_in_flight", "HTTP requests being served.").With()
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *ServerContext) {
+			start := time.Now()
+			inFlight.Inc()
+			defer inFlight.Dec()
+			writer := &statusWriter{ResponseWriter: c.Response}
+			c.Response = writer
+			next(c)
+			route := c.Route
+			if route == "" {
+				route = unmatchedRoute
+			}
+			method := c.Request.Method
+			if !metricMethods[method] {
+				method = otherMethod
+			}
+			requests.With(method, route, strconv.Itoa(writer.Status())).Inc()
+			duration.With(method, route).Observe(time.Since(start).Seconds())
+			responseSize.With(method, route).Observe(float64(writer.bytes))
+		}
+	}
+}
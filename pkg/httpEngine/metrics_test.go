@@ -0,0 +1,44 @@
+package controller
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/amupxm/pure-webserver/pkg/metrics"
+)
+
+func TestMetricsLabels(t *testing.T) {
+	registry := metrics.NewRegistry()
+	s := NewServer()
+	s.Use(Metrics(registry))
+	s.AddHandler("/v1/toys/:iid", http.MethodGet, func(c *ServerContext) {})
+	for _, request := range []struct{ method, target string }{
+		{http.MethodGet, "/v1/toys/1"},
+		{http.MethodGet, "/v1/toys/2"},
+		{http.MethodGet, "/v1/cars/1"},
+		{"FOO", "/v1/toys/1"},
+		{"BAR", "/v1/random/path"},
+	} {
+		serve(s, request.method, request.target)
+	}
+	var out bytes.Buffer
+	if err := registry.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`http_requests_total{method="GET",route="/v1/toys/:iid",status="200"} 2`,
+		`http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`http_requests_total{method="OTHER",route="unmatched",status="404"} 2`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics have no %s:\n%s", want, out.String())
+		}
+	}
+	for _, method := range []string{"FOO", "BAR"} {
+		if strings.Contains(out.String(), method) {
+			t.Errorf("method %s is a label value:\n%s", method, out.String())
+		}
+	}
+}
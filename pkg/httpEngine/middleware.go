@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"bytes"
+	"net/http"
+)
+
+type (
+	// HandlerFunc handles one request
+	HandlerFunc func(c *ServerContext)
+	// Middleware wraps a handler to run code before and after it
+	Middleware func(next HandlerFunc) HandlerFunc
+
+	// responseRecorder is a http.ResponseWriter which keeps the status and a copy of the body
+	responseRecorder struct {
+		http.ResponseWriter
+		status int
+		body   bytes.Buffer
+	}
+)
+
+// Use adds middlewares which wrap every handler, the first one runs first
+func (s *server) Use(middlewares ...Middleware) {
+	s.middlewares = append(s.middlewares, middlewares...)
+}
+
+// newResponseRecorder wraps w to record what handlers write
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w}
+}
+
+// WriteHeader records and sends the status code
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records and sends a part of the body
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// Status returns the recorded status code, 200 when the handler wrote nothing
+func (r *responseRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// Flush sends buffered data to the client when the wrapped writer supports it
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
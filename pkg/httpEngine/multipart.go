@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+)
+
+var errMissingBoundary = errors.New("multipart boundary is missing")
+
+// StreamMultipart calls handle for every part of a multipart/form-data body as it arrives, unlike BindForm
+// nothing is buffered in memory or temporary files. A part can only be read in its call of handle,
+// an error returned by handle stops reading and is returned
+func (s *ServerContext) StreamMultipart(handle func(part *multipart.Part) error) error {
+	if s.ContentType() != "multipart/form-data" {
+		return &BindError{Source: "form", Err: ErrUnsupportedMediaType}
+	}
+	_, params, _ := mime.ParseMediaType(s.Request.Header.Get("Content-Type"))
+	if params["boundary"] == "" {
+		return &BindError{Source: "form", Err: errMissingBoundary}
+	}
+	body, err := s.decodedBody()
+	if err != nil {
+		return &BindError{Source: "form", Err: ErrUnsupportedMediaType}
+	}
+	reader := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if errors.Is(err, ErrBodyTooLarge) {
+				err = ErrBodyTooLarge
+			}
+			return &BindError{Source: "form", Err: err}
+		}
+		err = handle(part)
+		part.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
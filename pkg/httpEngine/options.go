@@ -0,0 +1,51 @@
+package controller
+
+import "time"
+
+type (
+	// Option configures a server created by NewServer
+	Option func(s *server)
+
+	// Timeouts of the connections of a server, zero values disable a timeout
+	Timeouts struct {
+		// ReadHeader bounds reading the request headers
+		ReadHeader time.Duration
+		// Read bounds reading the whole request
+		Read time.Duration
+		// Write bounds the time from the end of the request headers to the end of the response
+		Write time.Duration
+		// Idle bounds waiting for the next request on a keep-alive connection
+		Idle time.Duration
+	}
+)
+
+// DefaultPort is the port of a server created without WithPort
+const DefaultPort = "8080"
+
+// WithPort sets the tcp port the server listens on
+func WithPort(port string) Option {
+	return func(s *server) {
+		s.Port = port
+	}
+}
+
+// WithRenderers sets the formats ServerContext.Render picks from, DefaultRenderers when not set
+func WithRenderers(renderers *Renderers) Option {
+	return func(s *server) {
+		s.renderers = renderers
+	}
+}
+
+// WithTimeouts sets the connection timeouts, which drop slow clients
+func WithTimeouts(timeouts Timeouts) Option {
+	return func(s *server) {
+		s.timeouts = timeouts
+	}
+}
+
+// WithMaxHeaderBytes limits the size of the request line and headers, larger requests get 431
+func WithMaxHeaderBytes(size int) Option {
+	return func(s *server) {
+		s.maxHeaderBytes = size
+	}
+}
@@ -0,0 +1,238 @@
+package controller
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startServer serves s on a local listener with the net/http server of StartServer
+func startServer(t *testing.T, s Server) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = s.(*server).httpServer()
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// dial opens a raw connection to ts which fails reads after a few seconds
+func dial(t *testing.T, ts *httptest.Server) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	return conn
+}
+
+// waitClosed reads conn until the server closes it and returns what it sent and how long that took
+func waitClosed(t *testing.T, conn net.Conn) (string, time.Duration) {
+	t.Helper()
+	start := time.Now()
+	sent, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("the server kept the connection open: %v", err)
+	}
+	return string(sent), time.Since(start)
+}
+
+func TestReadHeaderTimeoutDropsSlowClients(t *testing.T) {
+	s := NewServer(WithTimeouts(Timeouts{ReadHeader: 100 * time.Millisecond}))
+	s.AddHandler("/v1/toys", http.MethodGet, func(c *ServerContext) {})
+	conn := dial(t, startServer(t, s))
+	// the headers never end
+	io.WriteString(conn, "GET /v1/toys HTTP/1.1\r\nHost: toys\r\n")
+	sent, elapsed := waitClosed(t, conn)
+	if strings.Contains(sent, "200 OK") || elapsed > time.Second {
+		t.Errorf("after %s the server sent %q", elapsed, sent)
+	}
+}
+
+func TestReadTimeoutFailsSlowBodies(t *testing.T) {
+	readErr := make(chan error, 1)
+	s := NewServer(WithTimeouts(Timeouts{ReadHeader: time.Second, Read: 200 * time.Millisecond}))
+	s.AddHandler("/v1/toys", http.MethodPost, func(c *ServerContext) {
+		_, err := io.ReadAll(c.Request.Body)
+		readErr <- err
+	})
+	conn := dial(t, startServer(t, s))
+	// the body is announced with 10 bytes but only 2 are sent
+	io.WriteString(conn, "POST /v1/toys HTTP/1.1\r\nHost: toys\r\nContent-Length: 10\r\n\r\n{}")
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Error("reading the incomplete body succeeded")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the handler still waits for the body")
+	}
+	if _, elapsed := waitClosed(t, conn); elapsed > time.Second {
+		t.Errorf("the connection was closed after %s", elapsed)
+	}
+}
+
+func TestIdleTimeoutClosesKeepAliveConnections(t *testing.T) {
+	s := NewServer(WithTimeouts(Timeouts{Idle: 100 * time.Millisecond}))
+	s.AddHandler("/v1/toys", http.MethodGet, func(c *ServerContext) {
+		c.JSON(http.StatusOK, []string{})
+	})
+	conn := dial(t, startServer(t, s))
+	io.WriteString(conn, "GET /v1/toys HTTP/1.1\r\nHost: toys\r\n\r\n")
+	response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || response.Close {
+		t.Fatalf("first response: %d, close %v", response.StatusCode, response.Close)
+	}
+	if _, elapsed := waitClosed(t, conn); elapsed > time.Second {
+		t.Errorf("the idle connection was closed after %s", elapsed)
+	}
+}
+
+func TestMaxHeaderBytesRejectsLargeHeaders(t *testing.T) {
+	var called int32
+	s := NewServer(WithMaxHeaderBytes(1024))
+	s.AddHandler("/v1/toys", http.MethodGet, func(c *ServerContext) {
+		atomic.StoreInt32(&called, 1)
+	})
+	ts := startServer(t, s)
+	tests := []struct {
+		size int
+		code int
+	}{
+		{100, http.StatusOK},
+		// net/http allows 4KB on top of the limit
+		{10 << 10, http.StatusRequestHeaderFieldsTooLarge},
+	}
+	for _, test := range tests {
+		atomic.StoreInt32(&called, 0)
+		conn := dial(t, ts)
+		io.WriteString(conn, "GET /v1/toys HTTP/1.1\r\nHost: toys\r\nX-Large: "+strings.Repeat("a", test.size)+"\r\nConnection: close\r\n\r\n")
+		response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if handled := atomic.LoadInt32(&called) == 1; response.StatusCode != test.code || handled != (test.code == http.StatusOK) {
+			t.Errorf("header of %d bytes: code %d, handler called %v", test.size, response.StatusCode, handled)
+		}
+	}
+}
+
+func TestBodyLimitRejectsLargeBodies(t *testing.T) {
+	s := NewServer()
+	s.Use(BodyLimit(func(method, route string) int64 {
+		if method == http.MethodPost && route == "/v1/toys/_bulk" {
+			return 1024
+		}
+		return 16
+	}))
+	for _, route := range []string{"/v1/toys", "/v1/toys/_bulk"} {
+		s.AddHandler(route, http.MethodPost, func(c *ServerContext) {
+			if _, err := c.ReadBody(); err != nil {
+				c.ErrorHandler(http.StatusBadRequest, err)
+				return
+			}
+			c.Response.WriteHeader(http.StatusNoContent)
+		})
+	}
+	ts := startServer(t, s)
+	tests := []struct {
+		name    string
+		route   string
+		size    int
+		chunked bool
+		code    int
+	}{
+		{"small", "/v1/toys", 16, false, http.StatusNoContent},
+		{"declared too large", "/v1/toys", 17, false, http.StatusRequestEntityTooLarge},
+		{"chunked too large", "/v1/toys", 17, true, http.StatusRequestEntityTooLarge},
+		{"route limit", "/v1/toys/_bulk", 1000, false, http.StatusNoContent},
+		{"over route limit", "/v1/toys/_bulk", 2000, false, http.StatusRequestEntityTooLarge},
+		{"chunked over route limit", "/v1/toys/_bulk", 2000, true, http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		body := io.Reader(strings.NewReader(strings.Repeat("a", test.size)))
+		if test.chunked {
+			// a reader of unknown length is sent chunked
+			body = io.MultiReader(body)
+		}
+		request, err := http.NewRequest(http.MethodPost, ts.URL+test.route, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := ts.Client().Do(request)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		response.Body.Close()
+		if response.StatusCode != test.code {
+			t.Errorf("%s: code %d, want %d", test.name, response.StatusCode, test.code)
+		}
+		if test.code == http.StatusRequestEntityTooLarge && !test.chunked && !response.Close {
+			t.Errorf("%s: the connection stays open for the unread body", test.name)
+		}
+	}
+}
+
+func TestServersAreIndependent(t *testing.T) {
+	// pkg/httpEngine can not read config.AppConf, the config package imports it,
+	// so servers only use their options
+	first := NewServer(WithPort("9001"), WithTimeouts(Timeouts{Read: time.Second}), WithMaxHeaderBytes(1024))
+	second := NewServer()
+	first.Use(func(next HandlerFunc) HandlerFunc {
+		return func(c *ServerContext) {
+			c.Response.Header().Set("X-Server", "first")
+			next(c)
+		}
+	})
+	first.AddHandler("/v1/toys", http.MethodGet, func(c *ServerContext) {
+		c.JSON(http.StatusOK, "first")
+	})
+	second.AddHandler("/v1/toys", http.MethodGet, func(c *ServerContext) {
+		c.JSON(http.StatusOK, "second")
+	})
+	first.AddHandler("/v1/cars", http.MethodGet, func(c *ServerContext) {})
+
+	firstConfig, secondConfig := first.(*server).httpServer(), second.(*server).httpServer()
+	if firstConfig.Addr != ":9001" || firstConfig.ReadTimeout != time.Second || firstConfig.MaxHeaderBytes != 1024 {
+		t.Errorf("first server listens on %q with read timeout %s and %d header bytes", firstConfig.Addr, firstConfig.ReadTimeout, firstConfig.MaxHeaderBytes)
+	}
+	if secondConfig.Addr != ":"+DefaultPort || secondConfig.ReadTimeout != 0 || secondConfig.MaxHeaderBytes != 0 {
+		t.Errorf("second server listens on %q with read timeout %s and %d header bytes", secondConfig.Addr, secondConfig.ReadTimeout, secondConfig.MaxHeaderBytes)
+	}
+
+	tests := []struct {
+		name   string
+		s      Server
+		target string
+		code   int
+		body   string
+		header string
+	}{
+		{"first", first, "/v1/toys", http.StatusOK, `"first"`, "first"},
+		{"second", second, "/v1/toys", http.StatusOK, `"second"`, ""},
+		{"route of the first on the second", second, "/v1/cars", http.StatusNotFound, "", ""},
+	}
+	for _, test := range tests {
+		recorder := serve(test.s, http.MethodGet, test.target)
+		if recorder.Code != test.code || recorder.Header().Get("X-Server") != test.header {
+			t.Errorf("%s: code %d with X-Server %q, want %d and %q", test.name, recorder.Code, recorder.Header().Get("X-Server"), test.code, test.header)
+		}
+		if test.body != "" && strings.TrimSpace(recorder.Body.String()) != test.body {
+			t.Errorf("%s: body %q, want %s", test.name, recorder.Body.String(), test.body)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amupxm/pure-webserver/constants"
+	"github.com/amupxm/pure-webserver/pkg/logger"
+	"github.com/amupxm/pure-webserver/pkg/ratelimit"
+)
+
+// KeyFunc returns the client a request is counted for, empty when the request does not identify one
+type KeyFunc func(c *ServerContext) string
+
+var errRateLimited = errors.New(constants.RateLimited)
+
+// KeyByIP counts requests per client ip
+func KeyByIP() KeyFunc {
+	return func(c *ServerContext) string {
+		return "ip:" + clientIP(c.Request)
+	}
+}
+
+// KeyByAPIKey counts requests per api key sent in header, keys are hashed so stores never hold them
+func KeyByAPIKey(header string) KeyFunc {
+	return func(c *ServerContext) string {
+		key := c.Request.Header.Get(header)
+		if key == "" {
+			return ""
+		}
+		sum := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(sum[:16])
+	}
+}
+
+// KeyByJWTSubject counts requests per subject of the bearer token. The signature is not verified,
+// so it should run after a middleware rejecting invalid tokens
+func KeyByJWTSubject() KeyFunc {
+	return func(c *ServerContext) string {
+		token := strings.TrimSpace(strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer "))
+		parts := strings.Split(token, ".")
+		if len(parts) != 3 {
+			return ""
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			return ""
+		}
+		var claims struct {
+			Subject string `json:"sub"`
+		}
+		if json.Unmarshal(payload, &claims) != nil || claims.Subject == "" {
+			return ""
+		}
+		return "sub:" + claims.Subject
+	}
+}
+
+// KeyByFirst identifies clients by the first of keys which returns a key
+func KeyByFirst(keys ...KeyFunc) KeyFunc {
+	return func(c *ServerContext) string {
+		for _, key := range keys {
+			if client := key(c); client != "" {
+				return client
+			}
+		}
+		return ""
+	}
+}
+
+// RateLimit counts requests per route pattern and client against the limit limitFor returns,
+// routes without a limit are not counted. Clients are identified by key, falling back to their ip.
+// Every counted response gets RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy,
+// requests over the limit get 429 with Retry-After. Failures of the store let requests through
+func RateLimit(limiter *ratelimit.Limiter, limitFor func(method, route string) (ratelimit.Limit, bool), key KeyFunc) Middleware {
+	byIP := KeyByIP()
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *ServerContext) {
+			limit, ok := limitFor(c.Request.Method, c.Route)
+			if !ok || c.Route == "" {
+				next(c)
+				return
+			}
+			client := key(c)
+			if client == "" {
+				client = byIP(c)
+			}
+			result, err := limiter.Allow(c.Context(), c.Request.Method+" "+c.Route+"|"+client, limit)
+			if err != nil {
+				logger.Default().Warn("can not count request for rate limit", logger.Fields{"error": err, "request_id": c.RequestID})
+				next(c)
+				return
+			}
+			header := c.Response.Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+			header.Set("RateLimit-Policy", strconv.Itoa(limit.Requests)+";w="+strconv.Itoa(ceilSeconds(limit.Window)))
+			if !result.Allowed {
+				retryAfter := ceilSeconds(result.RetryAfter)
+				if retryAfter < 1 {
+					retryAfter = 1
+				}
+				header.Set("Retry-After", strconv.Itoa(retryAfter))
+				c.ErrorHandler(http.StatusTooManyRequests, errRateLimited)
+				return
+			}
+			next(c)
+		}
+	}
+}
+
+// ceilSeconds rounds a duration up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
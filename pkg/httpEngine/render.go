@@ -0,0 +1,207 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/amupxm/pure-webserver/constants"
+	"github.com/amupxm/pure-webserver/pkg/logger"
+)
+
+type (
+	// RenderFunc writes v in one format, it should fail for values the format can not represent
+	RenderFunc func(w io.Writer, v interface{}) error
+
+	// Renderers are the formats ServerContext.Render picks from, the first registered one is the default
+	Renderers struct {
+		lock      sync.RWMutex
+		renderers []renderer
+	}
+
+	// renderer is a registered format
+	renderer struct {
+		format    string
+		mediaType string
+		render    RenderFunc
+	}
+
+	// mediaRange is one entry of an Accept header
+	mediaRange struct {
+		mediaType string
+		quality   float64
+	}
+)
+
+// FormatQueryParam overrides the Accept header of a request, like "?format=csv"
+const FormatQueryParam = "format"
+
+var errNotAcceptable = errors.New(constants.NotAcceptable)
+
+// NewRenderers creates an empty registry
+func NewRenderers() *Renderers {
+	return &Renderers{}
+}
+
+// DefaultRenderers creates a registry of json (the default), pretty json, xml, csv and ndjson
+func DefaultRenderers() *Renderers {
+	r := NewRenderers()
+	r.Register("json", "application/json", RenderJSON)
+	r.Register("pretty", "application/json", RenderPrettyJSON)
+	r.Register("xml", "application/xml", RenderXML)
+	r.Register("csv", "text/csv", RenderCSV)
+	r.Register("ndjson", "application/x-ndjson", RenderNDJSON)
+	return r
+}
+
+// Register adds a format selected by ?format=format or an Accept header matching mediaType,
+// it replaces a format registered with the same name. Formats sharing a media type are picked
+// by Accept in the order they were registered
+func (r *Renderers) Register(format, mediaType string, render RenderFunc) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i, registered := range r.renderers {
+		if registered.format == format {
+			r.renderers[i] = renderer{format: format, mediaType: mediaType, render: render}
+			return
+		}
+	}
+	r.renderers = append(r.renderers, renderer{format: format, mediaType: mediaType, render: render})
+}
+
+// negotiate picks the renderer named format, or the best match of an Accept header
+func (r *Renderers) negotiate(format, accept string) (renderer, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if format != "" {
+		for _, registered := range r.renderers {
+			if registered.format == format {
+				return registered, true
+			}
+		}
+		return renderer{}, false
+	}
+	if len(r.renderers) == 0 {
+		return renderer{}, false
+	}
+	if strings.TrimSpace(accept) == "" {
+		return r.renderers[0], true
+	}
+	for _, accepted := range parseAccept(accept) {
+		for _, registered := range r.renderers {
+			if matchMediaRange(accepted.mediaType, registered.mediaType) {
+				return registered, true
+			}
+		}
+	}
+	return renderer{}, false
+}
+
+// parseAccept returns the media ranges of an Accept header by descending quality, ranges with q=0 are dropped
+func parseAccept(header string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality > 0 {
+			ranges = append(ranges, mediaRange{mediaType: mediaType, quality: quality})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+	return ranges
+}
+
+// matchMediaRange reports whether a media range like "text/*" matches mediaType
+func matchMediaRange(accepted, mediaType string) bool {
+	if accepted == "*/*" || accepted == mediaType {
+		return true
+	}
+	return strings.HasSuffix(accepted, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(accepted, "*"))
+}
+
+// Render writes response in the format of the format query param or the Accept header,
+// 406 when no registered format matches or the chosen one can not represent response
+func (s *ServerContext) Render(code int, response interface{}) {
+	addVary(s.Response.Header(), "Accept")
+	chosen, ok := s.negotiate()
+	if !ok {
+		s.ErrorHandler(http.StatusNotAcceptable, errNotAcceptable)
+		return
+	}
+	var body bytes.Buffer
+	if err := chosen.render(&body, response); err != nil {
+		logger.Default().Warn("can not render response", logger.Fields{"error": err, "format": chosen.format, "request_id": s.RequestID})
+		s.ErrorHandler(http.StatusNotAcceptable, errNotAcceptable)
+		return
+	}
+	contentType := chosen.mediaType
+	if strings.HasPrefix(contentType, "text/") {
+		contentType += "; charset=utf-8"
+	}
+	s.Response.Header().Set("Content-Type", contentType)
+	s.Response.WriteHeader(code)
+	s.Response.Write(body.Bytes())
+}
+
+// RepresentationETag returns etag for the format Render picks for the request, like "abc-xml" for xml,
+// the default format keeps etag so it still matches If-Match of writes. It adds Vary: Accept like Render,
+// so call it before NotModified to give 304 responses the same Vary
+func (s *ServerContext) RepresentationETag(etag string) string {
+	addVary(s.Response.Header(), "Accept")
+	chosen, ok := s.negotiate()
+	if !ok || !strings.HasSuffix(etag, `"`) || chosen.format == s.renderersOrDefault().defaultFormat() {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + chosen.format + `"`
+}
+
+// negotiate picks the renderer of the request by the format query param or the Accept header
+func (s *ServerContext) negotiate() (renderer, bool) {
+	return s.renderersOrDefault().negotiate(s.Request.URL.Query().Get(FormatQueryParam), s.Request.Header.Get("Accept"))
+}
+
+// renderersOrDefault returns the renderers of the server, DefaultRenderers for contexts created without one
+func (s *ServerContext) renderersOrDefault() *Renderers {
+	if s.renderers == nil {
+		return DefaultRenderers()
+	}
+	return s.renderers
+}
+
+// defaultFormat returns the name of the first registered format
+func (r *Renderers) defaultFormat() string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if len(r.renderers) == 0 {
+		return ""
+	}
+	return r.renderers[0].format
+}
+
+// addVary adds name to the Vary header unless it is listed already
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, listed := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(listed), name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
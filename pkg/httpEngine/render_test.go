@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/amupxm/pure-webserver/domain"
+	"github.com/amupxm/pure-webserver/pkg/database"
+)
+
+// renderProducts are rendered by the server of newRenderServer
+var renderProducts = []domain.Product{
+	{DbModel: database.DbModel{Id: "1", Version: 2}, Name: "car", Brand: "toyz", Company: "acme, inc", Iid: "toy", Id: "1", Images: []string{"a.png"}},
+	{DbModel: database.DbModel{Id: "2", Version: 1}, Name: `"boat"`, Brand: "toyz", Company: "acme", Iid: "toy", Id: "2", Images: []string{}},
+}
+
+// newRenderServer returns a server rendering renderProducts on /v1/toys and a map on /v1/stats
+func newRenderServer() Server {
+	s := NewServer()
+	s.AddHandler("/v1/toys", http.MethodGet, func(c *ServerContext) {
+		c.Render(http.StatusOK, renderProducts)
+	})
+	s.AddHandler("/v1/stats", http.MethodGet, func(c *ServerContext) {
+		c.Render(http.StatusOK, map[string]int{"toys": 2})
+	})
+	return s
+}
+
+func TestRenderFormats(t *testing.T) {
+	s := newRenderServer()
+	tests := []struct {
+		name        string
+		target      string
+		accept      string
+		code        int
+		contentType string
+	}{
+		{"default", "/v1/toys", "", 200, "application/json"},
+		{"any", "/v1/toys", "*/*", 200, "application/json"},
+		{"xml", "/v1/toys", "application/xml", 200, "application/xml"},
+		{"csv", "/v1/toys", "text/csv", 200, "text/csv; charset=utf-8"},
+		{"text range", "/v1/toys", "text/*", 200, "text/csv; charset=utf-8"},
+		{"ndjson", "/v1/toys", "application/x-ndjson", 200, "application/x-ndjson"},
+		{"format overrides accept", "/v1/toys?format=csv", "application/json", 200, "text/csv; charset=utf-8"},
+		{"pretty", "/v1/toys?format=pretty", "", 200, "application/json"},
+		{"higher quality first", "/v1/toys", "application/json;q=0.5, application/xml;q=0.9, text/csv;q=0.1", 200, "application/xml"},
+		{"order breaks ties", "/v1/toys", "text/csv, application/xml", 200, "text/csv; charset=utf-8"},
+		{"zero quality is refused", "/v1/toys", "application/xml;q=0, */*;q=0.1", 200, "application/json"},
+		{"unknown media type", "/v1/toys", "image/png", 406, "application/json"},
+		{"only refused types", "/v1/toys", "application/json;q=0", 406, "application/json"},
+		{"unknown format", "/v1/toys?format=yaml", "", 406, "application/json"},
+		{"csv of a map", "/v1/stats?format=csv", "", 406, "application/json"},
+		{"json of a map", "/v1/stats", "", 200, "application/json"},
+	}
+	for _, test := range tests {
+		response := serve(s, http.MethodGet, test.target, "Accept", test.accept)
+		if response.Code != test.code || response.Header().Get("Content-Type") != test.contentType {
+			t.Errorf("%s: %d with Content-Type %q, want %d with %q", test.name, response.Code, response.Header().Get("Content-Type"), test.code, test.contentType)
+		}
+		if vary := response.Header().Get("Vary"); vary != "Accept" {
+			t.Errorf("%s: Vary is %q, want Accept", test.name, vary)
+		}
+	}
+}
+
+func TestRenderCSV(t *testing.T) {
+	response := serve(newRenderServer(), http.MethodGet, "/v1/toys?format=csv")
+	// the id of the product hides the id of its embedded model
+	want := "created_at,updated_at,deleted_at,deleted,version,name,brand,company,iid,id,images\n" +
+		`0001-01-01T00:00:00Z,0001-01-01T00:00:00Z,,false,2,car,toyz,"acme, inc",toy,1,"[""a.png""]"` + "\n" +
+		`0001-01-01T00:00:00Z,0001-01-01T00:00:00Z,,false,1,"""boat""",toyz,acme,toy,2,[]` + "\n"
+	if body := response.Body.String(); body != want {
+		t.Errorf("csv is\n%s\nwant\n%s", body, want)
+	}
+}
+
+func TestRenderXML(t *testing.T) {
+	response := serve(newRenderServer(), http.MethodGet, "/v1/toys?format=xml")
+	body := response.Body.String()
+	if !strings.HasPrefix(body, xml.Header) {
+		t.Errorf("xml does not start with the xml header: %s", body)
+	}
+	var decoded struct {
+		XMLName xml.Name `xml:"response"`
+		Items   []struct {
+			Id        string   `xml:"id"`
+			Version   int64    `xml:"version"`
+			Name      string   `xml:"name"`
+			Company   string   `xml:"company"`
+			DeletedAt *string  `xml:"deleted_at"`
+			Images    []string `xml:"images>item"`
+		} `xml:"item"`
+	}
+	if err := xml.Unmarshal([]byte(body), &decoded); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	if len(decoded.Items) != 2 {
+		t.Fatalf("xml has %d items, want 2: %s", len(decoded.Items), body)
+	}
+	first, second := decoded.Items[0], decoded.Items[1]
+	if first.Id != "1" || first.Version != 2 || first.Name != "car" || first.Company != "acme, inc" || len(first.Images) != 1 || first.Images[0] != "a.png" {
+		t.Errorf("first item is %+v", first)
+	}
+	// null is an empty element
+	if first.DeletedAt == nil || *first.DeletedAt != "" {
+		t.Errorf("deleted_at of the first item is %v, want an empty element", first.DeletedAt)
+	}
+	if second.Id != "2" || second.Name != `"boat"` || len(second.Images) != 0 {
+		t.Errorf("second item is %+v", second)
+	}
+}
+
+func TestRenderNDJSON(t *testing.T) {
+	response := serve(newRenderServer(), http.MethodGet, "/v1/toys", "Accept", "application/x-ndjson")
+	lines := strings.Split(strings.TrimSuffix(response.Body.String(), "\n"), "\n")
+	if len(lines) != len(renderProducts) {
+		t.Fatalf("%d lines, want %d: %s", len(lines), len(renderProducts), response.Body)
+	}
+	for i, line := range lines {
+		var product domain.Product
+		if err := json.Unmarshal([]byte(line), &product); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		if product.Id != renderProducts[i].Id || product.Name != renderProducts[i].Name {
+			t.Errorf("line %d is %s", i, line)
+		}
+	}
+	// other values are a single line
+	response = serve(newRenderServer(), http.MethodGet, "/v1/stats?format=ndjson")
+	if body := response.Body.String(); body != "{\"toys\":2}\n" {
+		t.Errorf("ndjson of a map is %q", body)
+	}
+}
+
+func TestRenderersRegister(t *testing.T) {
+	r := NewRenderers()
+	if _, ok := r.negotiate("", ""); ok {
+		t.Error("an empty registry picked a format")
+	}
+	r.Register("json", "application/json", RenderJSON)
+	r.Register("compact", "application/json", RenderJSON)
+	r.Register("csv", "text/csv", RenderCSV)
+	r.Register("json", "application/json", RenderPrettyJSON)
+	tests := []struct {
+		format string
+		accept string
+		want   string
+		ok     bool
+	}{
+		{"", "", "json", true},
+		{"", "application/json", "json", true},
+		{"compact", "text/csv", "compact", true},
+		{"", "text/csv;q=0.2, application/*;q=0.3", "json", true},
+		{"", "text/csv;q=x, application/json;q=0.1", "json", true},
+		{"", "application/xml", "", false},
+		{"xml", "", "", false},
+	}
+	for _, test := range tests {
+		chosen, ok := r.negotiate(test.format, test.accept)
+		if ok != test.ok || chosen.format != test.want {
+			t.Errorf("format %q accept %q: %q %v, want %q %v", test.format, test.accept, chosen.format, ok, test.want, test.ok)
+		}
+	}
+	// registering a name again replaces the format in place
+	if chosen, _ := r.negotiate("json", ""); len(r.renderers) != 3 || chosen.render == nil || r.defaultFormat() != "json" {
+		t.Errorf("formats after replacing json: %+v", r.renderers)
+	}
+}
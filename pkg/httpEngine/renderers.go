@@ -0,0 +1,304 @@
+package controller
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// csvColumn is a field of the rows of a csv response
+type csvColumn struct {
+	name  string
+	index []int
+}
+
+const (
+	// xmlRootElement wraps xml responses
+	xmlRootElement = "response"
+	// xmlArrayItemElement holds one element of an array
+	xmlArrayItemElement = "item"
+)
+
+var (
+	errNotTabular     = errors.New("csv needs a struct or a slice of structs")
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// RenderJSON writes v as compact json
+func RenderJSON(w io.Writer, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+// RenderPrettyJSON writes v as indented json
+func RenderPrettyJSON(w io.Writer, v interface{}) error {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(body, '\n'))
+	return err
+}
+
+// RenderNDJSON writes every element of a slice as a json line, other values as a single line
+func RenderNDJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	value := indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return encoder.Encode(v)
+	}
+	for i := 0; i < value.Len(); i++ {
+		if err := encoder.Encode(value.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenderXML writes v as xml shaped like its json encoding, so json tags name the elements.
+// The root element is <response> and elements of arrays are <item>
+func RenderXML(w io.Writer, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	if err := writeXMLValue(encoder, decoder, xmlRootElement); err != nil {
+		return err
+	}
+	return encoder.Flush()
+}
+
+// writeXMLValue converts the next json value of decoder to an element called name
+func writeXMLValue(encoder *xml.Encoder, decoder *json.Decoder, name string) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	start := xml.StartElement{Name: xml.Name{Local: xmlName(name)}}
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+	switch t := token.(type) {
+	case json.Delim:
+		for decoder.More() {
+			child := xmlArrayItemElement
+			if t == '{' {
+				key, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+				child = key.(string)
+			}
+			if err := writeXMLValue(encoder, decoder, child); err != nil {
+				return err
+			}
+		}
+		// the closing delimiter
+		if _, err := decoder.Token(); err != nil {
+			return err
+		}
+	case nil:
+		// null is an empty element
+	default:
+		if err := encoder.EncodeToken(xml.CharData(fmt.Sprint(t))); err != nil {
+			return err
+		}
+	}
+	return encoder.EncodeToken(start.End())
+}
+
+// xmlName replaces the characters of name which are not allowed in xml element names
+func xmlName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case unicode.IsLetter(r) || r == '_':
+		case i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.'):
+		case i == 0 && unicode.IsDigit(r):
+			b.WriteRune('_')
+		default:
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// RenderCSV writes a slice of structs (or one struct) as csv with a header row,
+// columns are named and ordered like the json encoding of the structs
+func RenderCSV(w io.Writer, v interface{}) error {
+	value := indirect(reflect.ValueOf(v))
+	if !value.IsValid() {
+		return errNotTabular
+	}
+	rows := []reflect.Value{value}
+	rowType := value.Type()
+	if value.Kind() == reflect.Slice || value.Kind() == reflect.Array {
+		rows = rows[:0]
+		for i := 0; i < value.Len(); i++ {
+			rows = append(rows, value.Index(i))
+		}
+		rowType = value.Type().Elem()
+	}
+	for rowType.Kind() == reflect.Ptr {
+		rowType = rowType.Elem()
+	}
+	if rowType.Kind() != reflect.Struct {
+		return errNotTabular
+	}
+	columns := csvColumns(rowType)
+	writer := csv.NewWriter(w)
+	record := make([]string, len(columns))
+	for i, column := range columns {
+		record[i] = column.name
+	}
+	if err := writer.Write(record); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		for i, column := range columns {
+			value, err := csvValue(fieldByIndex(row, column.index))
+			if err != nil {
+				return err
+			}
+			record[i] = value
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// indirect follows pointers and interfaces to the value they hold, an invalid value for nil
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// csvColumns returns the exported fields of t with embedded structs flattened,
+// a field hides fields of the same name embedded deeper like in encoding/json
+func csvColumns(t reflect.Type) []csvColumn {
+	var columns []csvColumn
+	var walk func(t reflect.Type, index []int)
+	walk = func(t reflect.Type, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			fieldIndex := append(append([]int{}, index...), i)
+			fieldType := field.Type
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+				walk(fieldType, fieldIndex)
+				continue
+			}
+			if field.PkgPath != "" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			columns = append(columns, csvColumn{name: name, index: fieldIndex})
+		}
+	}
+	walk(t, nil)
+
+	// keep the shallowest field of every name
+	shallowest := make(map[string]int, len(columns))
+	for _, column := range columns {
+		if depth, ok := shallowest[column.name]; !ok || len(column.index) < depth {
+			shallowest[column.name] = len(column.index)
+		}
+	}
+	visible := columns[:0]
+	for _, column := range columns {
+		if len(column.index) == shallowest[column.name] {
+			visible = append(visible, column)
+			shallowest[column.name] = -1
+		}
+	}
+	sort.SliceStable(visible, func(i, j int) bool {
+		a, b := visible[i].index, visible[j].index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	return visible
+}
+
+// fieldByIndex returns the nested field of v by index, an invalid value when a pointer on the way is nil
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		if v = indirect(v); !v.IsValid() {
+			return v
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
+// csvValue formats a field, nested slices, maps and structs are written as json
+func csvValue(v reflect.Value) (string, error) {
+	v = indirect(v)
+	if !v.IsValid() {
+		return "", nil
+	}
+	if v.Type().Implements(textMarshalerType) && v.CanInterface() {
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), err
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+	}
+	if !v.CanInterface() {
+		return "", nil
+	}
+	body, err := json.Marshal(v.Interface())
+	return string(body), err
+}
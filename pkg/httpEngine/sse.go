@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amupxm/pure-webserver/constants"
+)
+
+type (
+	// Event is one server-sent event
+	Event struct {
+		// ID is remembered by the client and sent back as Last-Event-ID when it reconnects
+		ID string
+		// Event is the type of the event, clients treat events without one as "message"
+		Event string
+		// Data is the payload, every line is sent as its own data field
+		Data string
+		// Retry tells the client how long to wait before reconnecting, zero keeps its current delay
+		Retry time.Duration
+	}
+
+	// EventStream writes server-sent events to one client, it is not safe for concurrent use
+	EventStream struct {
+		c          *ServerContext
+		controller *http.ResponseController
+	}
+)
+
+// LastEventIDHeader is the request header holding the id of the last event a reconnecting client received
+const LastEventIDHeader = "Last-Event-ID"
+
+var errStreamingUnsupported = errors.New(constants.StreamingUnsupported)
+
+// eventFieldReplacer keeps line breaks out of single line event fields
+var eventFieldReplacer = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// EventStream starts a text/event-stream response. The write deadline of the connection is removed,
+// so the route should have no timeout either. retry is sent as the reconnection delay when non zero
+func (s *ServerContext) EventStream(retry time.Duration) (*EventStream, error) {
+	if !canFlush(s.Response) {
+		return nil, errStreamingUnsupported
+	}
+	controller := http.NewResponseController(s.Response)
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return nil, err
+	}
+	header := s.Response.Header()
+	header.Set("Content-Type", "text/event-stream; charset=utf-8")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// proxies like nginx would buffer the stream otherwise
+	header.Set("X-Accel-Buffering", "no")
+	s.Response.WriteHeader(http.StatusOK)
+	stream := &EventStream{c: s, controller: controller}
+	if retry > 0 {
+		if err := stream.write("retry: " + strconv.FormatInt(retry.Milliseconds(), 10) + "\n\n"); err != nil {
+			return nil, err
+		}
+	} else if err := stream.controller.Flush(); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// LastEventID returns the id of the last event the client received before reconnecting, empty on a first connection
+func (s *ServerContext) LastEventID() string {
+	return strings.TrimSpace(s.Request.Header.Get(LastEventIDHeader))
+}
+
+// Send writes event and flushes it to the client
+func (stream *EventStream) Send(event Event) error {
+	var b strings.Builder
+	if event.ID != "" {
+		b.WriteString("id: " + eventFieldReplacer.Replace(event.ID) + "\n")
+	}
+	if event.Event != "" {
+		b.WriteString("event: " + eventFieldReplacer.Replace(event.Event) + "\n")
+	}
+	if event.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+	data := strings.ReplaceAll(strings.ReplaceAll(event.Data, "\r\n", "\n"), "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return stream.write(b.String())
+}
+
+// Comment writes a comment line, clients ignore it but it keeps proxies from closing an idle stream
+func (stream *EventStream) Comment(text string) error {
+	return stream.write(": " + eventFieldReplacer.Replace(text) + "\n\n")
+}
+
+// Run sends the events of events and a heartbeat comment every heartbeat until events is closed,
+// a write fails or the request is done. A zero heartbeat disables heartbeats
+func (stream *EventStream) Run(events <-chan Event, heartbeat time.Duration) error {
+	var tick <-chan time.Time
+	if heartbeat > 0 {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	done := stream.c.Context().Done()
+	for {
+		select {
+		case <-done:
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		case <-tick:
+			if err := stream.Comment("heartbeat"); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// write sends s to the client right away
+func (stream *EventStream) write(s string) error {
+	if _, err := stream.c.Response.Write([]byte(s)); err != nil {
+		return err
+	}
+	return stream.controller.Flush()
+}
+
+// canFlush reports whether w or one of the writers it wraps can flush
+func canFlush(w http.ResponseWriter) bool {
+	for {
+		if _, ok := w.(http.Flusher); ok {
+			return true
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return false
+		}
+		w = unwrapper.Unwrap()
+	}
+}
@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eventContext returns the context of a GET request recorded by a flushing recorder
+func eventContext(ctx context.Context, header ...string) (*ServerContext, *httptest.ResponseRecorder) {
+	r := httptest.NewRequest(http.MethodGet, "/v1/toys/events", nil).WithContext(ctx)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	recorder := httptest.NewRecorder()
+	return &ServerContext{Response: recorder, Request: r}, recorder
+}
+
+func TestEventStreamSend(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{"data", Event{Data: "hello"}, "data: hello\n\n"},
+		{"all fields", Event{ID: "7", Event: "update", Data: `{"id":"1"}`, Retry: 1500 * time.Millisecond}, "id: 7\nevent: update\nretry: 1500\ndata: {\"id\":\"1\"}\n\n"},
+		{"multiline data", Event{Data: "a\nb\r\nc\rd"}, "data: a\ndata: b\ndata: c\ndata: d\n\n"},
+		{"empty data", Event{ID: "1"}, "id: 1\ndata: \n\n"},
+		{"line breaks in fields", Event{ID: "1\n2", Event: "up\r\ndate", Data: "x"}, "id: 1 2\nevent: up date\ndata: x\n\n"},
+	}
+	for _, test := range tests {
+		c, recorder := eventContext(context.Background())
+		stream, err := c.EventStream(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stream.Send(test.event); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := recorder.Body.String(); got != test.want {
+			t.Errorf("%s: sent %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestEventStreamHeaders(t *testing.T) {
+	c, recorder := eventContext(context.Background())
+	if _, err := c.EventStream(3 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	header := recorder.Header()
+	if recorder.Code != http.StatusOK || header.Get("Content-Type") != "text/event-stream; charset=utf-8" || header.Get("Cache-Control") != "no-cache" || header.Get("X-Accel-Buffering") != "no" {
+		t.Errorf("started with %d and header %v", recorder.Code, header)
+	}
+	if !recorder.Flushed || recorder.Body.String() != "retry: 3000\n\n" {
+		t.Errorf("sent %q, flushed %v", recorder.Body.String(), recorder.Flushed)
+	}
+}
+
+func TestEventStreamNeedsFlusher(t *testing.T) {
+	c, _ := eventContext(context.Background())
+	// the embedded interface hides the Flush of the recorder
+	c.Response = struct{ http.ResponseWriter }{c.Response}
+	if _, err := c.EventStream(0); !errors.Is(err, errStreamingUnsupported) {
+		t.Errorf("error %v, want %v", err, errStreamingUnsupported)
+	}
+}
+
+func TestLastEventID(t *testing.T) {
+	tests := []struct {
+		header []string
+		want   string
+	}{
+		{nil, ""},
+		{[]string{LastEventIDHeader, "42"}, "42"},
+		{[]string{LastEventIDHeader, " 42 "}, "42"},
+	}
+	for _, test := range tests {
+		c, _ := eventContext(context.Background(), test.header...)
+		if got := c.LastEventID(); got != test.want {
+			t.Errorf("LastEventID with header %q = %q, want %q", test.header, got, test.want)
+		}
+	}
+}
+
+func TestEventStreamRun(t *testing.T) {
+	c, recorder := eventContext(context.Background())
+	stream, err := c.EventStream(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan Event, 2)
+	events <- Event{ID: "1", Data: "first"}
+	go func() {
+		// leave time for heartbeats before the last event
+		time.Sleep(250 * time.Millisecond)
+		events <- Event{ID: "2", Data: "second"}
+		close(events)
+	}()
+	if err := stream.Run(events, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	body := recorder.Body.String()
+	if !strings.HasPrefix(body, "id: 1\ndata: first\n\n: heartbeat\n\n") || !strings.HasSuffix(body, ": heartbeat\n\nid: 2\ndata: second\n\n") {
+		t.Errorf("sent %q", body)
+	}
+}
+
+func TestEventStreamRunEndsWithRequest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c, recorder := eventContext(ctx)
+	stream, err := c.EventStream(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(50*time.Millisecond, cancel)
+	done := make(chan error, 1)
+	go func() { done <- stream.Run(make(chan Event), 0) }()
+	select {
+	case err := <-done:
+		if err != nil || recorder.Body.Len() != 0 {
+			t.Errorf("Run returned %v after sending %q", err, recorder.Body.String())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run kept streaming after the request was canceled")
+	}
+}
+
+func TestEventStreamIsFlushedThroughMiddlewares(t *testing.T) {
+	finished := make(chan struct{})
+	s := NewServer()
+	s.Use(Compress(CompressOptions{MinSize: 1, ContentTypes: []string{"text/*"}}))
+	s.AddHandler("/v1/toys/events", http.MethodGet, func(c *ServerContext) {
+		defer close(finished)
+		stream, err := c.EventStream(time.Second)
+		if err != nil {
+			c.ErrorHandler(http.StatusInternalServerError, err)
+			return
+		}
+		stream.Send(Event{ID: "1", Event: "insert", Data: "car"})
+		// the client reads the event while the stream is open
+		stream.Run(make(chan Event), 0)
+	})
+	ts := startServer(t, s)
+	request, _ := http.NewRequest(http.MethodGet, ts.URL+"/v1/toys/events", nil)
+	request.Header.Set("Accept-Encoding", "identity")
+	response, err := ts.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	var got []string
+	for len(got) < 6 {
+		select {
+		case line := <-lines:
+			got = append(got, line)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only %q arrived", got)
+		}
+	}
+	if want := "retry: 1000,,id: 1,event: insert,data: car,"; strings.Join(got, ",") != want {
+		t.Errorf("received %q, want %q", strings.Join(got, ","), want)
+	}
+	response.Body.Close()
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Error("the handler kept streaming after the client left")
+	}
+}
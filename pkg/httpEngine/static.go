@@ -0,0 +1,191 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// StaticOptions configures serving files with Static
+	StaticOptions struct {
+		// Index is the file served for directories, "index.html" when empty
+		Index string
+		// SPA serves the root index file for missing paths without an extension, so client side routes load the app
+		SPA bool
+		// MaxAge is how long clients may cache files without revalidating, index files are always revalidated
+		MaxAge time.Duration
+	}
+
+	// staticFiles serves the files of one file system
+	staticFiles struct {
+		fsys    fs.FS
+		options StaticOptions
+		// etags caches the content hashes of files by name, size and modification time
+		etags sync.Map
+	}
+)
+
+// StaticParam is the wildcard param holding the file path of routes added by Server.Static
+const StaticParam = "filepath"
+
+// Static returns a handler serving the files of fsys, like os.DirFS("./public") or an embed.FS,
+// by the path in the StaticParam wildcard param. Files get ETags and Last-Modified and support
+// conditional and Range requests, a file.gz next to a file is served to clients accepting gzip.
+// Paths with ".." or hidden segments are never served
+func Static(fsys fs.FS, options StaticOptions) HandlerFunc {
+	if options.Index == "" {
+		options.Index = "index.html"
+	}
+	files := &staticFiles{fsys: fsys, options: options}
+	return files.serve
+}
+
+// Static serves the files of fsys under prefix for GET and HEAD requests
+func (s *server) Static(prefix string, fsys fs.FS, options StaticOptions) {
+	route := strings.TrimSuffix(prefix, "/") + "/*" + StaticParam
+	handler := Static(fsys, options)
+	s.AddHandler(route, http.MethodGet, handler)
+	s.AddHandler(route, http.MethodHead, handler)
+}
+
+// serve writes the file of the request
+func (f *staticFiles) serve(c *ServerContext) {
+	name, ok := staticName(c.URLParams[StaticParam])
+	if !ok {
+		http.NotFound(c.Response, c.Request)
+		return
+	}
+	info, err := fs.Stat(f.fsys, name)
+	if err == nil && info.IsDir() {
+		// relative links of an index file need the trailing slash
+		if !strings.HasSuffix(c.Request.URL.Path, "/") {
+			target := c.Request.URL.Path + "/"
+			if c.Request.URL.RawQuery != "" {
+				target += "?" + c.Request.URL.RawQuery
+			}
+			http.Redirect(c.Response, c.Request, target, http.StatusMovedPermanently)
+			return
+		}
+		name = path.Join(name, f.options.Index)
+		info, err = fs.Stat(f.fsys, name)
+	}
+	if err != nil && f.options.SPA && path.Ext(name) == "" {
+		name = f.options.Index
+		info, err = fs.Stat(f.fsys, name)
+	}
+	if err != nil || info.IsDir() {
+		http.NotFound(c.Response, c.Request)
+		return
+	}
+
+	header := c.Response.Header()
+	if path.Base(name) == f.options.Index {
+		header.Set("Cache-Control", "no-cache")
+	} else if f.options.MaxAge > 0 {
+		header.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(f.options.MaxAge.Seconds())))
+	}
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	header.Add("Vary", "Accept-Encoding")
+	served, servedInfo := name, info
+	if negotiateEncoding(c.Request.Header.Get("Accept-Encoding")) == "gzip" {
+		if gzInfo, err := fs.Stat(f.fsys, name+".gz"); err == nil && !gzInfo.IsDir() {
+			served, servedInfo = name+".gz", gzInfo
+			header.Set("Content-Encoding", "gzip")
+		}
+	}
+	content, err := f.open(served)
+	if err != nil {
+		c.ErrorHandler(http.StatusInternalServerError, err)
+		return
+	}
+	defer content.Close()
+	etag, err := f.etag(served, servedInfo, content)
+	if err != nil {
+		c.ErrorHandler(http.StatusInternalServerError, err)
+		return
+	}
+	c.SetETag(etag)
+	http.ServeContent(c.Response, c.Request, name, servedInfo.ModTime(), content)
+}
+
+// staticName cleans the requested path to a name of fs.FS, rejecting parent and hidden segments
+func staticName(requested string) (string, bool) {
+	if strings.ContainsAny(requested, "\\\x00") {
+		return "", false
+	}
+	for _, segment := range strings.Split(requested, "/") {
+		if segment == ".." || strings.HasPrefix(segment, ".") && segment != "." {
+			return "", false
+		}
+	}
+	name := strings.TrimPrefix(path.Clean("/"+requested), "/")
+	if name == "" {
+		name = "."
+	}
+	return name, fs.ValidPath(name)
+}
+
+// open returns the file called name as a io.ReadSeeker, files which can not seek are read into memory
+func (f *staticFiles) open(name string) (readSeekCloser, error) {
+	file, err := f.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if seeker, ok := file.(readSeekCloser); ok {
+		return seeker, nil
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	return nopSeekCloser{bytes.NewReader(content)}, nil
+}
+
+// etag returns a strong entity tag of the content of a file, cached until its size or modification time changes
+func (f *staticFiles) etag(name string, info fs.FileInfo, content io.ReadSeeker) (string, error) {
+	key := name + "|" + strconv.FormatInt(info.Size(), 10) + "|" + info.ModTime().String()
+	if etag, ok := f.etags.Load(key); ok {
+		return etag.(string), nil
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := ETag(hex.EncodeToString(hash.Sum(nil))[:32])
+	f.etags.Store(key, etag)
+	return etag, nil
+}
+
+type (
+	// readSeekCloser is a file http.ServeContent can serve
+	readSeekCloser interface {
+		io.ReadSeeker
+		io.Closer
+	}
+
+	// nopSeekCloser adds a no-op Close to a bytes.Reader
+	nopSeekCloser struct {
+		*bytes.Reader
+	}
+)
+
+// Close does nothing
+func (nopSeekCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newStaticServer serves a temporary directory under /admin, a secret file lies next to the directory
+func newStaticServer(t *testing.T, options StaticOptions) Server {
+	t.Helper()
+	root := t.TempDir()
+	files := map[string]string{
+		"secret":                  "secret",
+		"public/index.html":       "<html>app</html>",
+		"public/app.js":           "console.log('app')",
+		"public/app.js.gz":        "gzipped app",
+		"public/.env":             "TOKEN=secret",
+		"public/.git/config":      "[core]",
+		"public/docs/.hidden.txt": "hidden",
+		"public/docs/guide.txt":   "guide",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := NewServer()
+	s.Static("/admin", os.DirFS(filepath.Join(root, "public")), options)
+	return s
+}
+
+func TestStaticRejectsParentAndHiddenPaths(t *testing.T) {
+	s := newStaticServer(t, StaticOptions{SPA: true})
+	for _, target := range []string{
+		"/admin/../secret",
+		"/admin/docs/../../secret",
+		"/admin/%2e%2e/secret",
+		"/admin/..%2fsecret",
+		"/admin/..%5csecret",
+		"/admin/docs%5c..%5c..%5csecret",
+		"/admin/.env",
+		"/admin/.git/config",
+		"/admin/docs/.hidden.txt",
+		"/admin/%2eenv",
+		"/admin/app.js%00.txt",
+	} {
+		response := serve(s, http.MethodGet, target)
+		if response.Code != http.StatusNotFound || strings.Contains(response.Body.String(), "secret") || strings.Contains(response.Body.String(), "[core]") {
+			t.Errorf("%s: %d %q", target, response.Code, response.Body.String())
+		}
+	}
+}
+
+func TestStaticServesFiles(t *testing.T) {
+	s := newStaticServer(t, StaticOptions{SPA: true, MaxAge: time.Hour})
+	tests := []struct {
+		name         string
+		target       string
+		header       []string
+		code         int
+		body         string
+		cacheControl string
+	}{
+		{"file", "/admin/app.js", nil, 200, "console.log('app')", "public, max-age=3600"},
+		{"precompressed", "/admin/app.js", []string{"Accept-Encoding", "gzip"}, 200, "gzipped app", "public, max-age=3600"},
+		{"nested", "/admin/docs/guide.txt", nil, 200, "guide", "public, max-age=3600"},
+		{"dot segment", "/admin/./docs/guide.txt", nil, 200, "guide", "public, max-age=3600"},
+		{"index", "/admin/", nil, 200, "<html>app</html>", "no-cache"},
+		{"spa route", "/admin/toys/7", nil, 200, "<html>app</html>", "no-cache"},
+		{"missing file", "/admin/missing.js", nil, 404, "", ""},
+		{"directory without slash", "/admin/docs", nil, 301, "", ""},
+	}
+	for _, test := range tests {
+		response := serve(s, http.MethodGet, test.target, test.header...)
+		if response.Code != test.code || test.body != "" && response.Body.String() != test.body {
+			t.Errorf("%s: %d %q, want %d %q", test.name, response.Code, response.Body.String(), test.code, test.body)
+			continue
+		}
+		if test.code == http.StatusOK && response.Header().Get("Cache-Control") != test.cacheControl {
+			t.Errorf("%s: Cache-Control is %q", test.name, response.Header().Get("Cache-Control"))
+		}
+	}
+
+	etag := serve(s, http.MethodGet, "/admin/app.js").Header().Get("ETag")
+	if response := serve(s, http.MethodGet, "/admin/app.js", "If-None-Match", etag); etag == "" || response.Code != http.StatusNotModified {
+		t.Errorf("revalidating %s: %d", etag, response.Code)
+	}
+}
+
+func TestStaticName(t *testing.T) {
+	tests := []struct {
+		requested string
+		name      string
+		ok        bool
+	}{
+		{"", ".", true},
+		{"app.js", "app.js", true},
+		{"docs/guide.txt", "docs/guide.txt", true},
+		{"docs//guide.txt", "docs/guide.txt", true},
+		{"./docs/./guide.txt", "docs/guide.txt", true},
+		{"..", "", false},
+		{"docs/../../secret", "", false},
+		{"docs/..", "", false},
+		{".env", "", false},
+		{"docs/.git/config", "", false},
+		{`..\secret`, "", false},
+		{"app.js\x00", "", false},
+	}
+	for _, test := range tests {
+		name, ok := staticName(test.requested)
+		if ok != test.ok || ok && name != test.name {
+			t.Errorf("staticName(%q) = %q, %v, want %q, %v", test.requested, name, ok, test.name, test.ok)
+		}
+	}
+}
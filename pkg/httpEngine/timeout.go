@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/amupxm/pure-webserver/constants"
+)
+
+type (
+	// timeoutWriter buffers the response of a handler running under a timeout,
+	// writes after the timeout fail with http.ErrHandlerTimeout
+	timeoutWriter struct {
+		lock     sync.Mutex
+		header   http.Header
+		status   int
+		body     bytes.Buffer
+		timedOut bool
+	}
+	// timeoutBody is the request body of a handler running under a timeout, it is detached
+	// when the timeout answers because net/http forbids reading the body after ServeHTTP returned
+	timeoutBody struct {
+		lock     sync.Mutex
+		body     io.ReadCloser
+		detached bool
+	}
+)
+
+var (
+	errTimeout   = errors.New(constants.Timeout)
+	errCancelled = errors.New(constants.Cancelled)
+)
+
+// Timeout bounds the handling of a request by the duration timeoutFor returns for its method and route pattern,
+// zero means no timeout. When it is exceeded the context of the handler is cancelled and the client gets 504,
+// what the handler writes afterwards is dropped and its reads of the request body fail. A read in progress delays
+// the answer until it returns, which ReadTimeout bounds. Responses are buffered, so streaming routes should have no timeout
+func Timeout(timeoutFor func(method, route string) time.Duration) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *ServerContext) {
+			timeout := timeoutFor(c.Request.Method, c.Route)
+			if timeout <= 0 || c.Route == "" {
+				next(c)
+				return
+			}
+			ctx, cancel := context.WithTimeout(c.Context(), timeout)
+			defer cancel()
+			writer := &timeoutWriter{header: make(http.Header)}
+			// the handler gets its own context so it never touches c once the timeout answered
+			inner := *c
+			inner.Request = c.Request.WithContext(ctx)
+			inner.Response = writer
+			body := &timeoutBody{body: c.Request.Body}
+			if c.Request.Body != nil && c.Request.Body != http.NoBody {
+				inner.Request.Body = body
+			}
+
+			done := make(chan struct{})
+			panicked := make(chan interface{}, 1)
+			go func() {
+				defer close(done)
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+				}()
+				next(&inner)
+			}()
+			select {
+			case <-done:
+				select {
+				case p := <-panicked:
+					// panics are raised in the serving goroutine so they do not crash the process
+					panic(p)
+				default:
+				}
+				writer.writeTo(c.Response)
+			case <-ctx.Done():
+				writer.lock.Lock()
+				writer.timedOut = true
+				writer.lock.Unlock()
+				body.detach()
+				c.ErrorHandler(http.StatusGatewayTimeout, ctx.Err())
+			}
+		}
+	}
+}
+
+// contextErrorStatus returns the status and error reported for work stopped by a deadline (504)
+// or a cancellation (503), a zero status for other errors
+func contextErrorStatus(err error) (int, error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, errTimeout
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable, errCancelled
+	}
+	return 0, err
+}
+
+// Header returns the buffered header
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader buffers the status code
+func (w *timeoutWriter) WriteHeader(status int) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.status == 0 && !w.timedOut {
+		w.status = status
+	}
+}
+
+// Write buffers a part of the body
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// writeTo sends the buffered response
+func (w *timeoutWriter) writeTo(dst http.ResponseWriter) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for name, values := range w.header {
+		dst.Header()[name] = values
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	dst.WriteHeader(w.status)
+	dst.Write(w.body.Bytes())
+}
+
+// Read reads the request body until it is detached, then it fails with http.ErrHandlerTimeout
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.detached {
+		return 0, http.ErrHandlerTimeout
+	}
+	return b.body.Read(p)
+}
+
+// Close closes the request body unless it is detached, net/http closes it then
+func (b *timeoutBody) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.detached {
+		return nil
+	}
+	return b.body.Close()
+}
+
+// detach waits for a read in progress and makes the following ones fail
+func (b *timeoutBody) detach() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.detached = true
+}
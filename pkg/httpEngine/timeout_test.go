@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// routeTimeout returns a timeoutFor giving timeout to every route but exempt
+func routeTimeout(timeout time.Duration, exempt string) func(method, route string) time.Duration {
+	return func(method, route string) time.Duration {
+		if route == exempt {
+			return 0
+		}
+		return timeout
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	lateWrite := make(chan error, 1)
+	s := NewServer()
+	s.Use(Timeout(routeTimeout(50*time.Millisecond, "/v1/exempt")))
+	s.AddHandler("/v1/slow", http.MethodGet, func(c *ServerContext) {
+		<-c.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		_, err := c.Response.Write([]byte("late"))
+		lateWrite <- err
+	})
+	s.AddHandler("/v1/fast", http.MethodGet, func(c *ServerContext) {
+		c.Response.Header().Set("X-Toy", "car")
+		c.JSON(http.StatusCreated, "fast")
+	})
+	s.AddHandler("/v1/exempt", http.MethodGet, func(c *ServerContext) {
+		time.Sleep(100 * time.Millisecond)
+		c.JSON(http.StatusOK, "exempt")
+	})
+	tests := []struct {
+		target string
+		code   int
+		body   string
+	}{
+		{"/v1/slow", http.StatusGatewayTimeout, ""},
+		{"/v1/fast", http.StatusCreated, `"fast"`},
+		{"/v1/exempt", http.StatusOK, `"exempt"`},
+	}
+	for _, test := range tests {
+		start := time.Now()
+		recorder := serve(s, http.MethodGet, test.target)
+		if recorder.Code != test.code {
+			t.Errorf("%s: code %d, want %d", test.target, recorder.Code, test.code)
+		}
+		if test.body != "" && strings.TrimSpace(recorder.Body.String()) != test.body {
+			t.Errorf("%s: body %q, want %s", test.target, recorder.Body.String(), test.body)
+		}
+		if test.code == http.StatusGatewayTimeout && time.Since(start) > time.Second {
+			t.Errorf("%s: answered after %s", test.target, time.Since(start))
+		}
+	}
+	if err := <-lateWrite; !errors.Is(err, http.ErrHandlerTimeout) {
+		t.Errorf("write after the timeout: %v, want %v", err, http.ErrHandlerTimeout)
+	}
+	if recorder := serve(s, http.MethodGet, "/v1/fast"); recorder.Header().Get("X-Toy") != "car" {
+		t.Errorf("buffered header was not sent: %v", recorder.Header())
+	}
+}
+
+func TestErrorHandlerReportsContextErrors(t *testing.T) {
+	s := NewServer()
+	s.AddHandler("/v1/toys", http.MethodGet, func(c *ServerContext) {
+		<-c.Context().Done()
+		// handlers answer with their own code, the context decides
+		c.ErrorHandler(http.StatusBadRequest, errors.New("can not read products"))
+	})
+	deadline, cancelDeadline := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelDeadline()
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		code int
+	}{
+		{"deadline", deadline, http.StatusGatewayTimeout},
+		{"client gone", cancelled, http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/v1/toys", nil).WithContext(test.ctx)
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, r)
+		if recorder.Code != test.code {
+			t.Errorf("%s: code %d, want %d", test.name, recorder.Code, test.code)
+		}
+	}
+}
+
+// guardedBody holds "{}" and fails the test when it is read after the server answered
+type guardedBody struct {
+	t        *testing.T
+	lock     sync.Mutex
+	answered bool
+	reads    int
+}
+
+func (b *guardedBody) Read(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.answered {
+		b.t.Error("the body was read after the server answered")
+	}
+	b.reads++
+	if b.reads > 1 {
+		return 0, io.EOF
+	}
+	return copy(p, "{}"), nil
+}
+
+func (b *guardedBody) Close() error { return nil }
+
+func TestTimeoutDetachesTheBody(t *testing.T) {
+	answered := make(chan struct{})
+	readErr := make(chan error, 1)
+	s := NewServer()
+	s.Use(Timeout(routeTimeout(20*time.Millisecond, "")))
+	s.AddHandler("/v1/toys", http.MethodPost, func(c *ServerContext) {
+		if _, err := c.Request.Body.Read(make([]byte, 2)); err != nil {
+			t.Error(err)
+		}
+		<-answered
+		_, err := io.ReadAll(c.Request.Body)
+		readErr <- err
+	})
+	body := &guardedBody{t: t}
+	r := httptest.NewRequest(http.MethodPost, "/v1/toys", nil)
+	r.Body = body
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, r)
+	body.lock.Lock()
+	body.answered = true
+	body.lock.Unlock()
+	close(answered)
+
+	if recorder.Code != http.StatusGatewayTimeout {
+		t.Errorf("code %d, want %d", recorder.Code, http.StatusGatewayTimeout)
+	}
+	if err := <-readErr; !errors.Is(err, http.ErrHandlerTimeout) {
+		t.Errorf("read after the timeout: %v, want %v", err, http.ErrHandlerTimeout)
+	}
+}
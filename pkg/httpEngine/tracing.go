@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/amupxm/pure-webserver/pkg/tracing"
+)
+
+// WithTracer records a server span per request with child spans for routing and the handler,
+// continuing the trace of the traceparent and tracestate headers
+func WithTracer(tracer *tracing.Tracer) Option {
+	return func(s *server) {
+		s.tracer = tracer
+	}
+}
+
+// traceRequest starts the server span of a request and returns the function ending it,
+// the span is named after the route pattern once the request is routed
+func (s *server) traceRequest(c *ServerContext) func() {
+	ctx := c.Request.Context()
+	if parent, err := tracing.Extract(c.Request.Header); err == nil {
+		ctx = tracing.ContextWithRemoteSpanContext(ctx, parent)
+	}
+	ctx, span := s.tracer.Start(ctx, c.Request.Method, tracing.KindServer)
+	span.SetAttribute("http.method", c.Request.Method)
+	span.SetAttribute("http.target", c.Request.URL.RequestURI())
+	span.SetAttribute("net.peer.ip", clientIP(c.Request))
+	writer := &statusWriter{ResponseWriter: c.Response}
+	c.Response = writer
+	c.Request = c.Request.WithContext(ctx)
+	return func() {
+		route := c.Route
+		if route == "" {
+			route = unmatchedRoute
+		}
+		span.SetName(c.Request.Method + " " + route)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("http.status_code", writer.Status())
+		if c.RequestID != "" {
+			span.SetAttribute("http.request_id", c.RequestID)
+		}
+		if writer.Status() >= http.StatusInternalServerError {
+			span.SetStatus(tracing.StatusError, http.StatusText(writer.Status()))
+		}
+		span.End()
+	}
+}
+
+// findTracedHandler finds the handler of a request inside a routing span
+// and wraps it in a span covering the handler alone
+func (s *server) findTracedHandler(c *ServerContext) HandlerFunc {
+	_, span := tracing.Start(c.Request.Context(), "routing")
+	handler := s.findHandler(c)
+	span.SetAttribute("http.route", c.Route)
+	span.End()
+	return func(c *ServerContext) {
+		ctx, span := tracing.Start(c.Request.Context(), "handler")
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+		handler(c)
+	}
+}
@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/amupxm/pure-webserver/constants"
+	"github.com/amupxm/pure-webserver/pkg/logger"
+	"github.com/amupxm/pure-webserver/pkg/websocket"
+)
+
+// WebSocketHandler handles one websocket connection, the connection is closed when it returns
+type WebSocketHandler func(c *ServerContext, conn *websocket.Conn)
+
+var errUpgradeRequired = errors.New(constants.UpgradeRequired)
+
+// WebSocket upgrades the requests of a route to websockets handled by handler, other requests get 426.
+// The connection is taken over from the server, so the route should have no timeout
+func WebSocket(handler WebSocketHandler, options websocket.Options) HandlerFunc {
+	return func(c *ServerContext) {
+		if !websocket.IsUpgrade(c.Request) {
+			c.Response.Header().Set("Upgrade", "websocket")
+			c.ErrorHandler(http.StatusUpgradeRequired, errUpgradeRequired)
+			return
+		}
+		conn, err := websocket.Upgrade(c.Response, c.Request, options)
+		if err != nil {
+			logger.Default().Debug("websocket handshake failed", logger.Fields{"error": err, "request_id": c.RequestID})
+			return
+		}
+		defer conn.Close(websocket.CloseNormal, "")
+		handler(c, conn)
+	}
+}
+
+// WebSocket serves websockets on path with handler
+func (s *server) WebSocket(path string, handler WebSocketHandler, options websocket.Options) {
+	s.AddHandler(path, http.MethodGet, WebSocket(handler, options))
+}
@@ -0,0 +1,214 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+)
+
+type (
+	// Patcher applies a patch document to a decoded json value
+	Patcher interface {
+		// Apply returns the patched copy of doc, doc itself is never modified
+		Apply(doc interface{}) (interface{}, error)
+	}
+	// MergePatch is a RFC 7396 json merge patch document
+	MergePatch struct {
+		patch interface{}
+	}
+	// Patch is a RFC 6902 json patch document
+	Patch []Operation
+	// Operation is one operation of a json patch document
+	Operation struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		From  string          `json:"from,omitempty"`
+		Value json.RawMessage `json:"value,omitempty"`
+	}
+)
+
+const (
+	// MergePatchType is the media type of RFC 7396 documents
+	MergePatchType = "application/merge-patch+json"
+	// JSONPatchType is the media type of RFC 6902 documents
+	JSONPatchType = "application/json-patch+json"
+)
+
+var (
+	// ErrUnsupportedMediaType is returned by Decode for media types other than merge and json patch
+	ErrUnsupportedMediaType = errors.New("unsupported patch media type")
+	// ErrTestFailed is returned when a test operation does not hold
+	ErrTestFailed = errors.New("patch test operation failed")
+)
+
+// Decode parses body as a merge patch or a json patch depending on contentType
+func Decode(contentType string, body []byte) (Patcher, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, ErrUnsupportedMediaType
+	}
+	switch mediaType {
+	case MergePatchType:
+		return DecodeMergePatch(body)
+	case JSONPatchType:
+		return DecodePatch(body)
+	}
+	return nil, ErrUnsupportedMediaType
+}
+
+// DecodeMergePatch parses a RFC 7396 json merge patch document
+func DecodeMergePatch(body []byte) (MergePatch, error) {
+	var patch interface{}
+	err := json.Unmarshal(body, &patch)
+	return MergePatch{patch: patch}, err
+}
+
+// DecodePatch parses a RFC 6902 json patch document
+func DecodePatch(body []byte) (Patch, error) {
+	var patch Patch
+	err := json.Unmarshal(body, &patch)
+	return patch, err
+}
+
+// Apply merges the patch into a copy of doc, null members of the patch remove members of doc
+func (mp MergePatch) Apply(doc interface{}) (interface{}, error) {
+	return mergePatch(deepCopy(doc), mp.patch), nil
+}
+
+// mergePatch implements the MergePatch function of RFC 7396 section 2
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = mergePatch(targetObject[name], value)
+	}
+	return targetObject
+}
+
+// Apply runs every operation on a copy of doc, the patch is applied entirely or not at all
+func (p Patch) Apply(doc interface{}) (interface{}, error) {
+	result := deepCopy(doc)
+	for i, operation := range p {
+		var err error
+		result, err = operation.apply(result)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, operation.Op, operation.Path, err)
+		}
+	}
+	return result, nil
+}
+
+// apply runs one operation on doc and returns the new document
+func (o Operation) apply(doc interface{}) (interface{}, error) {
+	path, err := parsePointer(o.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch o.Op {
+	case "add", "replace", "test":
+		if o.Value == nil {
+			return nil, errors.New("missing value")
+		}
+		var value interface{}
+		if err := json.Unmarshal(o.Value, &value); err != nil {
+			return nil, err
+		}
+		switch o.Op {
+		case "add":
+			return add(doc, path, value)
+		case "replace":
+			return replace(doc, path, value)
+		}
+		current, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(current, value) {
+			return nil, ErrTestFailed
+		}
+		return doc, nil
+	case "remove":
+		return remove(doc, path)
+	case "move", "copy":
+		from, err := parsePointer(o.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if o.Op == "copy" {
+			return add(doc, path, deepCopy(value))
+		}
+		if from.isProperPrefixOf(path) {
+			return nil, errors.New("can not move a value into one of its children")
+		}
+		doc, err = remove(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+	}
+	return nil, fmt.Errorf("unknown operation %q", o.Op)
+}
+
+// deepCopy copies decoded json values so patches never share state with their input
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for name, member := range v {
+			result[name] = deepCopy(member)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, element := range v {
+			result[i] = deepCopy(element)
+		}
+		return result
+	}
+	return value
+}
+
+// equal compares decoded json values as required by the test operation
+func equal(a, b interface{}) bool {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for name, member := range av {
+			other, ok := bv[name]
+			if !ok || !equal(member, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !equal(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
@@ -0,0 +1,161 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// decodeJSON decodes a json literal of a test
+func decodeJSON(t *testing.T, s string) interface{} {
+	t.Helper()
+	var value interface{}
+	if err := json.Unmarshal([]byte(s), &value); err != nil {
+		t.Fatalf("invalid test json %s: %v", s, err)
+	}
+	return value
+}
+
+func TestPatchApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{"add member", `{"a":1}`, `[{"op":"add","path":"/b","value":2}]`, `{"a":1,"b":2}`},
+		{"add replaces member", `{"a":1}`, `[{"op":"add","path":"/a","value":2}]`, `{"a":2}`},
+		{"add array element", `{"a":[1,3]}`, `[{"op":"add","path":"/a/1","value":2}]`, `{"a":[1,2,3]}`},
+		{"add array end", `{"a":[1]}`, `[{"op":"add","path":"/a/-","value":2}]`, `{"a":[1,2]}`},
+		{"add whole document", `{"a":1}`, `[{"op":"add","path":"","value":{"b":2}}]`, `{"b":2}`},
+		{"remove member", `{"a":1,"b":2}`, `[{"op":"remove","path":"/a"}]`, `{"b":2}`},
+		{"remove array element", `{"a":[1,2,3]}`, `[{"op":"remove","path":"/a/0"}]`, `{"a":[2,3]}`},
+		{"replace nested", `{"a":{"b":1}}`, `[{"op":"replace","path":"/a/b","value":"x"}]`, `{"a":{"b":"x"}}`},
+		{"move", `{"a":1}`, `[{"op":"move","from":"/a","path":"/b"}]`, `{"b":1}`},
+		{"copy", `{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"}]`, `{"a":{"b":1},"c":{"b":1}}`},
+		{"test then replace", `{"a":[1,{"b":2}]}`, `[{"op":"test","path":"/a","value":[1,{"b":2}]},{"op":"replace","path":"/a","value":0}]`, `{"a":0}`},
+		{"escaped pointer", `{"a/b":1,"c~d":2}`, `[{"op":"remove","path":"/a~1b"},{"op":"remove","path":"/c~0d"}]`, `{}`},
+	}
+	for _, test := range tests {
+		patch, err := DecodePatch([]byte(test.patch))
+		if err != nil {
+			t.Fatalf("%s: decode: %v", test.name, err)
+		}
+		doc := decodeJSON(t, test.doc)
+		got, err := patch.Apply(doc)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if want := decodeJSON(t, test.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, want)
+		}
+		if !reflect.DeepEqual(doc, decodeJSON(t, test.doc)) {
+			t.Errorf("%s: the input document was modified to %v", test.name, doc)
+		}
+	}
+}
+
+func TestPatchApplyErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  error
+	}{
+		{"failed test", `[{"op":"test","path":"/a","value":2}]`, ErrTestFailed},
+		{"remove missing member", `[{"op":"remove","path":"/missing"}]`, ErrPathNotFound},
+		{"replace missing member", `[{"op":"replace","path":"/missing","value":1}]`, ErrPathNotFound},
+		{"add below missing member", `[{"op":"add","path":"/missing/b","value":1}]`, ErrPathNotFound},
+		{"array index out of range", `[{"op":"add","path":"/list/5","value":1}]`, ErrPathNotFound},
+		{"leading zero index", `[{"op":"remove","path":"/list/01"}]`, nil},
+		{"missing value", `[{"op":"add","path":"/b"}]`, nil},
+		{"unknown op", `[{"op":"merge","path":"/a"}]`, nil},
+		{"invalid pointer", `[{"op":"remove","path":"a"}]`, nil},
+		{"remove whole document", `[{"op":"remove","path":""}]`, nil},
+		{"move into own child", `[{"op":"move","from":"/obj","path":"/obj/child"}]`, nil},
+		// the first operation is not kept when a later one fails
+		{"atomic", `[{"op":"add","path":"/b","value":1},{"op":"test","path":"/a","value":0}]`, ErrTestFailed},
+	}
+	for _, test := range tests {
+		patch, err := DecodePatch([]byte(test.patch))
+		if err != nil {
+			t.Fatalf("%s: decode: %v", test.name, err)
+		}
+		doc := decodeJSON(t, `{"a":1,"list":[1,2],"obj":{}}`)
+		got, err := patch.Apply(doc)
+		if err == nil {
+			t.Errorf("%s: got %v, want an error", test.name, got)
+			continue
+		}
+		if test.want != nil && !errors.Is(err, test.want) {
+			t.Errorf("%s: error %v, want %v", test.name, err, test.want)
+		}
+		if _, ok := doc.(map[string]interface{})["b"]; ok {
+			t.Errorf("%s: the input document was modified", test.name)
+		}
+	}
+}
+
+func TestMergePatchApply(t *testing.T) {
+	tests := []struct {
+		doc   string
+		patch string
+		want  string
+	}{
+		// examples of RFC 7396 appendix A
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, test := range tests {
+		patch, err := DecodeMergePatch([]byte(test.patch))
+		if err != nil {
+			t.Fatalf("decode %s: %v", test.patch, err)
+		}
+		got, err := patch.Apply(decodeJSON(t, test.doc))
+		if err != nil {
+			t.Errorf("merge %s into %s: %v", test.patch, test.doc, err)
+			continue
+		}
+		if want := decodeJSON(t, test.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("merge %s into %s = %v, want %v", test.patch, test.doc, got, want)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		want        interface{}
+		err         error
+	}{
+		{MergePatchType, `{"a":1}`, MergePatch{}, nil},
+		{JSONPatchType + "; charset=utf-8", `[]`, Patch{}, nil},
+		{"application/json", `{}`, nil, ErrUnsupportedMediaType},
+		{"not a media type;;", `{}`, nil, ErrUnsupportedMediaType},
+	}
+	for _, test := range tests {
+		patcher, err := Decode(test.contentType, []byte(test.body))
+		if !errors.Is(err, test.err) {
+			t.Errorf("Decode(%q) error = %v, want %v", test.contentType, err, test.err)
+			continue
+		}
+		if test.want != nil && reflect.TypeOf(patcher) != reflect.TypeOf(test.want) {
+			t.Errorf("Decode(%q) = %T, want %T", test.contentType, patcher, test.want)
+		}
+	}
+}
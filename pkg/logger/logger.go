@@ -0,0 +1,208 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Level is the severity of an entry
+	Level int32
+	// Format is the encoding of entries
+	Format string
+	// Fields are the key value pairs attached to an entry
+	Fields map[string]interface{}
+
+	// Logger writes leveled entries with structured fields
+	Logger interface {
+		// With returns a logger adding fields to every entry
+		With(fields Fields) Logger
+		// Debug writes an entry for developers
+		Debug(msg string, fields ...Fields)
+		// Info writes an entry about normal operation
+		Info(msg string, fields ...Fields)
+		// Warn writes an entry about an unexpected but handled situation
+		Warn(msg string, fields ...Fields)
+		// Error writes an entry about a failure
+		Error(msg string, fields ...Fields)
+		// SetLevel changes the lowest level written by this logger and every logger derived from it
+		SetLevel(level Level)
+	}
+	logger struct {
+		out    *output
+		fields Fields
+	}
+	// output is shared by a logger and the loggers derived from it
+	output struct {
+		lock   sync.Mutex
+		writer io.Writer
+		format Format
+		level  int32
+	}
+)
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+const (
+	FormatJSON   Format = "json"
+	FormatLogfmt Format = "logfmt"
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+var (
+	defaultLock   sync.RWMutex
+	defaultLogger = New(os.Stderr, FormatLogfmt, LevelInfo)
+)
+
+// New creates a logger writing entries of level and above to w
+func New(w io.Writer, format Format, level Level) Logger {
+	return &logger{
+		out: &output{
+			writer: w,
+			format: format,
+			level:  int32(level),
+		},
+	}
+}
+
+// Default returns the logger used across packages
+func Default() Logger {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+	return defaultLogger
+}
+
+// SetDefault replaces the logger used across packages
+func SetDefault(l Logger) {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	defaultLogger = l
+}
+
+// ParseLevel parses a level name like "info"
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// String returns the name of the level
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// With returns a logger adding fields to every entry
+func (l *logger) With(fields Fields) Logger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &logger{out: l.out, fields: merged}
+}
+
+// Debug writes an entry for developers
+func (l *logger) Debug(msg string, fields ...Fields) { l.write(LevelDebug, msg, fields) }
+
+// Info writes an entry about normal operation
+func (l *logger) Info(msg string, fields ...Fields) { l.write(LevelInfo, msg, fields) }
+
+// Warn writes an entry about an unexpected but handled situation
+func (l *logger) Warn(msg string, fields ...Fields) { l.write(LevelWarn, msg, fields) }
+
+// Error writes an entry about a failure
+func (l *logger) Error(msg string, fields ...Fields) { l.write(LevelError, msg, fields) }
+
+// SetLevel changes the lowest level written by this logger and every logger derived from it
+func (l *logger) SetLevel(level Level) {
+	atomic.StoreInt32(&l.out.level, int32(level))
+}
+
+// write encodes one entry as a single line
+func (l *logger) write(level Level, msg string, extra []Fields) {
+	if int32(level) < atomic.LoadInt32(&l.out.level) {
+		return
+	}
+	fields := make(Fields, len(l.fields)+3)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	for _, f := range extra {
+		for k, v := range f {
+			fields[k] = v
+		}
+	}
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			fields[k] = err.Error()
+		}
+	}
+
+	var line []byte
+	if l.out.format == FormatJSON {
+		fields["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+		fields["level"] = level.String()
+		fields["msg"] = msg
+		var err error
+		line, err = json.Marshal(fields)
+		if err != nil {
+			line, _ = json.Marshal(map[string]string{"level": "error", "msg": "can not encode log entry: " + err.Error()})
+		}
+	} else {
+		line = encodeLogfmt(level, msg, fields)
+	}
+	line = append(line, '\n')
+
+	l.out.lock.Lock()
+	defer l.out.lock.Unlock()
+	l.out.writer.Write(line)
+}
+
+// encodeLogfmt writes time, level and msg followed by fields sorted by key
+func encodeLogfmt(level Level, msg string, fields Fields) []byte {
+	var b strings.Builder
+	b.WriteString("time=" + time.Now().UTC().Format(time.RFC3339Nano))
+	b.WriteString(" level=" + level.String())
+	b.WriteString(" msg=" + logfmtValue(msg))
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteString(" " + k + "=" + logfmtValue(fmt.Sprint(fields[k])))
+	}
+	return []byte(b.String())
+}
+
+// logfmtValue quotes values which are empty or contain spaces, quotes or equal signs
+func logfmtValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " =\"\t\n\r") {
+		return strconv.Quote(value)
+	}
+	return value
+}
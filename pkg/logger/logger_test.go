@@ -0,0 +1,100 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestJSONEntries(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out, FormatJSON, LevelDebug).With(Fields{"service": "toys"})
+	l.Info("request", Fields{"status": 200, "error": errors.New("broken pipe")})
+	var entry map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("entry %q is not json: %v", out.String(), err)
+	}
+	want := map[string]interface{}{"level": "info", "msg": "request", "service": "toys", "status": 200.0, "error": "broken pipe"}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Errorf("%s is %v, want %v", key, entry[key], value)
+		}
+	}
+	if _, ok := entry["time"].(string); !ok {
+		t.Errorf("entry has no time: %q", out.String())
+	}
+	if strings.Count(out.String(), "\n") != 1 {
+		t.Errorf("entry is not a single line: %q", out.String())
+	}
+}
+
+func TestLogfmtEntries(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields Fields
+		want   string
+	}{
+		{"plain", Fields{"status": 200}, " status=200"},
+		{"sorted keys", Fields{"b": 1, "a": 2}, " a=2 b=1"},
+		{"empty value", Fields{"route": ""}, ` route=""`},
+		{"space", Fields{"path": "/v1/toys search"}, ` path="/v1/toys search"`},
+		{"equal sign", Fields{"query": "q=car"}, ` query="q=car"`},
+		{"quote and newline", Fields{"agent": "say \"hi\"\nbye"}, ` agent="say \"hi\"\nbye"`},
+		{"error", Fields{"error": errors.New("not found")}, ` error="not found"`},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		New(&out, FormatLogfmt, LevelInfo).Info("toy written", test.fields)
+		line := strings.TrimSuffix(out.String(), "\n")
+		if !strings.HasPrefix(line, "time=") || strings.Contains(line, "\n") {
+			t.Errorf("%s: entry %q is not one logfmt line", test.name, out.String())
+		}
+		if want := ` level=info msg="toy written"` + test.want; !strings.HasSuffix(line, want) {
+			t.Errorf("%s: entry %q does not end with %q", test.name, line, want)
+		}
+	}
+}
+
+func TestLevels(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out, FormatLogfmt, LevelWarn)
+	derived := l.With(Fields{"component": "database"})
+	write := func() {
+		l.Debug("debug")
+		l.Info("info")
+		l.Warn("warn")
+		derived.Error("error")
+	}
+	write()
+	if got := strings.Count(out.String(), "\n"); got != 2 || !strings.Contains(out.String(), "level=warn") || !strings.Contains(out.String(), "level=error") {
+		t.Errorf("at warn the logger wrote %d entries:\n%s", got, out.String())
+	}
+	// the level is shared with the derived loggers
+	out.Reset()
+	derived.SetLevel(LevelDebug)
+	write()
+	if got := strings.Count(out.String(), "\n"); got != 4 {
+		t.Errorf("at debug the logger wrote %d entries, want 4:\n%s", got, out.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		level Level
+		ok    bool
+	}{
+		{"debug", LevelDebug, true},
+		{"WARN", LevelWarn, true},
+		{"error", LevelError, true},
+		{"loud", LevelInfo, false},
+	}
+	for _, test := range tests {
+		level, err := ParseLevel(test.name)
+		if level != test.level || (err == nil) != test.ok {
+			t.Errorf("ParseLevel(%q) = %s, %v", test.name, level, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the media type of the prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler serves the metrics of r in the prometheus text exposition format
+func Handler(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		_ = r.Write(w)
+	})
+}
+
+// Write renders every family of the registry sorted by name, series sorted by label values
+func (r *Registry) Write(w io.Writer) error {
+	r.lock.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.lock.Unlock()
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	out := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(out)
+	}
+	return out.Flush()
+}
+
+// write renders the help, type and samples of a family
+func (f *family) write(out *bufio.Writer) {
+	out.WriteString("# HELP " + f.name + " " + escapeHelp(f.help) + "\n")
+	out.WriteString("# TYPE " + f.name + " " + string(f.kind) + "\n")
+	if f.collect != nil {
+		writeSample(out, f.name, nil, nil, f.collect())
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != KindHistogram {
+			writeSample(out, f.name, f.labels, s.labelValues, s.value)
+			continue
+		}
+		labels := append(append([]string(nil), f.labels...), "le")
+		for i, upperBound := range f.buckets {
+			values := append(append([]string(nil), s.labelValues...), formatFloat(upperBound))
+			writeSample(out, f.name+"_bucket", labels, values, float64(s.counts[i]))
+		}
+		values := append(append([]string(nil), s.labelValues...), "+Inf")
+		writeSample(out, f.name+"_bucket", labels, values, float64(s.count))
+		writeSample(out, f.name+"_sum", f.labels, s.labelValues, s.sum)
+		writeSample(out, f.name+"_count", f.labels, s.labelValues, float64(s.count))
+	}
+}
+
+// writeSample renders one line like name{label="value"} 1
+func writeSample(out *bufio.Writer, name string, labels, values []string, value float64) {
+	out.WriteString(name)
+	if len(labels) > 0 {
+		out.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			out.WriteString(label + `="` + escapeLabelValue(values[i]) + `"`)
+		}
+		out.WriteByte('}')
+	}
+	out.WriteString(" " + formatFloat(value) + "\n")
+}
+
+// formatFloat renders a sample value, infinities and NaN as prometheus expects them
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// escapeHelp escapes backslashes and line feeds of help texts
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+// escapeLabelValue escapes backslashes, double quotes and line feeds of label values
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
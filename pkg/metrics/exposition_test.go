@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("http_requests_total", "Requests by method\nand code.", "method", "code")
+	requests.With("GET", "200").Inc()
+	requests.With("GET", "200").Add(2)
+	requests.With("GET", "200").Add(-5)
+	requests.With("POST", `5"0\0`).Inc()
+	r.Gauge("in_flight", "Requests being served.").With().Set(3)
+	r.Histogram("latency_seconds", "Latency.", []float64{1, 0.1}, "route").With("/toys").Observe(0.5)
+	r.GaugeFunc("up", "Always one.", func() float64 { return 1 })
+
+	var out bytes.Buffer
+	if err := r.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP http_requests_total Requests by method\nand code.
+# TYPE http_requests_total counter
+http_requests_total{method="GET",code="200"} 3
+http_requests_total{method="POST",code="5\"0\\0"} 1
+# HELP in_flight Requests being served.
+# TYPE in_flight gauge
+in_flight 3
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/toys",le="0.1"} 0
+latency_seconds_bucket{route="/toys",le="1"} 1
+latency_seconds_bucket{route="/toys",le="+Inf"} 1
+latency_seconds_sum{route="/toys"} 0.5
+latency_seconds_count{route="/toys"} 1
+# HELP up Always one.
+# TYPE up gauge
+up 1
+`
+	if out.String() != want {
+		t.Errorf("exposition is\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestRegisterConflictPanics(t *testing.T) {
+	r := NewRegistry()
+	if r.Counter("hits", "Hits.", "route").family != r.Counter("hits", "Hits.", "route").family {
+		t.Error("registering a family again returned another one")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a counter as a gauge did not panic")
+		}
+	}()
+	r.Gauge("hits", "Hits.", "route")
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("hits", "Hits.").With().Inc()
+	recorder := httptest.NewRecorder()
+	Handler(r).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if got := recorder.Header().Get("Content-Type"); got != ContentType {
+		t.Errorf("content type is %q", got)
+	}
+	if !bytes.Contains(recorder.Body.Bytes(), []byte("hits 1\n")) {
+		t.Errorf("body is %s", recorder.Body.String())
+	}
+}
@@ -0,0 +1,218 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type (
+	// Kind is the prometheus type of a metric family
+	Kind string
+
+	// Registry holds metric families and renders them in the prometheus text format
+	Registry struct {
+		lock     sync.Mutex
+		families map[string]*family
+	}
+	// family is a named metric with its help, labels and one series per label values
+	family struct {
+		lock    sync.Mutex
+		name    string
+		help    string
+		kind    Kind
+		labels  []string
+		buckets []float64
+		series  map[string]*series
+		// collect computes the value of a family without labels when it is rendered
+		collect func() float64
+	}
+	// series is the state of a family for one combination of label values
+	series struct {
+		labelValues []string
+		value       float64
+		// counts holds the cumulative count per bucket of histograms
+		counts []uint64
+		count  uint64
+		sum    float64
+	}
+
+	// CounterVec is a counter partitioned by labels
+	CounterVec struct{ family *family }
+	// GaugeVec is a gauge partitioned by labels
+	GaugeVec struct{ family *family }
+	// HistogramVec is a histogram partitioned by labels
+	HistogramVec struct{ family *family }
+
+	// Counter is a value which only goes up
+	Counter struct {
+		family *family
+		series *series
+	}
+	// Gauge is a value which goes up and down
+	Gauge struct {
+		family *family
+		series *series
+	}
+	// Histogram counts observations in buckets
+	Histogram struct {
+		family *family
+		series *series
+	}
+)
+
+const (
+	KindCounter   Kind = "counter"
+	KindGauge     Kind = "gauge"
+	KindHistogram Kind = "histogram"
+)
+
+// DefBuckets are latency buckets in seconds, from 5ms to 10s
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var defaultRegistry = NewRegistry()
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// Default returns the registry shared by the application
+func Default() *Registry {
+	return defaultRegistry
+}
+
+// Counter registers a counter family, or returns the registered one with the same name
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{family: r.register(name, help, KindCounter, nil, labels)}
+}
+
+// Gauge registers a gauge family, or returns the registered one with the same name
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{family: r.register(name, help, KindGauge, nil, labels)}
+}
+
+// Histogram registers a histogram family with upper bounds buckets, DefBuckets when nil,
+// or returns the registered one with the same name
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return &HistogramVec{family: r.register(name, help, KindHistogram, sorted, labels)}
+}
+
+// GaugeFunc registers a gauge without labels whose value is computed by f on every render
+func (r *Registry) GaugeFunc(name, help string, f func() float64) {
+	r.register(name, help, KindGauge, nil, nil).collect = f
+}
+
+// register adds a family, registering an existing name again with another kind or labels panics
+func (r *Registry) register(name, help string, kind Kind, buckets []float64, labels []string) *family {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.kind != kind || strings.Join(f.labels, ",") != strings.Join(labels, ",") {
+			panic(fmt.Sprintf("metrics: %s is already registered as a %s with labels %v", name, f.kind, f.labels))
+		}
+		return f
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.families[name] = f
+	return f
+}
+
+// with returns the series of labelValues, creating it on first use
+func (f *family) with(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.kind == KindHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// With returns the counter of labelValues, given in the order of the labels
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	return &Counter{family: v.family, series: v.family.with(labelValues)}
+}
+
+// With returns the gauge of labelValues, given in the order of the labels
+func (v *GaugeVec) With(labelValues ...string) *Gauge {
+	return &Gauge{family: v.family, series: v.family.with(labelValues)}
+}
+
+// With returns the histogram of labelValues, given in the order of the labels
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	return &Histogram{family: v.family, series: v.family.with(labelValues)}
+}
+
+// Inc adds one to the counter
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds delta to the counter, negative deltas are ignored
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		return
+	}
+	c.family.lock.Lock()
+	c.series.value += delta
+	c.family.lock.Unlock()
+}
+
+// Set sets the gauge to value
+func (g *Gauge) Set(value float64) {
+	g.family.lock.Lock()
+	g.series.value = value
+	g.family.lock.Unlock()
+}
+
+// Add adds delta to the gauge
+func (g *Gauge) Add(delta float64) {
+	g.family.lock.Lock()
+	g.series.value += delta
+	g.family.lock.Unlock()
+}
+
+// Inc adds one to the gauge
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+// Dec subtracts one from the gauge
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+// Observe adds value to the histogram
+func (h *Histogram) Observe(value float64) {
+	h.family.lock.Lock()
+	defer h.family.lock.Unlock()
+	for i, upperBound := range h.family.buckets {
+		if value <= upperBound {
+			h.series.counts[i]++
+		}
+	}
+	h.series.count++
+	h.series.sum += value
+}
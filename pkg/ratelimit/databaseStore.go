@@ -0,0 +1,168 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/amupxm/pure-webserver/pkg/database"
+	"github.com/amupxm/pure-webserver/pkg/logger"
+)
+
+type (
+	// Record is the persisted state of a key
+	Record struct {
+		database.DbModel
+		Key   string `json:"key"`
+		State State  `json:"state"`
+	}
+
+	// DatabaseStore counts in memory and writes changed states to a database collection in batches,
+	// so limits survive restarts without a database write per request
+	DatabaseStore struct {
+		*MemoryStore
+		db   database.Database
+		lock sync.Mutex
+		// dirty are the keys changed since the last flush
+		dirty map[string]bool
+		// ids are the database ids of persisted keys
+		ids map[string]string
+	}
+)
+
+// NewDatabaseStore creates a store loading the states persisted in db, states of keys without requests
+// for idle are dropped from memory and expire from the database
+func NewDatabaseStore(ctx context.Context, db database.Database, idle time.Duration) (*DatabaseStore, error) {
+	var record *Record
+	db.SetTTL(record, idle)
+	s := &DatabaseStore{
+		MemoryStore: NewMemoryStore(idle),
+		db:          db,
+		dirty:       make(map[string]bool),
+		ids:         make(map[string]string),
+	}
+	items, err := db.GetFromCollection(ctx, record)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		var stored Record
+		b, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &stored); err != nil {
+			return nil, err
+		}
+		s.ids[stored.Key] = stored.Id
+		s.restore(stored.Key, stored.State)
+	}
+	return s, nil
+}
+
+// Update applies update to the state of key atomically, the state is written on the next flush
+func (s *DatabaseStore) Update(ctx context.Context, key string, update func(state *State)) error {
+	if err := s.MemoryStore.Update(ctx, key, update); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.dirty[key] = true
+	s.lock.Unlock()
+	return nil
+}
+
+// Flush writes the states changed since the last flush with a single database write
+func (s *DatabaseStore) Flush(ctx context.Context) error {
+	s.lock.Lock()
+	keys := make([]string, 0, len(s.dirty))
+	for key := range s.dirty {
+		keys = append(keys, key)
+	}
+	s.dirty = make(map[string]bool)
+	ids := make(map[string]string, len(keys))
+	for _, key := range keys {
+		ids[key] = s.ids[key]
+	}
+	s.lock.Unlock()
+	if len(keys) == 0 {
+		return nil
+	}
+
+	states := s.snapshot(keys)
+	var record *Record
+	var operations []database.BatchOperation
+	var written []string
+	for _, key := range keys {
+		state, ok := states[key]
+		if !ok {
+			continue
+		}
+		if ids[key] == "" {
+			operations = append(operations, database.BatchOperation{
+				Kind: database.BatchInsert,
+				Data: &Record{Key: key, State: state},
+			})
+		} else {
+			operations = append(operations, database.BatchOperation{
+				Kind: database.BatchModify,
+				Id:   ids[key],
+				Modify: func(doc map[string]interface{}) (map[string]interface{}, error) {
+					doc["state"] = state
+					return doc, nil
+				},
+			})
+		}
+		written = append(written, key)
+	}
+	results, err := s.db.ExecuteBatch(ctx, record, operations, false)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err != nil {
+		// keep the keys for the next flush
+		for _, key := range keys {
+			s.dirty[key] = true
+		}
+		return err
+	}
+	for i, result := range results {
+		key := written[i]
+		switch {
+		case errors.Is(result.Err, database.ErrNotFound):
+			// the record expired, it is inserted again on the next flush
+			delete(s.ids, key)
+			s.dirty[key] = true
+		case result.Err != nil:
+			s.dirty[key] = true
+		case len(result.Docs) == 1:
+			if id, ok := result.Docs[0]["id"].(string); ok {
+				s.ids[key] = id
+			}
+		}
+	}
+	return nil
+}
+
+// Run flushes every interval until stop is closed, then flushes one last time
+func (s *DatabaseStore) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			s.flushAndLog()
+			return
+		}
+		s.flushAndLog()
+	}
+}
+
+// flushAndLog flushes and logs a failure, counters are kept in memory until the next flush
+func (s *DatabaseStore) flushAndLog() {
+	if err := s.Flush(context.Background()); err != nil {
+		logger.Default().Warn("can not persist rate limit states", logger.Fields{"error": err})
+	}
+}
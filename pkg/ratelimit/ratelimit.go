@@ -0,0 +1,173 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Algorithm decides how requests are counted against a limit
+	Algorithm string
+
+	// Limit allows Requests per Window
+	Limit struct {
+		Requests int
+		Window   time.Duration
+	}
+
+	// Result is the decision for one request and what the client may still send
+	Result struct {
+		Allowed   bool
+		Limit     int
+		Remaining int
+		// Reset is the time until the full limit is available again
+		Reset time.Duration
+		// RetryAfter is the time until the next request is allowed, zero when allowed
+		RetryAfter time.Duration
+	}
+
+	// Limiter counts requests by key in a store
+	Limiter struct {
+		store     Store
+		algorithm Algorithm
+		now       func() time.Time
+	}
+)
+
+const (
+	// TokenBucket refills Requests tokens per Window evenly and allows bursts up to Requests
+	TokenBucket Algorithm = "token_bucket"
+	// SlidingWindow weights the count of the previous window by its overlap with the sliding one
+	SlidingWindow Algorithm = "sliding_window"
+)
+
+// ErrUnknownAlgorithm is returned for algorithms other than TokenBucket and SlidingWindow
+var ErrUnknownAlgorithm = errors.New("unknown rate limit algorithm")
+
+// NewLimiter creates a limiter keeping its counters in store
+func NewLimiter(store Store, algorithm Algorithm) (*Limiter, error) {
+	if algorithm != TokenBucket && algorithm != SlidingWindow {
+		return nil, fmt.Errorf("%w %q", ErrUnknownAlgorithm, algorithm)
+	}
+	return &Limiter{store: store, algorithm: algorithm, now: time.Now}, nil
+}
+
+// ParseLimit parses a limit like "100/1m", requests per window
+func ParseLimit(s string) (Limit, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return Limit{}, fmt.Errorf("rate limit %q must look like \"100/1m\"", s)
+	}
+	requests, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || requests < 1 {
+		return Limit{}, fmt.Errorf("rate limit %q must allow at least one request", s)
+	}
+	window, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil || window <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q must have a positive window like \"1m\"", s)
+	}
+	return Limit{Requests: requests, Window: window}, nil
+}
+
+// String formats the limit like "100/1m0s"
+func (l Limit) String() string {
+	return strconv.Itoa(l.Requests) + "/" + l.Window.String()
+}
+
+// Allow counts one request of key against limit
+func (l *Limiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	var result Result
+	now := l.now()
+	err := l.store.Update(ctx, key, func(state *State) {
+		if l.algorithm == TokenBucket {
+			result = tokenBucket(state, limit, now)
+		} else {
+			result = slidingWindow(state, limit, now)
+		}
+	})
+	return result, err
+}
+
+// tokenBucket takes a token from a bucket of limit.Requests tokens refilled over limit.Window
+func tokenBucket(state *State, limit Limit, now time.Time) Result {
+	capacity := float64(limit.Requests)
+	perSecond := capacity / limit.Window.Seconds()
+	if state.Last.IsZero() {
+		state.Tokens = capacity
+	} else if elapsed := now.Sub(state.Last).Seconds(); elapsed > 0 {
+		state.Tokens = math.Min(capacity, state.Tokens+elapsed*perSecond)
+	}
+	state.Tokens = math.Min(state.Tokens, capacity)
+	state.Last = now
+
+	result := Result{Limit: limit.Requests}
+	if state.Tokens >= 1 {
+		state.Tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - state.Tokens) / perSecond)
+	}
+	result.Remaining = int(state.Tokens)
+	result.Reset = seconds((capacity - state.Tokens) / perSecond)
+	return result
+}
+
+// slidingWindow counts requests of the current fixed window and weights the previous one
+// by how much of it the sliding window still covers
+func slidingWindow(state *State, limit Limit, now time.Time) Result {
+	start := now.Truncate(limit.Window)
+	switch {
+	case state.Start.Equal(start):
+	case state.Start.Add(limit.Window).Equal(start):
+		state.Start, state.PreviousCount, state.Count = start, state.Count, 0
+	default:
+		state.Start, state.PreviousCount, state.Count = start, 0, 0
+	}
+	state.Last = now
+
+	elapsed := now.Sub(start)
+	previousWeight := 1 - float64(elapsed)/float64(limit.Window)
+	estimate := float64(state.PreviousCount)*previousWeight + float64(state.Count)
+	result := Result{Limit: limit.Requests}
+	if estimate+1 <= float64(limit.Requests) {
+		state.Count++
+		result.Allowed = true
+		result.Remaining = int(float64(limit.Requests) - estimate - 1)
+		result.Reset = slidingReset(state, limit.Window, elapsed)
+		return result
+	}
+	result.Reset = slidingReset(state, limit.Window, elapsed)
+	// wait until the previous window weighs little enough, or for the next window when the current one is full
+	free := float64(limit.Requests - 1 - state.Count)
+	if free >= 0 && state.PreviousCount > 0 {
+		result.RetryAfter = time.Duration(float64(limit.Window) * (previousWeight - free/float64(state.PreviousCount)))
+	} else {
+		result.RetryAfter = limit.Window - elapsed
+	}
+	if result.RetryAfter < 0 {
+		result.RetryAfter = 0
+	}
+	return result
+}
+
+// slidingReset returns the time until no counted request weighs on the sliding window anymore,
+// requests of the current window still count during the whole next one
+func slidingReset(state *State, window, elapsed time.Duration) time.Duration {
+	switch {
+	case state.Count > 0:
+		return 2*window - elapsed
+	case state.PreviousCount > 0:
+		return window - elapsed
+	}
+	return 0
+}
+
+// seconds converts fractional seconds to a duration
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
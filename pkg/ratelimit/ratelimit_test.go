@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testLimiter returns a limiter over a memory store whose clock is moved by advance
+func testLimiter(t *testing.T, algorithm Algorithm) (limiter *Limiter, advance func(d time.Duration)) {
+	t.Helper()
+	limiter, err := NewLimiter(NewMemoryStore(time.Hour), algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+	return limiter, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestTokenBucket(t *testing.T) {
+	limit := Limit{Requests: 3, Window: 3 * time.Second}
+	// every step waits before it sends a request, a token is refilled every second
+	tests := []struct {
+		wait       time.Duration
+		allowed    bool
+		remaining  int
+		reset      time.Duration
+		retryAfter time.Duration
+	}{
+		{0, true, 2, time.Second, 0},
+		{0, true, 1, 2 * time.Second, 0},
+		{0, true, 0, 3 * time.Second, 0},
+		{0, false, 0, 3 * time.Second, time.Second},
+		{500 * time.Millisecond, false, 0, 2500 * time.Millisecond, 500 * time.Millisecond},
+		{500 * time.Millisecond, true, 0, 3 * time.Second, 0},
+		{10 * time.Second, true, 2, time.Second, 0},
+	}
+	limiter, advance := testLimiter(t, TokenBucket)
+	for i, test := range tests {
+		advance(test.wait)
+		result, err := limiter.Allow(context.Background(), "client", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Result{Allowed: test.allowed, Limit: 3, Remaining: test.remaining, Reset: test.reset, RetryAfter: test.retryAfter}
+		if result != want {
+			t.Errorf("request %d: result %+v, want %+v", i, result, want)
+		}
+	}
+}
+
+func TestTokenBucketKeysAreIndependent(t *testing.T) {
+	limiter, _ := testLimiter(t, TokenBucket)
+	limit := Limit{Requests: 1, Window: time.Minute}
+	for _, key := range []string{"a", "b"} {
+		if result, _ := limiter.Allow(context.Background(), key, limit); !result.Allowed {
+			t.Errorf("first request of %s was limited", key)
+		}
+	}
+	if result, _ := limiter.Allow(context.Background(), "a", limit); result.Allowed {
+		t.Error("second request of a was allowed")
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	limiter, advance := testLimiter(t, SlidingWindow)
+	limit := Limit{Requests: 2, Window: time.Minute}
+	allow := func() Result {
+		result, err := limiter.Allow(context.Background(), "client", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	if !allow().Allowed || !allow().Allowed {
+		t.Fatal("requests within the limit were limited")
+	}
+	if result := allow(); result.Allowed || result.RetryAfter != time.Minute {
+		t.Errorf("third request: %+v", result)
+	}
+	// half of the previous window still weighs on the sliding one, as one request
+	advance(90 * time.Second)
+	if result := allow(); !result.Allowed || result.Remaining != 0 {
+		t.Errorf("request in the next window: %+v", result)
+	}
+	if result := allow(); result.Allowed || result.RetryAfter != 30*time.Second {
+		t.Errorf("request over the weighted limit: %+v", result)
+	}
+	advance(30 * time.Second)
+	if result := allow(); !result.Allowed {
+		t.Errorf("request after the previous window passed: %+v", result)
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		text  string
+		limit Limit
+		valid bool
+	}{
+		{"100/1m", Limit{100, time.Minute}, true},
+		{" 5 / 1s ", Limit{5, time.Second}, true},
+		{"100", Limit{}, false},
+		{"0/1m", Limit{}, false},
+		{"10/0s", Limit{}, false},
+		{"10/minute", Limit{}, false},
+	}
+	for _, test := range tests {
+		limit, err := ParseLimit(test.text)
+		if (err == nil) != test.valid || limit != test.limit {
+			t.Errorf("ParseLimit(%q) = %v, %v", test.text, limit, err)
+		}
+	}
+}
+
+func TestNewLimiterUnknownAlgorithm(t *testing.T) {
+	if _, err := NewLimiter(NewMemoryStore(time.Minute), "leaky_bucket"); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Errorf("error is %v, want %v", err, ErrUnknownAlgorithm)
+	}
+}
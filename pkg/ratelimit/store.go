@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type (
+	// State is the counter of one key, its fields are used by the algorithm of the limiter
+	State struct {
+		// Tokens left in the bucket of TokenBucket
+		Tokens float64 `json:"tokens"`
+		// Start of the current window of SlidingWindow
+		Start time.Time `json:"start"`
+		// Count of requests in the current window of SlidingWindow
+		Count int `json:"count"`
+		// PreviousCount of requests in the previous window of SlidingWindow
+		PreviousCount int `json:"previous_count"`
+		// Last is the time of the last request
+		Last time.Time `json:"last"`
+	}
+
+	// Store keeps the states of keys
+	Store interface {
+		// Update applies update to the state of key atomically, a new key starts with a zero state
+		Update(ctx context.Context, key string, update func(state *State)) error
+	}
+
+	// MemoryStore keeps states in memory, they are lost on restart
+	MemoryStore struct {
+		lock   sync.Mutex
+		states map[string]*State
+		// idle is the time after which the state of a key without requests is dropped
+		idle      time.Duration
+		lastSweep time.Time
+	}
+)
+
+// NewMemoryStore creates a store dropping the states of keys without requests for idle,
+// idle should be at least the longest window of the limits
+func NewMemoryStore(idle time.Duration) *MemoryStore {
+	return &MemoryStore{states: make(map[string]*State), idle: idle, lastSweep: time.Now()}
+}
+
+// Update applies update to the state of key atomically
+func (s *MemoryStore) Update(ctx context.Context, key string, update func(state *State)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sweep()
+	state, ok := s.states[key]
+	if !ok {
+		state = &State{}
+		s.states[key] = state
+	}
+	update(state)
+	return nil
+}
+
+// sweep drops idle states at most once per idle period, the caller must hold the lock
+func (s *MemoryStore) sweep() {
+	now := time.Now()
+	if s.idle <= 0 || now.Sub(s.lastSweep) < s.idle {
+		return
+	}
+	s.lastSweep = now
+	for key, state := range s.states {
+		if now.Sub(state.Last) > s.idle {
+			delete(s.states, key)
+		}
+	}
+}
+
+// snapshot returns a copy of the states of keys
+func (s *MemoryStore) snapshot(keys []string) map[string]State {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	states := make(map[string]State, len(keys))
+	for _, key := range keys {
+		if state, ok := s.states[key]; ok {
+			states[key] = *state
+		}
+	}
+	return states
+}
+
+// restore sets the state of key unless it already has one
+func (s *MemoryStore) restore(key string, state State) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.states[key]; !ok {
+		s.states[key] = &state
+	}
+}
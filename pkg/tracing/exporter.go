@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+type (
+	// Exporter sends ended spans to a backend
+	Exporter interface {
+		// Export sends a batch of spans
+		Export(ctx context.Context, spans []*SpanData) error
+		// Shutdown releases the resources of the exporter
+		Shutdown(ctx context.Context) error
+	}
+
+	// FileExporter appends spans to a file, one json object per line
+	FileExporter struct {
+		lock sync.Mutex
+		file *os.File
+	}
+)
+
+// NewFileExporter creates an exporter appending to path, the file is created when missing
+func NewFileExporter(path string) (*FileExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{file: file}, nil
+}
+
+// Export appends every span as a json line, the batch is written with a single write
+func (e *FileExporter) Export(_ context.Context, spans []*SpanData) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	w := bufio.NewWriter(e.file)
+	encoder := json.NewEncoder(w)
+	for _, span := range spans {
+		if err := encoder.Encode(span); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// Shutdown closes the file
+func (e *FileExporter) Shutdown(context.Context) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.file.Close()
+}
@@ -0,0 +1,154 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubCollector records the export requests posted to it
+type stubCollector struct {
+	lock     sync.Mutex
+	requests []otlpRequest
+	headers  []http.Header
+	status   int
+}
+
+func (c *stubCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request otlpRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.requests = append(c.requests, request)
+	c.headers = append(c.headers, r.Header.Clone())
+	if c.status != 0 {
+		http.Error(w, "collector is full", c.status)
+	}
+}
+
+// received returns the spans of every export request so far, one slice per request
+func (c *stubCollector) received() [][]otlpSpan {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var batches [][]otlpSpan
+	for _, request := range c.requests {
+		batches = append(batches, request.ResourceSpans[0].ScopeSpans[0].Spans)
+	}
+	return batches
+}
+
+func TestOTLPExporterPayload(t *testing.T) {
+	collector := &stubCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+	exporter := NewOTLPExporter(server.URL, "toys")
+	exporter.SetHeader("Authorization", "Bearer token")
+	tracer := NewTracer(exporter, WithBatch(10, time.Hour))
+
+	ctx, parent := tracer.Start(context.Background(), "GET /v1/toys", KindServer)
+	_, child := tracer.Start(ctx, "database read", KindInternal)
+	child.SetAttribute("db.items", 3)
+	child.SetAttribute("db.cached", true)
+	child.SetAttribute("db.collection", "products")
+	child.SetAttribute("db.ratio", 0.5)
+	child.SetStatus(StatusError, "disk is gone")
+	child.End()
+	parent.End()
+	if err := tracer.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	collector.lock.Lock()
+	if len(collector.requests) != 1 {
+		t.Fatalf("collector received %d requests, want 1", len(collector.requests))
+	}
+	request, header := collector.requests[0], collector.headers[0]
+	collector.lock.Unlock()
+	if header.Get("Authorization") != "Bearer token" || header.Get("Content-Type") != "application/json" {
+		t.Errorf("export headers are %v", header)
+	}
+	resource := request.ResourceSpans[0]
+	if service := resource.Resource.Attributes[0]; service.Key != "service.name" || *service.Value.StringValue != "toys" {
+		t.Errorf("resource attributes are %+v", resource.Resource.Attributes)
+	}
+	if resource.ScopeSpans[0].Scope.Name != instrumentationScope {
+		t.Errorf("scope is %q", resource.ScopeSpans[0].Scope.Name)
+	}
+	spans := resource.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+	exportedChild, exportedParent := spans[0], spans[1]
+	if exportedParent.Kind != 2 || exportedParent.ParentSpanID != "" || exportedParent.SpanID != parent.SpanContext().SpanID.String() {
+		t.Errorf("parent span is %+v", exportedParent)
+	}
+	if exportedChild.Kind != 1 || exportedChild.TraceID != exportedParent.TraceID || exportedChild.ParentSpanID != exportedParent.SpanID {
+		t.Errorf("child span is %+v", exportedChild)
+	}
+	if exportedChild.Status != (otlpStatus{Code: 2, Message: "disk is gone"}) || exportedChild.StartTimeUnixNano == "" || exportedChild.EndTimeUnixNano == "" {
+		t.Errorf("child span is %+v", exportedChild)
+	}
+	attributes := exportedChild.Attributes
+	if len(attributes) != 4 ||
+		attributes[0].Key != "db.cached" || !*attributes[0].Value.BoolValue ||
+		attributes[1].Key != "db.collection" || *attributes[1].Value.StringValue != "products" ||
+		attributes[2].Key != "db.items" || *attributes[2].Value.IntValue != "3" ||
+		attributes[3].Key != "db.ratio" || *attributes[3].Value.DoubleValue != 0.5 {
+		t.Errorf("child attributes are %+v", attributes)
+	}
+}
+
+func TestTracerBatchesAndFlushesOnShutdown(t *testing.T) {
+	collector := &stubCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+	tracer := NewTracer(NewOTLPExporter(server.URL, "toys"), WithBatch(2, time.Hour))
+
+	for _, name := range []string{"first", "second", "third"} {
+		_, span := tracer.Start(context.Background(), name, KindInternal)
+		span.End()
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(collector.received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if batches := collector.received(); len(batches) != 1 || len(batches[0]) != 2 {
+		t.Fatalf("before shutdown the collector received %v, want one full batch", batches)
+	}
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	batches := collector.received()
+	if len(batches) != 2 || len(batches[1]) != 1 || batches[1][0].Name != "third" {
+		t.Fatalf("after shutdown the collector received %v, want the last span in a second batch", batches)
+	}
+
+	_, late := tracer.Start(context.Background(), "late", KindInternal)
+	late.End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(collector.received()); got != 2 {
+		t.Errorf("a span ended after shutdown was exported, %d requests", got)
+	}
+}
+
+func TestOTLPExporterCollectorError(t *testing.T) {
+	server := httptest.NewServer(&stubCollector{status: http.StatusServiceUnavailable})
+	defer server.Close()
+	err := NewOTLPExporter(server.URL, "toys").Export(context.Background(), []*SpanData{{Name: "span"}})
+	if err == nil {
+		t.Fatal("an export rejected by the collector succeeded")
+	}
+	if want := "collector answered 503 Service Unavailable: collector is full"; err.Error() != want {
+		t.Errorf("error is %q, want %q", err, want)
+	}
+}
@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	// TraceparentHeader carries the trace id, parent span id and flags of W3C Trace Context
+	TraceparentHeader = "traceparent"
+	// TracestateHeader carries vendor specific trace data of W3C Trace Context
+	TracestateHeader = "tracestate"
+
+	// maxTracestateMembers is the number of list members kept from tracestate
+	maxTracestateMembers = 32
+	// sampledFlag is the trace flag telling that the caller records the trace
+	sampledFlag = 0x01
+)
+
+// ErrInvalidTraceparent is returned for traceparent headers which do not follow W3C Trace Context
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// Extract returns the span context of the traceparent and tracestate headers,
+// tracestate is ignored when traceparent is missing or invalid
+func Extract(header http.Header) (SpanContext, error) {
+	sc, err := ParseTraceparent(header.Get(TraceparentHeader))
+	if err != nil {
+		return SpanContext{}, err
+	}
+	sc.TraceState = normalizeTracestate(header.Values(TracestateHeader))
+	sc.Remote = true
+	return sc, nil
+}
+
+// Inject sets the traceparent and tracestate headers of sc
+func Inject(header http.Header, sc SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+	header.Set(TraceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		header.Set(TracestateHeader, sc.TraceState)
+	} else {
+		header.Del(TracestateHeader)
+	}
+}
+
+// ParseTraceparent parses a header like "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+// versions above 00 are read as 00 as the specification asks
+func ParseTraceparent(value string) (SpanContext, error) {
+	value = strings.TrimSpace(value)
+	if len(value) < 55 {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	version, ok := decodeHex(value[0:2])
+	if !ok || version[0] == 0xff || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	if version[0] == 0 && len(value) != 55 || version[0] != 0 && len(value) > 55 && value[55] != '-' {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	traceID, ok := decodeHex(value[3:35])
+	if !ok {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	spanID, ok := decodeHex(value[36:52])
+	if !ok {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	flags, ok := decodeHex(value[53:55])
+	if !ok {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	var sc SpanContext
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&sampledFlag != 0
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	return sc, nil
+}
+
+// Traceparent formats the span context as a version 00 traceparent header
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// decodeHex decodes lowercase hex, uppercase is invalid in trace context headers
+func decodeHex(s string) ([]byte, bool) {
+	if strings.ToLower(s) != s {
+		return nil, false
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
+
+// normalizeTracestate joins tracestate headers, drops empty and malformed members
+// and keeps the first 32, the first member of a key wins
+func normalizeTracestate(values []string) string {
+	var members []string
+	seen := map[string]bool{}
+	for _, value := range values {
+		for _, member := range strings.Split(value, ",") {
+			member = strings.TrimSpace(member)
+			i := strings.Index(member, "=")
+			if i <= 0 || i == len(member)-1 {
+				continue
+			}
+			key := member[:i]
+			if strings.ContainsAny(key, " \t") || seen[key] || len(members) == maxTracestateMembers {
+				continue
+			}
+			seen[key] = true
+			members = append(members, member)
+		}
+	}
+	return strings.Join(members, ",")
+}
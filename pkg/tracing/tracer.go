@@ -0,0 +1,224 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/amupxm/pure-webserver/pkg/logger"
+)
+
+type (
+	// Tracer starts spans and sends the ended ones to its exporter in batches
+	Tracer struct {
+		exporter    Exporter
+		sampleRatio float64
+		batchSize   int
+		interval    time.Duration
+		queue       chan *SpanData
+		flush       chan chan struct{}
+		stop        chan struct{}
+		stopOnce    sync.Once
+		done        chan struct{}
+	}
+	// Option configures a tracer created by NewTracer
+	Option func(t *Tracer)
+)
+
+const (
+	// DefaultBatchSize is the number of spans exported at once
+	DefaultBatchSize = 256
+	// DefaultInterval is the longest time an ended span waits before it is exported
+	DefaultInterval = 2 * time.Second
+	// queueSize bounds the spans waiting for export, more are dropped
+	queueSize = 4096
+)
+
+var (
+	defaultLock   sync.RWMutex
+	defaultTracer = NewTracer(nil)
+)
+
+// NewTracer creates a tracer exporting to exporter, a nil exporter records nothing
+// but still propagates incoming trace contexts
+func NewTracer(exporter Exporter, options ...Option) *Tracer {
+	t := &Tracer{
+		exporter:    exporter,
+		sampleRatio: 1,
+		batchSize:   DefaultBatchSize,
+		interval:    DefaultInterval,
+		queue:       make(chan *SpanData, queueSize),
+		flush:       make(chan chan struct{}),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	for _, option := range options {
+		option(t)
+	}
+	if exporter == nil {
+		close(t.done)
+		return t
+	}
+	go t.run()
+	return t
+}
+
+// WithSampleRatio sets the share of new traces which are recorded, between 0 and 1,
+// traces started by other services follow their sampled flag
+func WithSampleRatio(ratio float64) Option {
+	return func(t *Tracer) {
+		t.sampleRatio = ratio
+	}
+}
+
+// WithBatch sets how many spans are exported at once and how long ended spans wait at most
+func WithBatch(size int, interval time.Duration) Option {
+	return func(t *Tracer) {
+		if size > 0 {
+			t.batchSize = size
+		}
+		if interval > 0 {
+			t.interval = interval
+		}
+	}
+}
+
+// Default returns the tracer used across packages
+func Default() *Tracer {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+	return defaultTracer
+}
+
+// SetDefault replaces the tracer used across packages
+func SetDefault(t *Tracer) {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	defaultTracer = t
+}
+
+// Start starts a span of kind as a child of the span or remote span context in ctx,
+// without a parent a new trace is started. The returned context carries the new span
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent, hasParent := SpanContextFromContext(ctx)
+	if t.exporter == nil && !hasParent {
+		return ctx, nil
+	}
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:   name,
+			Kind:   kind,
+			Start:  time.Now(),
+			Status: StatusUnset,
+		},
+	}
+	if hasParent && parent.IsValid() {
+		span.sc = SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled, TraceState: parent.TraceState}
+		span.data.ParentSpanID = parent.SpanID
+	} else {
+		span.sc.TraceID = newTraceID()
+		span.sc.Sampled = sampledByRatio(span.sc.TraceID, t.sampleRatio)
+	}
+	span.sc.SpanID = newSpanID()
+	if t.exporter == nil {
+		span.sc.Sampled = false
+	}
+	span.data.TraceID, span.data.SpanID, span.data.TraceState = span.sc.TraceID, span.sc.SpanID, span.sc.TraceState
+	return ContextWithSpan(ctx, span), span
+}
+
+// export queues an ended span, it is dropped when the queue is full
+func (t *Tracer) export(data *SpanData) {
+	if t.exporter == nil {
+		return
+	}
+	select {
+	case t.queue <- data:
+	default:
+	}
+}
+
+// run exports queued spans when a batch is full, the interval passed or a flush is requested
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	batch := make([]*SpanData, 0, t.batchSize)
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.Export(context.Background(), batch); err != nil {
+			logger.Default().Warn("can not export spans", logger.Fields{"error": err, "spans": len(batch)})
+		}
+		batch = make([]*SpanData, 0, t.batchSize)
+	}
+	drain := func() {
+		for {
+			select {
+			case data := <-t.queue:
+				batch = append(batch, data)
+				if len(batch) >= t.batchSize {
+					send()
+				}
+			default:
+				send()
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) >= t.batchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case flushed := <-t.flush:
+			drain()
+			close(flushed)
+		case <-t.stop:
+			drain()
+			return
+		}
+	}
+}
+
+// Flush exports every ended span, it returns when they are exported or ctx is done
+func (t *Tracer) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case t.flush <- flushed:
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown exports every ended span and closes the exporter, spans ended later are dropped
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.stopOnce.Do(func() {
+		if t.exporter != nil {
+			close(t.stop)
+		}
+	})
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if t.exporter == nil {
+		return nil
+	}
+	return t.exporter.Shutdown(ctx)
+}
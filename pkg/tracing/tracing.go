@@ -0,0 +1,242 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+type (
+	// TraceID identifies a trace across services
+	TraceID [16]byte
+	// SpanID identifies a span inside a trace
+	SpanID [8]byte
+	// SpanKind tells whether a span serves a request, calls another service or is internal
+	SpanKind string
+	// StatusCode is the outcome of a span
+	StatusCode string
+
+	// SpanContext is the part of a span propagated to other services
+	SpanContext struct {
+		TraceID    TraceID
+		SpanID     SpanID
+		Sampled    bool
+		TraceState string
+		// Remote is set for span contexts extracted from incoming requests
+		Remote bool
+	}
+
+	// SpanData is what exporters receive for an ended span
+	SpanData struct {
+		TraceID       TraceID                `json:"trace_id"`
+		SpanID        SpanID                 `json:"span_id"`
+		ParentSpanID  SpanID                 `json:"parent_span_id"`
+		TraceState    string                 `json:"trace_state,omitempty"`
+		Name          string                 `json:"name"`
+		Kind          SpanKind               `json:"kind"`
+		Start         time.Time              `json:"start"`
+		End           time.Time              `json:"end"`
+		Attributes    map[string]interface{} `json:"attributes,omitempty"`
+		Status        StatusCode             `json:"status"`
+		StatusMessage string                 `json:"status_message,omitempty"`
+	}
+
+	// Span is an operation of a trace, a nil span records nothing
+	Span struct {
+		tracer *Tracer
+		lock   sync.Mutex
+		sc     SpanContext
+		data   SpanData
+		ended  bool
+	}
+
+	// spanKey is the context key of the current span
+	spanKey struct{}
+	// remoteKey is the context key of a span context extracted from a request
+	remoteKey struct{}
+)
+
+const (
+	KindInternal SpanKind = "internal"
+	KindServer   SpanKind = "server"
+	KindClient   SpanKind = "client"
+)
+
+const (
+	StatusUnset StatusCode = "unset"
+	StatusOK    StatusCode = "ok"
+	StatusError StatusCode = "error"
+)
+
+// String returns the trace id as 32 lowercase hex digits
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether the trace id is not all zeros
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// MarshalText encodes the trace id as hex
+func (id TraceID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// String returns the span id as 16 lowercase hex digits
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether the span id is not all zeros
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// MarshalText encodes the span id as hex, empty for the zero id
+func (id SpanID) MarshalText() ([]byte, error) {
+	if !id.IsValid() {
+		return []byte{}, nil
+	}
+	return []byte(id.String()), nil
+}
+
+// IsValid reports whether both ids of the span context are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Start starts a span as a child of the span in ctx, using its tracer or the default tracer
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	tracer := Default()
+	if parent := SpanFromContext(ctx); parent != nil {
+		tracer = parent.tracer
+	}
+	return tracer.Start(ctx, name, KindInternal)
+}
+
+// SpanFromContext returns the current span of ctx, nil when there is none
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithSpan returns a context whose current span is span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// ContextWithRemoteSpanContext returns a context whose next span continues the trace of a remote service
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	sc.Remote = true
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context of the current span, or the remote one
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext(), true
+	}
+	sc, ok := ctx.Value(remoteKey{}).(SpanContext)
+	return sc, ok
+}
+
+// SpanContext returns the ids of the span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetName renames the span, like a server span once the route is known
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Name = name
+}
+
+// SetAttribute attaches a key value pair to the span, values should be strings, numbers or bools
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]interface{})
+	}
+	s.data.Attributes[key] = value
+}
+
+// SetStatus sets the outcome of the span
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Status, s.data.StatusMessage = code, message
+}
+
+// RecordError marks the span as failed with err, a nil err is ignored
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.SetStatus(StatusError, err.Error())
+}
+
+// End finishes the span and hands it to the exporter of its tracer, ending twice has no effect
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.lock.Unlock()
+	if s.sc.Sampled {
+		s.tracer.export(&data)
+	}
+}
+
+// newTraceID returns a random trace id
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+// newSpanID returns a random span id
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+// sampledByRatio decides the sampling of a new trace from its id, so every service agrees
+func sampledByRatio(id TraceID, ratio float64) bool {
+	if ratio >= 1 {
+		return true
+	}
+	if ratio <= 0 {
+		return false
+	}
+	return binary.BigEndian.Uint64(id[8:]) < uint64(ratio*(1<<63))*2
+}
@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrBadHandshake is returned by Dial when the server does not accept the handshake
+var ErrBadHandshake = errors.New("websocket handshake failed")
+
+// Dial opens a client connection to a ws:// or wss:// url, header is added to the handshake request
+// (like Origin or Authorization). The response of the server is returned even when the handshake fails
+func Dial(ctx context.Context, rawURL string, header http.Header, options Options) (*Conn, *http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	secure := false
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+		secure = true
+	default:
+		return nil, nil, errors.New("websocket url must start with ws:// or wss://")
+	}
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), map[bool]string{false: "80", true: "443"}[secure])
+	}
+	var conn net.Conn
+	if secure {
+		dialer := &tls.Dialer{Config: &tls.Config{ServerName: u.Hostname()}}
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// the handshake is bound by ctx, the connection is not
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	request, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Sec-WebSocket-Key", key)
+	request.Header.Set("Sec-WebSocket-Version", "13")
+	if len(options.Subprotocols) > 0 {
+		request.Header.Set("Sec-WebSocket-Protocol", strings.Join(options.Subprotocols, ", "))
+	}
+	if err := request.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols ||
+		!headerHasToken(response.Header, "Upgrade", "websocket") ||
+		!headerHasToken(response.Header, "Connection", "upgrade") ||
+		response.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, response, ErrBadHandshake
+	}
+	if !stop() {
+		// ctx was done while the handshake completed
+		return nil, response, ctx.Err()
+	}
+	return newConn(conn, reader, options, true, response.Header.Get("Sec-WebSocket-Protocol")), response, nil
+}
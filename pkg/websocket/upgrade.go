@@ -0,0 +1,128 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HandshakeError is returned by Upgrade for requests which are not valid websocket handshakes,
+// Upgrade already answered them with Status
+type HandshakeError struct {
+	Status int
+	Reason string
+}
+
+// acceptGUID is appended to the key of a handshake to compute Sec-WebSocket-Accept
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Upgrade answers a websocket handshake and takes over the connection of the request,
+// nothing may be written to w afterwards. Invalid handshakes are answered with an error status
+func Upgrade(w http.ResponseWriter, r *http.Request, options Options) (*Conn, error) {
+	if r.Method != http.MethodGet {
+		return nil, handshakeError(w, http.StatusMethodNotAllowed, "websocket handshakes must use GET")
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		return nil, handshakeError(w, http.StatusBadRequest, "request is not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, handshakeError(w, http.StatusUpgradeRequired, "unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, handshakeError(w, http.StatusBadRequest, "invalid Sec-WebSocket-Key")
+	}
+	checkOrigin := options.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = SameOrigin
+	}
+	if !checkOrigin(r.Header.Get("Origin"), r.Host) {
+		return nil, handshakeError(w, http.StatusForbidden, "origin not allowed")
+	}
+	subprotocol := chooseSubprotocol(r.Header, options.Subprotocols)
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, handshakeError(w, http.StatusInternalServerError, "connection can not be upgraded: "+err.Error())
+	}
+	// the deadlines of the http server do not apply to the websocket
+	conn.SetDeadline(time.Time{})
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n"
+	if subprotocol != "" {
+		response += "Sec-WebSocket-Protocol: " + subprotocol + "\r\n"
+	}
+	if _, err := conn.Write([]byte(response + "\r\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newConn(conn, rw.Reader, options, false, subprotocol), nil
+}
+
+// SameOrigin accepts requests without an Origin, which do not come from browsers,
+// and requests whose Origin has the host of the request
+func SameOrigin(origin, host string) bool {
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, host)
+}
+
+// IsUpgrade reports whether r asks for a websocket
+func IsUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+// Error returns the reason of the failure
+func (e *HandshakeError) Error() string {
+	return e.Reason
+}
+
+// handshakeError answers the request with status and returns the matching error
+func handshakeError(w http.ResponseWriter, status int, reason string) error {
+	http.Error(w, reason, status)
+	return &HandshakeError{Status: status, Reason: reason}
+}
+
+// acceptKey returns the Sec-WebSocket-Accept of key
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerHasToken reports whether the comma separated values of header name contain token
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// chooseSubprotocol returns the first subprotocol offered in header which is supported
+func chooseSubprotocol(header http.Header, supported []string) string {
+	for _, value := range header.Values("Sec-WebSocket-Protocol") {
+		for _, offered := range strings.Split(value, ",") {
+			offered = strings.TrimSpace(offered)
+			for _, protocol := range supported {
+				if offered == protocol {
+					return protocol
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,420 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type (
+	// MessageType is the opcode of a frame
+	MessageType int
+
+	// Options configure both ends of connections
+	Options struct {
+		// MaxMessageSize limits received messages, larger ones close the connection with CloseMessageTooBig.
+		// DefaultMaxMessageSize when zero
+		MaxMessageSize int64
+		// FragmentSize splits sent messages into frames of at most this many bytes, zero sends one frame per message
+		FragmentSize int
+		// IdleTimeout closes connections receiving no frame for this long, zero disables it.
+		// Peers answering pings keep connections open
+		IdleTimeout time.Duration
+		// WriteTimeout bounds writing one frame, zero disables it
+		WriteTimeout time.Duration
+		// Subprotocols are the supported subprotocols, the first one offered by the client is chosen
+		Subprotocols []string
+		// CheckOrigin accepts or rejects the Origin of handshakes, nil accepts requests without an Origin
+		// and requests whose Origin has the host of the request
+		CheckOrigin func(origin, host string) bool
+	}
+
+	// Conn is a websocket connection. Messages may be read by one goroutine while others write
+	Conn struct {
+		conn    net.Conn
+		reader  *bufio.Reader
+		options Options
+		// client connections mask the frames they send and expect unmasked frames
+		client bool
+		// subprotocol is the negotiated subprotocol, empty when none
+		subprotocol string
+
+		writeLock sync.Mutex
+		// closeSent is set once a close frame was written, nothing can be written after it
+		closeSent bool
+	}
+
+	// CloseError is returned by ReadMessage when the peer closed the connection
+	CloseError struct {
+		Code   int
+		Reason string
+	}
+
+	// frame is one received frame
+	frame struct {
+		fin     bool
+		opcode  MessageType
+		payload []byte
+	}
+)
+
+const (
+	// ContinuationMessage continues a fragmented message
+	ContinuationMessage MessageType = 0
+	// TextMessage is an utf-8 encoded message
+	TextMessage MessageType = 1
+	// BinaryMessage is a binary message
+	BinaryMessage MessageType = 2
+	// CloseMessage closes the connection
+	CloseMessage MessageType = 8
+	// PingMessage asks the peer for a pong
+	PingMessage MessageType = 9
+	// PongMessage answers a ping
+	PongMessage MessageType = 10
+)
+
+// close codes of RFC 6455 section 7.4.1
+const (
+	CloseNormal              = 1000
+	CloseGoingAway           = 1001
+	CloseProtocolError       = 1002
+	CloseUnsupportedData     = 1003
+	CloseNoStatus            = 1005
+	CloseAbnormal            = 1006
+	CloseInvalidPayload      = 1007
+	ClosePolicyViolation     = 1008
+	CloseMessageTooBig       = 1009
+	CloseMandatoryExtension  = 1010
+	CloseInternalServerError = 1011
+	CloseServiceRestart      = 1012
+	CloseTryAgainLater       = 1013
+)
+
+// DefaultMaxMessageSize limits received messages of connections without MaxMessageSize
+const DefaultMaxMessageSize = 1 << 20
+
+// maxControlPayload is the largest payload of control frames
+const maxControlPayload = 125
+
+var (
+	// ErrProtocol is returned when the peer breaks the protocol, the connection is closed with CloseProtocolError
+	ErrProtocol = errors.New("websocket protocol error")
+	// ErrMessageTooLarge is returned for messages over the size limit, the connection is closed with CloseMessageTooBig
+	ErrMessageTooLarge = errors.New("websocket message too large")
+	// ErrInvalidUTF8 is returned for text messages which are not utf-8, the connection is closed with CloseInvalidPayload
+	ErrInvalidUTF8 = errors.New("websocket text message is not valid utf-8")
+	// ErrClosed is returned when writing after a close frame was sent
+	ErrClosed = errors.New("websocket connection is closed")
+)
+
+// newConn wraps an established connection, reader holds what was read after the handshake
+func newConn(conn net.Conn, reader *bufio.Reader, options Options, client bool, subprotocol string) *Conn {
+	if options.MaxMessageSize <= 0 {
+		options.MaxMessageSize = DefaultMaxMessageSize
+	}
+	return &Conn{conn: conn, reader: reader, options: options, client: client, subprotocol: subprotocol}
+}
+
+// Error returns the close code and reason
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return "websocket closed with code " + strconv.Itoa(e.Code)
+	}
+	return "websocket closed with code " + strconv.Itoa(e.Code) + ": " + e.Reason
+}
+
+// Subprotocol returns the negotiated subprotocol, empty when none was
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// RemoteAddr returns the address of the peer
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ReadMessage returns the next text or binary message, reassembled from its fragments.
+// Pings are answered and pongs skipped while waiting. When the peer closes the connection its close frame
+// is echoed and a *CloseError is returned, when it breaks the protocol the connection is closed with the matching code
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var messageType MessageType
+	var message []byte
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		switch f.opcode {
+		case PingMessage:
+			if err := c.writeFrame(PongMessage, f.payload, true); err != nil && err != ErrClosed {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			return 0, nil, c.closeReceived(f.payload)
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				// a new message started before the fragmented one ended
+				return 0, nil, c.fail(ErrProtocol)
+			}
+			messageType = f.opcode
+		case ContinuationMessage:
+			if messageType == 0 {
+				return 0, nil, c.fail(ErrProtocol)
+			}
+		default:
+			return 0, nil, c.fail(ErrProtocol)
+		}
+		if int64(len(message))+int64(len(f.payload)) > c.options.MaxMessageSize {
+			return 0, nil, c.fail(ErrMessageTooLarge)
+		}
+		message = append(message, f.payload...)
+		if !f.fin {
+			continue
+		}
+		if messageType == TextMessage && !utf8.Valid(message) {
+			return 0, nil, c.fail(ErrInvalidUTF8)
+		}
+		return messageType, message, nil
+	}
+}
+
+// WriteMessage sends a text or binary message, split into fragments when the connection has a FragmentSize
+func (c *Conn) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return ErrProtocol
+	}
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	size := c.options.FragmentSize
+	if size <= 0 || len(data) <= size {
+		return c.writeFrameLocked(messageType, data, true)
+	}
+	opcode := messageType
+	for len(data) > size {
+		if err := c.writeFrameLocked(opcode, data[:size], false); err != nil {
+			return err
+		}
+		data = data[size:]
+		opcode = ContinuationMessage
+	}
+	return c.writeFrameLocked(opcode, data, true)
+}
+
+// Ping sends a ping, the peer answers with a pong carrying data
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return ErrProtocol
+	}
+	return c.writeFrame(PingMessage, data, true)
+}
+
+// Close sends a close frame with code and reason unless one was sent already and closes the connection
+func (c *Conn) Close(code int, reason string) error {
+	err := c.writeFrame(CloseMessage, closePayload(code, reason), true)
+	closeErr := c.conn.Close()
+	if err != nil && err != ErrClosed {
+		return err
+	}
+	return closeErr
+}
+
+// closeReceived answers the close frame of the peer and returns its code and reason
+func (c *Conn) closeReceived(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return c.fail(ErrProtocol)
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(ErrProtocol)
+		}
+		if !utf8.ValidString(closeErr.Reason) {
+			return c.fail(ErrInvalidUTF8)
+		}
+	}
+	code := closeErr.Code
+	if code == CloseNoStatus {
+		code = CloseNormal
+	}
+	c.Close(code, "")
+	return closeErr
+}
+
+// fail closes the connection with the code matching err and returns err
+func (c *Conn) fail(err error) error {
+	code := 0
+	switch {
+	case errors.Is(err, ErrProtocol):
+		code = CloseProtocolError
+	case errors.Is(err, ErrMessageTooLarge):
+		code = CloseMessageTooBig
+	case errors.Is(err, ErrInvalidUTF8):
+		code = CloseInvalidPayload
+	}
+	if code != 0 {
+		c.Close(code, err.Error())
+	} else {
+		c.conn.Close()
+	}
+	return err
+}
+
+// readFrame reads the next frame and unmasks its payload
+func (c *Conn) readFrame() (frame, error) {
+	if c.options.IdleTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.options.IdleTimeout))
+	}
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{fin: header[0]&0x80 != 0, opcode: MessageType(header[0] & 0x0f)}
+	// no extension is negotiated, so the reserved bits must be zero
+	if header[0]&0x70 != 0 {
+		return f, ErrProtocol
+	}
+	masked := header[1]&0x80 != 0
+	if masked == c.client {
+		// clients mask every frame, servers none
+		return f, ErrProtocol
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return f, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return f, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+		if length>>63 != 0 {
+			return f, ErrProtocol
+		}
+	}
+	if f.opcode >= CloseMessage && (!f.fin || length > maxControlPayload) {
+		return f, ErrProtocol
+	}
+	if length > uint64(c.options.MaxMessageSize) {
+		return f, ErrMessageTooLarge
+	}
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, key[:]); err != nil {
+			return f, err
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, f.payload); err != nil {
+		return f, err
+	}
+	if masked {
+		mask(key, f.payload)
+	}
+	return f, nil
+}
+
+// writeFrame sends one frame
+func (c *Conn) writeFrame(opcode MessageType, payload []byte, fin bool) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.writeFrameLocked(opcode, payload, fin)
+}
+
+// writeFrameLocked sends one frame, the caller must hold the write lock
+func (c *Conn) writeFrameLocked(opcode MessageType, payload []byte, fin bool) error {
+	if c.closeSent {
+		return ErrClosed
+	}
+	if opcode == CloseMessage {
+		c.closeSent = true
+	}
+	b := make([]byte, 0, 14+len(payload))
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	b = append(b, first)
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		b = append(b, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		b = append(b, maskBit|126, byte(len(payload)>>8), byte(len(payload)))
+	default:
+		var extended [8]byte
+		binary.BigEndian.PutUint64(extended[:], uint64(len(payload)))
+		b = append(append(b, maskBit|127), extended[:]...)
+	}
+	if c.client {
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		b = append(b, key[:]...)
+		start := len(b)
+		b = append(b, payload...)
+		mask(key, b[start:])
+	} else {
+		b = append(b, payload...)
+	}
+	if c.options.WriteTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteTimeout))
+	}
+	_, err := c.conn.Write(b)
+	return err
+}
+
+// mask applies the masking key to payload, masking twice restores it
+func mask(key [4]byte, payload []byte) {
+	for i := range payload {
+		payload[i] ^= key[i%4]
+	}
+}
+
+// closePayload returns the payload of a close frame, reasons are cut to fit a control frame
+func closePayload(code int, reason string) []byte {
+	if code == CloseNoStatus || code == CloseAbnormal || code == 0 {
+		return nil
+	}
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+		// do not cut an utf-8 sequence
+		for !utf8.ValidString(reason) {
+			reason = reason[:len(reason)-1]
+		}
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return append(payload, reason...)
+}
+
+// validCloseCode reports whether code may be sent in a close frame
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
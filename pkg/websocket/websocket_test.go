@@ -0,0 +1,316 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startServer upgrades every request with options and passes the connection to handle,
+// the error handle returns is sent on the returned channel
+func startServer(t *testing.T, options Options, handle func(conn *Conn) error) (string, <-chan error) {
+	t.Helper()
+	errs := make(chan error, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r, options)
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer conn.conn.Close()
+		errs <- handle(conn)
+	}))
+	t.Cleanup(ts.Close)
+	return "ws" + strings.TrimPrefix(ts.URL, "http"), errs
+}
+
+// echo sends every message back until reading fails
+func echo(conn *Conn) error {
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if err := conn.WriteMessage(messageType, message); err != nil {
+			return err
+		}
+	}
+}
+
+// dialTest opens a client connection to url which fails reads after a few seconds
+func dialTest(t *testing.T, url string, options Options) *Conn {
+	t.Helper()
+	conn, _, err := Dial(context.Background(), url, nil, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.conn.Close() })
+	conn.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	return conn
+}
+
+// serverError returns the error the server handler ended with
+func serverError(t *testing.T, errs <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(3 * time.Second):
+		t.Fatal("the server handler did not return")
+		return nil
+	}
+}
+
+// recordingConn keeps a copy of everything written to the connection
+type recordingConn struct {
+	net.Conn
+	lock    sync.Mutex
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.lock.Lock()
+	c.written.Write(b)
+	c.lock.Unlock()
+	return c.Conn.Write(b)
+}
+
+func TestRoundTrip(t *testing.T) {
+	url, errs := startServer(t, Options{FragmentSize: 1000}, echo)
+	client := dialTest(t, url, Options{FragmentSize: 4096})
+	tests := []struct {
+		name        string
+		messageType MessageType
+		message     []byte
+	}{
+		{"empty", TextMessage, nil},
+		{"text", TextMessage, []byte("hello toys")},
+		{"largest short length", BinaryMessage, bytes.Repeat([]byte{1}, 125)},
+		{"16 bit length", BinaryMessage, bytes.Repeat([]byte{2}, 126)},
+		{"fragmented by the server", TextMessage, []byte(strings.Repeat("ü", 1500))},
+		{"fragmented by both ends with 64 bit length", BinaryMessage, bytes.Repeat([]byte{3}, 70000)},
+	}
+	for _, test := range tests {
+		if err := client.WriteMessage(test.messageType, test.message); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		messageType, message, err := client.ReadMessage()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if messageType != test.messageType || !bytes.Equal(message, test.message) {
+			t.Errorf("%s: echoed type %d with %d bytes, want type %d with %d bytes", test.name, messageType, len(message), test.messageType, len(test.message))
+		}
+	}
+	client.Close(CloseNormal, "")
+	var closeErr *CloseError
+	if err := serverError(t, errs); !errors.As(err, &closeErr) || closeErr.Code != CloseNormal {
+		t.Errorf("server ended with %v, want close code %d", err, CloseNormal)
+	}
+}
+
+func TestFragmentsOnTheWire(t *testing.T) {
+	url, _ := startServer(t, Options{}, echo)
+	client := dialTest(t, url, Options{FragmentSize: 4})
+	recorder := &recordingConn{Conn: client.conn}
+	client.conn = recorder
+	if err := client.WriteMessage(TextMessage, []byte("hello toys")); err != nil {
+		t.Fatal(err)
+	}
+	if _, message, err := client.ReadMessage(); err != nil || string(message) != "hello toys" {
+		t.Fatalf("echoed %q, %v", message, err)
+	}
+	// three masked frames of 4, 4 and 2 bytes: text, continuation, final continuation
+	written := recorder.written.Bytes()
+	want := []struct {
+		first  byte
+		length int
+	}{{0x01, 4}, {0x00, 4}, {0x80, 2}}
+	for i, frame := range want {
+		if len(written) < 2 {
+			t.Fatalf("frame %d is missing", i)
+		}
+		if written[0] != frame.first || written[1] != 0x80|byte(frame.length) {
+			t.Errorf("frame %d starts with %#x %#x, want %#x %#x", i, written[0], written[1], frame.first, 0x80|frame.length)
+		}
+		written = written[min(len(written), 2+4+frame.length):]
+	}
+	if bytes.Contains(recorder.written.Bytes(), []byte("hell")) {
+		t.Error("the client sent its payload unmasked")
+	}
+}
+
+func TestUnmaskedClientFramesAreRejected(t *testing.T) {
+	url, errs := startServer(t, Options{}, echo)
+	client := dialTest(t, url, Options{})
+	// a text frame "hi" without mask bit, like servers send them
+	client.conn.Write([]byte{0x81, 0x02, 'h', 'i'})
+	var closeErr *CloseError
+	if _, _, err := client.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != CloseProtocolError {
+		t.Errorf("client read %v, want close code %d", err, CloseProtocolError)
+	}
+	if err := serverError(t, errs); !errors.Is(err, ErrProtocol) {
+		t.Errorf("server ended with %v, want %v", err, ErrProtocol)
+	}
+}
+
+func TestPingPong(t *testing.T) {
+	url, errs := startServer(t, Options{}, func(conn *Conn) error {
+		// the server pings first and the client answers while it waits for a message
+		if err := conn.Ping([]byte("server")); err != nil {
+			return err
+		}
+		f, err := conn.readFrame()
+		if err != nil {
+			return err
+		}
+		if f.opcode != PongMessage || string(f.payload) != "server" {
+			return fmt.Errorf("the client answered with opcode %d and %q", f.opcode, f.payload)
+		}
+		if err := conn.WriteMessage(TextMessage, []byte("pong received")); err != nil {
+			return err
+		}
+		return echo(conn)
+	})
+	client := dialTest(t, url, Options{})
+	if _, message, err := client.ReadMessage(); err != nil || string(message) != "pong received" {
+		t.Fatalf("read %q, %v", message, err)
+	}
+	// the server answers the ping of the client while it waits for a message
+	if err := client.Ping([]byte("client")); err != nil {
+		t.Fatal(err)
+	}
+	f, err := client.readFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.opcode != PongMessage || string(f.payload) != "client" {
+		t.Errorf("server answered with opcode %d and %q", f.opcode, f.payload)
+	}
+	if err := client.Ping(make([]byte, maxControlPayload+1)); !errors.Is(err, ErrProtocol) {
+		t.Errorf("pinging with a large payload: %v, want %v", err, ErrProtocol)
+	}
+	client.Close(CloseGoingAway, "")
+	serverError(t, errs)
+}
+
+func TestCloseHandshake(t *testing.T) {
+	url, errs := startServer(t, Options{}, echo)
+	client := dialTest(t, url, Options{})
+	if err := client.writeFrame(CloseMessage, closePayload(CloseGoingAway, "bye"), true); err != nil {
+		t.Fatal(err)
+	}
+	// the server echoes the close frame with its code
+	f, err := client.readFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.opcode != CloseMessage || !bytes.Equal(f.payload, closePayload(CloseGoingAway, "")) {
+		t.Errorf("server answered with opcode %d and %q", f.opcode, f.payload)
+	}
+	var closeErr *CloseError
+	if err := serverError(t, errs); !errors.As(err, &closeErr) || closeErr.Code != CloseGoingAway || closeErr.Reason != "bye" {
+		t.Errorf("server ended with %v, want code %d with reason bye", err, CloseGoingAway)
+	}
+	if err := client.WriteMessage(TextMessage, []byte("late")); !errors.Is(err, ErrClosed) {
+		t.Errorf("writing after the close frame: %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	tests := []struct {
+		name         string
+		fragmentSize int
+		size         int
+		code         int
+	}{
+		{"at the limit", 0, 16, 0},
+		{"fragmented at the limit", 5, 16, 0},
+		{"one large frame", 0, 17, CloseMessageTooBig},
+		{"large in fragments", 5, 17, CloseMessageTooBig},
+	}
+	for _, test := range tests {
+		url, errs := startServer(t, Options{MaxMessageSize: 16}, echo)
+		client := dialTest(t, url, Options{FragmentSize: test.fragmentSize})
+		if err := client.WriteMessage(BinaryMessage, make([]byte, test.size)); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		_, message, err := client.ReadMessage()
+		if test.code == 0 {
+			if err != nil || len(message) != test.size {
+				t.Errorf("%s: echoed %d bytes, %v", test.name, len(message), err)
+			}
+			client.Close(CloseNormal, "")
+			serverError(t, errs)
+			continue
+		}
+		var closeErr *CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != test.code {
+			t.Errorf("%s: client read %v, want close code %d", test.name, err, test.code)
+		}
+		if err := serverError(t, errs); !errors.Is(err, ErrMessageTooLarge) {
+			t.Errorf("%s: server ended with %v, want %v", test.name, err, ErrMessageTooLarge)
+		}
+	}
+}
+
+func TestDialHandshake(t *testing.T) {
+	url, errs := startServer(t, Options{Subprotocols: []string{"toys.v2", "toys.v1"}}, echo)
+	conn, response, err := Dial(context.Background(), url, http.Header{"Origin": {"http://example.com"}}, Options{})
+	if !errors.Is(err, ErrBadHandshake) || response == nil || response.StatusCode != http.StatusForbidden {
+		t.Errorf("dialing from another origin: %v", err)
+	}
+	var handshakeErr *HandshakeError
+	if err := serverError(t, errs); !errors.As(err, &handshakeErr) || handshakeErr.Status != http.StatusForbidden {
+		t.Errorf("server ended with %v, want status %d", err, http.StatusForbidden)
+	}
+
+	conn, _, err = Dial(context.Background(), url, nil, Options{Subprotocols: []string{"toys.v1", "toys.v2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(CloseNormal, "")
+	// the first offered protocol the server supports is chosen
+	if conn.Subprotocol() != "toys.v1" {
+		t.Errorf("negotiated subprotocol %q, want toys.v1", conn.Subprotocol())
+	}
+
+	plain := httptest.NewServer(http.NotFoundHandler())
+	defer plain.Close()
+	if _, response, err := Dial(context.Background(), "ws"+strings.TrimPrefix(plain.URL, "http"), nil, Options{}); !errors.Is(err, ErrBadHandshake) || response.StatusCode != http.StatusNotFound {
+		t.Errorf("dialing a server without websockets: %v", err)
+	}
+	if _, _, err := Dial(context.Background(), strings.Replace(url, "ws", "http", 1), nil, Options{}); err == nil {
+		t.Error("dialing an http url succeeded")
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	// the server accepts the connection but never answers the handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(2 * time.Second)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, err := Dial(ctx, "ws://"+listener.Addr().String(), nil, Options{}); err == nil || time.Since(start) > time.Second {
+		t.Errorf("dial returned %v after %s", err, time.Since(start))
+	}
+}
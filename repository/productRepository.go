@@ -1,13 +1,15 @@
 package repository
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"log"
 
 	"github.com/amupxm/pure-webserver/constants"
 	"github.com/amupxm/pure-webserver/domain"
 	"github.com/amupxm/pure-webserver/pkg/database"
+	"github.com/amupxm/pure-webserver/pkg/jsonpatch"
+	"github.com/amupxm/pure-webserver/pkg/logger"
 )
 
 // import (
@@ -19,33 +21,45 @@ type (
 	// ProductRepository is the interface for product repository
 	ProductRepository interface {
 		// CreateProduct writes a new product to the database
-		CreateProduct(product *domain.Product) (*domain.Product, error)
+		CreateProduct(ctx context.Context, product *domain.Product) (*domain.Product, error)
 		// GetProductByID gets a product by id
-		GetProductByID(id string) ([]domain.Product, error)
+		GetProductByID(ctx context.Context, id string) ([]domain.Product, error)
 		// GetAllProducts gets all products
-		GetAllProducts(product *domain.Product) (*[]domain.Product, error)
-		// UpdateProduct updates a product
-		UpdateProduct(product *domain.Product) (*domain.Product, error)
-		// DeleteProduct deletes a product
-		DeleteProduct(iid string) error
+		GetAllProducts(ctx context.Context, product *domain.Product) (*[]domain.Product, error)
+		// UpdateProduct replaces the stored products with product.Iid, non nil versions must match the stored ones
+		UpdateProduct(ctx context.Context, product *domain.Product, versions map[string]int64) (*domain.Product, error)
+		// PatchProduct atomically applies a patch to the stored products with iid, non nil versions must match the stored ones
+		PatchProduct(ctx context.Context, iid string, versions map[string]int64, patch jsonpatch.Patcher) (*domain.Product, error)
+		// DeleteProduct atomically deletes the stored products with iid, non nil versions must match the stored ones
+		DeleteProduct(ctx context.Context, iid string, versions map[string]int64) error
+		// SearchProducts gets products matching a full-text query
+		SearchProducts(ctx context.Context, query string) (*[]domain.Product, error)
+		// BulkWrite applies create, update and delete operations with a single database write
+		BulkWrite(ctx context.Context, operations []domain.BulkOperation, atomic bool) ([]domain.BulkResult, error)
+		// ProductChanges gets at most limit changes of products after fromSeq, oldest first
+		ProductChanges(ctx context.Context, fromSeq int64, limit int) ([]domain.ProductChange, error)
 	}
 	productRepository struct {
 		db database.Database
 	}
 )
 
-// NewProductRepository creates a new product repository
-func NewProductRepository(db database.Database) ProductRepository {
+// NewProductRepository creates a new product repository, the last changeLogRetention changes of products
+// are kept (all of them when zero)
+func NewProductRepository(db database.Database, changeLogRetention int) ProductRepository {
+	var product *domain.Product
+	db.IndexFields(product, "name", "brand", "company")
+	db.EnableChangeLog(product, changeLogRetention)
 	return &productRepository{
 		db: db,
 	}
 }
 
 // GetProductByID gets a product by id
-func (pl *productRepository) GetProductByID(id string) ([]domain.Product, error) {
+func (pl *productRepository) GetProductByID(ctx context.Context, id string) ([]domain.Product, error) {
 	var product *domain.Product
 	var result []domain.Product
-	products, err := pl.db.GetFromCollection(product)
+	products, err := pl.db.GetFromCollection(ctx, product)
 	if err != nil {
 		return result, err
 	}
@@ -55,11 +69,11 @@ func (pl *productRepository) GetProductByID(id string) ([]domain.Product, error)
 
 	}
 	productFilteredList := products.Where("iid", id)
-	log.Print(productFilteredList)
+	logger.Default().Debug("products found by iid", logger.Fields{"iid": id, "count": len(*productFilteredList)})
 	//marshal and unmarshal to get the struct
 	s, err := json.Marshal(productFilteredList)
 	if err != nil {
-		log.Print(err)
+		logger.Default().Error("can not encode products", logger.Fields{"error": err})
 	}
 	err = json.Unmarshal(s, &result)
 	if err != nil {
@@ -69,21 +83,21 @@ func (pl *productRepository) GetProductByID(id string) ([]domain.Product, error)
 }
 
 // CreateProduct creates a new product
-func (pl *productRepository) CreateProduct(product *domain.Product) (*domain.Product, error) {
-	err := pl.db.WriteToCollection(product)
+func (pl *productRepository) CreateProduct(ctx context.Context, product *domain.Product) (*domain.Product, error) {
+	err := pl.db.WriteToCollection(ctx, product)
 	return product, err
 }
 
 // GetAllProducts gets all products
-func (pl *productRepository) GetAllProducts(product *domain.Product) (*[]domain.Product, error) {
+func (pl *productRepository) GetAllProducts(ctx context.Context, product *domain.Product) (*[]domain.Product, error) {
 	var result *[]domain.Product
-	res, err := pl.db.GetFromCollection(product)
+	res, err := pl.db.GetFromCollection(ctx, product)
 	if err != nil {
 		return result, err
 	}
 	s, err := json.Marshal(res)
 	if err != nil {
-		log.Print(err)
+		logger.Default().Error("can not encode products", logger.Fields{"error": err})
 	}
 	err = json.Unmarshal(s, &result)
 	if err != nil {
@@ -92,64 +106,214 @@ func (pl *productRepository) GetAllProducts(product *domain.Product) (*[]domain.
 	return result, err
 }
 
-// UpdateProduct updates a product
-func (pl *productRepository) UpdateProduct(product *domain.Product) (*domain.Product, error) {
-	var allProducts *[]domain.Product
-	res, err := pl.db.GetFromCollection(product)
+// UpdateProduct replaces name, brand and company of the stored products with product.Iid in a single write,
+// non nil versions must map the id of every stored product to its version or database.ErrVersionConflict is returned
+func (pl *productRepository) UpdateProduct(ctx context.Context, product *domain.Product, versions map[string]int64) (*domain.Product, error) {
+	docs, err := pl.writeProducts(ctx, product.Iid, database.BatchOperation{
+		Kind:     database.BatchModify,
+		Versions: versions,
+		Modify: func(doc map[string]interface{}) (map[string]interface{}, error) {
+			doc["name"] = product.Name
+			doc["brand"] = product.Brand
+			doc["company"] = product.Company
+			return doc, nil
+		},
+	})
 	if err != nil {
 		return product, err
 	}
-	s, err := json.Marshal(res)
+	var updated domain.Product
+	err = decodeLast(docs, &updated)
+	return &updated, err
+}
+
+// PatchProduct applies a patch to the stored products with iid in a single write,
+// non nil versions must map the id of every stored product to its version or database.ErrVersionConflict is returned
+func (pl *productRepository) PatchProduct(ctx context.Context, iid string, versions map[string]int64, patch jsonpatch.Patcher) (*domain.Product, error) {
+	docs, err := pl.writeProducts(ctx, iid, database.BatchOperation{
+		Kind:     database.BatchModify,
+		Versions: versions,
+		Modify:   patchDocument(iid, patch),
+	})
 	if err != nil {
-		log.Print(err)
+		return nil, err
+	}
+	var updated domain.Product
+	err = decodeLast(docs, &updated)
+	return &updated, err
+}
+
+// DeleteProduct deletes the stored products with iid in a single write,
+// non nil versions must map the id of every stored product to its version or database.ErrVersionConflict is returned
+func (pl *productRepository) DeleteProduct(ctx context.Context, iid string, versions map[string]int64) error {
+	_, err := pl.writeProducts(ctx, iid, database.BatchOperation{
+		Kind:     database.BatchRemove,
+		Versions: versions,
+	})
+	return err
+}
+
+// writeProducts applies operation to every stored product with iid as one atomic batch,
+// so a failure on one of them leaves all of them unchanged
+func (pl *productRepository) writeProducts(ctx context.Context, iid string, operation database.BatchOperation) ([]map[string]interface{}, error) {
+	var product *domain.Product
+	operation.Match = func(doc map[string]interface{}) bool {
+		return doc["iid"] == iid
+	}
+	results, err := pl.db.ExecuteBatch(ctx, product, []database.BatchOperation{operation}, true)
+	if len(results) == 1 && results[0].Err != nil {
+		err = results[0].Err
+	}
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, errors.New(constants.NoData)
 	}
-	err = json.Unmarshal(s, &allProducts)
 	if err != nil {
-		return product, err
+		return nil, err
 	}
-	var tempProducts []domain.Product
-	// find product by same iid
-	for _, v := range *allProducts {
-		if v.Iid == product.Iid {
-			// update product
-			v.Name = product.Name
-			v.Brand = product.Brand
-			v.Company = product.Company
-			v.UpdatedAt = product.UpdatedAt
-			product = &v
-		}
-		tempProducts = append(tempProducts, v)
+	return results[0].Docs, nil
+}
+
+// decodeLast fills product from the last of docs
+func decodeLast(docs []map[string]interface{}, product *domain.Product) error {
+	if len(docs) == 0 {
+		return errors.New(constants.NoData)
 	}
-	err = pl.db.UpdateCollection(&tempProducts)
+	s, err := json.Marshal(docs[len(docs)-1])
 	if err != nil {
-		return product, err
+		return err
 	}
-	return product, nil
+	return json.Unmarshal(s, product)
 }
 
-// DeleteProduct deletes a product
-func (pl *productRepository) DeleteProduct(iid string) error {
-	var allProducts *[]domain.Product
+// SearchProducts gets products matching a full-text query ordered by relevance
+func (pl *productRepository) SearchProducts(ctx context.Context, query string) (*[]domain.Product, error) {
 	var product *domain.Product
-	res, err := pl.db.GetFromCollection(product)
+	result := &[]domain.Product{}
+	res, err := pl.db.Search(ctx, product, query)
 	if err != nil {
-		return err
+		return result, err
 	}
 	s, err := json.Marshal(res)
 	if err != nil {
-		log.Print(err)
+		return result, err
 	}
-	err = json.Unmarshal(s, &allProducts)
-	if err != nil {
-		return err
+	err = json.Unmarshal(s, result)
+	return result, err
+}
+
+// BulkWrite applies create, update and delete operations in order with a single database write,
+// with atomic set a failing operation rolls back every other one
+func (pl *productRepository) BulkWrite(ctx context.Context, operations []domain.BulkOperation, atomic bool) ([]domain.BulkResult, error) {
+	var product *domain.Product
+	results := make([]domain.BulkResult, len(operations))
+	var batch []database.BatchOperation
+	// positions maps batch operations back to their bulk operation
+	var positions []int
+	for i, operation := range operations {
+		iid := operation.Iid
+		batchOperation := database.BatchOperation{
+			Version: operation.Version,
+			Match: func(doc map[string]interface{}) bool {
+				return doc["iid"] == iid
+			},
+		}
+		switch operation.Op {
+		case domain.BulkCreate:
+			var created domain.Product
+			if err := json.Unmarshal(operation.Product, &created); err != nil {
+				results[i].Err = errors.New(constants.BadData)
+				continue
+			}
+			created.Iid = iid
+			batchOperation.Kind = database.BatchInsert
+			batchOperation.Data = &created
+		case domain.BulkUpdate:
+			patch, err := jsonpatch.DecodeMergePatch(operation.Product)
+			if err != nil {
+				results[i].Err = errors.New(constants.BadData)
+				continue
+			}
+			batchOperation.Kind = database.BatchModify
+			batchOperation.Modify = patchDocument(iid, patch)
+		case domain.BulkDelete:
+			batchOperation.Kind = database.BatchRemove
+		default:
+			results[i].Err = errors.New(constants.BadData)
+			continue
+		}
+		batch = append(batch, batchOperation)
+		positions = append(positions, i)
 	}
-	var tempProducts []domain.Product
-	// find product by same iid
-	for _, v := range *allProducts {
-		if v.Iid != iid {
-			tempProducts = append(tempProducts, v)
+	if atomic && len(positions) != len(operations) {
+		for _, i := range positions {
+			results[i].Err = database.ErrBatchAborted
 		}
+		return results, database.ErrBatchAborted
 	}
-	err = pl.db.UpdateCollection(&tempProducts)
-	return err
+
+	batchResults, err := pl.db.ExecuteBatch(ctx, product, batch, atomic)
+	if err != nil && !errors.Is(err, database.ErrBatchAborted) {
+		return nil, err
+	}
+	for j, batchResult := range batchResults {
+		i := positions[j]
+		results[i].Err = batchResult.Err
+		s, marshalErr := json.Marshal(batchResult.Docs)
+		if marshalErr != nil {
+			return nil, marshalErr
+		}
+		if marshalErr = json.Unmarshal(s, &results[i].Products); marshalErr != nil {
+			return nil, marshalErr
+		}
+	}
+	return results, err
+}
+
+// patchDocument returns a database modification applying patch to a stored product, patches which do not
+// result in an object are rejected. The result is decoded to the model to reject wrong types and drop unknown fields
+func patchDocument(iid string, patch jsonpatch.Patcher) func(doc map[string]interface{}) (map[string]interface{}, error) {
+	return func(doc map[string]interface{}) (map[string]interface{}, error) {
+		patched, err := patch.Apply(doc)
+		if err != nil {
+			return nil, err
+		}
+		// a patch replacing the product by null or a scalar would wipe every field
+		if _, ok := patched.(map[string]interface{}); !ok {
+			return nil, errors.New(constants.BadData)
+		}
+		var result domain.Product
+		s, err := json.Marshal(patched)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(s, &result)
+		if err != nil {
+			return nil, errors.New(constants.BadData)
+		}
+		// iid can not be changed by a patch
+		result.Iid = iid
+		var next map[string]interface{}
+		s, err = json.Marshal(result)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(s, &next)
+		return next, err
+	}
+}
+
+// ProductChanges gets at most limit changes of products after fromSeq from the change log, oldest first
+func (pl *productRepository) ProductChanges(ctx context.Context, fromSeq int64, limit int) ([]domain.ProductChange, error) {
+	var product *domain.Product
+	result := []domain.ProductChange{}
+	changes, err := pl.db.Changes(ctx, product, fromSeq, limit)
+	if err != nil {
+		return result, err
+	}
+	s, err := json.Marshal(changes)
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(s, &result)
+	return result, err
 }
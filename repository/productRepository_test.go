@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/amupxm/pure-webserver/domain"
+	"github.com/amupxm/pure-webserver/pkg/database"
+	"github.com/amupxm/pure-webserver/pkg/jsonpatch"
+)
+
+// newTestRepository returns a repository over a temporary database holding two products with iid "toy"
+func newTestRepository(t *testing.T) ProductRepository {
+	t.Helper()
+	db := database.NewDatabase(database.WithBucketName(filepath.Join(t.TempDir(), "database.json")))
+	repository := NewProductRepository(db, 0)
+	for _, name := range []string{"first", "second"} {
+		if _, err := repository.CreateProduct(context.Background(), &domain.Product{Name: name, Brand: "brand", Iid: "toy"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repository
+}
+
+// storedProducts returns the products with iid "toy"
+func storedProducts(t *testing.T, repository ProductRepository) []domain.Product {
+	t.Helper()
+	products, err := repository.GetProductByID(context.Background(), "toy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return products
+}
+
+// storedVersions maps the ids of the products with iid "toy" to their versions
+func storedVersions(t *testing.T, repository ProductRepository) map[string]int64 {
+	t.Helper()
+	versions := make(map[string]int64)
+	for _, product := range storedProducts(t, repository) {
+		versions[product.Id] = product.Version
+	}
+	return versions
+}
+
+func TestPatchProductWritesEveryCopy(t *testing.T) {
+	repository := newTestRepository(t)
+	patch, _ := jsonpatch.DecodeMergePatch([]byte(`{"brand":"patched"}`))
+	if _, err := repository.PatchProduct(context.Background(), "toy", nil, patch); err != nil {
+		t.Fatal(err)
+	}
+	for _, product := range storedProducts(t, repository) {
+		if product.Brand != "patched" || product.Version != 2 {
+			t.Errorf("product %s has brand %q and version %d, want patched and 2", product.Id, product.Brand, product.Version)
+		}
+	}
+}
+
+func TestPatchProductIsAtomic(t *testing.T) {
+	empty, _ := jsonpatch.DecodeMergePatch([]byte(`{}`))
+	tests := []struct {
+		name     string
+		versions map[string]int64
+		patch    jsonpatch.Patcher
+		err      error
+	}{
+		// both copies have version 1, the second one fails the test operation
+		{"failed test", nil, jsonpatch.Patch{{Op: "test", Path: "/name", Value: []byte(`"first"`)}}, jsonpatch.ErrTestFailed},
+		{"version conflict", map[string]int64{"1": 1, "2": 7}, empty, database.ErrVersionConflict},
+		{"copy missing from versions", map[string]int64{"1": 1}, empty, database.ErrVersionConflict},
+		{"removed copy in versions", map[string]int64{"1": 1, "2": 1, "3": 1}, empty, database.ErrVersionConflict},
+	}
+	for _, test := range tests {
+		repository := newTestRepository(t)
+		_, err := repository.PatchProduct(context.Background(), "toy", test.versions, test.patch)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: error %v, want %v", test.name, err, test.err)
+		}
+		for _, product := range storedProducts(t, repository) {
+			if product.Version != 1 {
+				t.Errorf("%s: product %s was written to version %d", test.name, product.Id, product.Version)
+			}
+		}
+	}
+}
+
+func TestPatchProductRejectsNonObjectMergePatch(t *testing.T) {
+	for _, body := range []string{`null`, `"name"`, `[]`, `1`} {
+		repository := newTestRepository(t)
+		patch, err := jsonpatch.DecodeMergePatch([]byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repository.PatchProduct(context.Background(), "toy", nil, patch); err == nil {
+			t.Errorf("merge patch %s was applied", body)
+		}
+		for _, product := range storedProducts(t, repository) {
+			if product.Name == "" || product.Brand != "brand" {
+				t.Errorf("merge patch %s wiped product %s", body, product.Id)
+			}
+		}
+	}
+}
+
+func TestUpdateProductReplacesEveryCopy(t *testing.T) {
+	repository := newTestRepository(t)
+	read := storedVersions(t, repository)
+	updated, err := repository.UpdateProduct(context.Background(), &domain.Product{Iid: "toy", Name: "new", Brand: "b", Company: "c"}, read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Name != "new" || updated.Version != 2 {
+		t.Errorf("updated product is %+v", updated)
+	}
+	for _, product := range storedProducts(t, repository) {
+		if product.Name != "new" || product.Company != "c" {
+			t.Errorf("product %s was not replaced: %+v", product.Id, product)
+		}
+	}
+	_, err = repository.UpdateProduct(context.Background(), &domain.Product{Iid: "toy", Name: "newer"}, read)
+	if !errors.Is(err, database.ErrVersionConflict) {
+		t.Errorf("update of an old version error = %v, want %v", err, database.ErrVersionConflict)
+	}
+}
+
+func TestDeleteProduct(t *testing.T) {
+	repository := newTestRepository(t)
+	read := storedVersions(t, repository)
+	// another copy written after the versions were read fails the delete
+	if _, err := repository.CreateProduct(context.Background(), &domain.Product{Name: "third", Iid: "toy"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.DeleteProduct(context.Background(), "toy", read); !errors.Is(err, database.ErrVersionConflict) {
+		t.Errorf("delete of another version error = %v, want %v", err, database.ErrVersionConflict)
+	}
+	if products := storedProducts(t, repository); len(products) != 3 {
+		t.Fatalf("a failed delete removed products, %d are left", len(products))
+	}
+	if err := repository.DeleteProduct(context.Background(), "toy", storedVersions(t, repository)); err != nil {
+		t.Fatal(err)
+	}
+	if products, _ := repository.GetProductByID(context.Background(), "toy"); len(products) != 0 {
+		t.Errorf("%d products are left after the delete", len(products))
+	}
+	if err := repository.DeleteProduct(context.Background(), "toy", nil); err == nil {
+		t.Error("delete of a missing product succeeded")
+	}
+}